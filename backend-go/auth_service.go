@@ -3,84 +3,453 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	"omnixius-api/db"
+	"omnixius-api/internal/useragent"
 	"omnixius-api/pqc"
 
 	"github.com/gin-gonic/gin"
 )
 
-// createSession inserts a session row and returns (sessionID, expiresAt). Caller uses SignTokenWithSession.
-func createSession(userID int64, deviceName string) (sessionID int64, expiresAt time.Time) {
-	exp := time.Now().Add(7 * 24 * time.Hour)
-	res, err := db.DB.Exec(
-		"INSERT INTO sessions (user_id, device_name, expires_at) VALUES (?, ?, ?)",
-		userID, deviceName, exp.Unix(),
+// SessionTokens is what a client gets on login and on refresh. Token is a short-lived signed access token
+// (ExpiresIn seconds); RefreshToken is opaque, single-use and exchanged at POST /auth/refresh.
+type SessionTokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// withTokens adds the token fields to a response body.
+func withTokens(h gin.H, t SessionTokens) gin.H {
+	h["token"] = t.Token
+	h["refresh_token"] = t.RefreshToken
+	h["expires_in"] = t.ExpiresIn
+	return h
+}
+
+var (
+	ErrRefreshInvalid = errors.New("invalid or expired refresh token")
+	ErrRefreshReused  = errors.New("refresh token reuse detected")
+)
+
+// ClientInfo describes the client opening a session; it is stored on the session row.
+type ClientInfo struct {
+	IP           string
+	UserAgent    string
+	CredentialID int64 // webauthn_credentials.id for passkey sign-ins
+}
+
+// clientInfo returns the ClientInfo of the request.
+func clientInfo(c *gin.Context) ClientInfo {
+	return ClientInfo{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+}
+
+// sessionTouchInterval throttles sessions.last_used_at writes from authenticated requests.
+const sessionTouchInterval = 5 * time.Minute
+
+// issueSession inserts a session row (one refresh-token family) and returns its first access and refresh tokens.
+func issueSession(userID int64, deviceName string, client ClientInfo) (SessionTokens, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	defer tx.Rollback()
+	sessionID, refresh, err := openSessionTx(tx, userID, deviceName, client)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return SessionTokens{}, err
+	}
+	noteLoginDevice(userID, sessionID, deviceName, client)
+	return signSessionTokens(userID, sessionID, refresh)
+}
+
+// openSessionTx inserts a session row with its first refresh token and returns both; the caller commits,
+// then calls noteLoginDevice and signSessionTokens.
+func openSessionTx(tx *sql.Tx, userID int64, deviceName string, client ClientInfo) (int64, string, error) {
+	ua := client.UserAgent
+	if len(ua) > 512 {
+		ua = ua[:512]
+	}
+	now := time.Now()
+	res, err := tx.Exec(
+		"INSERT INTO sessions (user_id, device_name, ip, user_agent, client_type, credential_id, created_at, last_used_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, deviceName, nullStr(client.IP), nullStr(ua), useragent.Parse(ua).Device, nullInt64(client.CredentialID),
+		now.Unix(), now.Unix(), now.Add(cfg.RefreshTokenTTL).Unix(),
 	)
 	if err != nil {
-		return 0, exp
+		return 0, "", err
+	}
+	sessionID, _ := res.LastInsertId()
+	refresh, err := newRefreshToken(tx, sessionID)
+	if err != nil {
+		return 0, "", err
 	}
-	sid, _ := res.LastInsertId()
-	return sid, exp
+	return sessionID, refresh, nil
+}
+
+func signSessionTokens(userID, sessionID int64, refresh string) (SessionTokens, error) {
+	var role string
+	db.DB.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role)
+	access, err := signAccessToken(pqc.Claims{
+		UserID: userID, SessionID: sessionID, Role: role, ExpiresAt: time.Now().Add(cfg.AccessTokenTTL).Unix(),
+	})
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	return SessionTokens{Token: access, RefreshToken: refresh, ExpiresIn: int64(cfg.AccessTokenTTL / time.Second)}, nil
+}
+
+// newRefreshToken stores the hash of a fresh random token for the session and returns the token.
+func newRefreshToken(tx *sql.Tx, sessionID int64) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	tok := base64.RawURLEncoding.EncodeToString(b)
+	_, err := tx.Exec("INSERT INTO session_refresh_tokens (token_hash, session_id, created_at) VALUES (?, ?, ?)",
+		hashRefreshToken(tok), sessionID, time.Now().Unix())
+	return tok, err
+}
+
+func hashRefreshToken(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:])
+}
+
+// revokeOtherSessions deletes every session of the user except keepSessionID (0 keeps none), with their refresh
+// tokens, and returns how many were revoked.
+func revokeOtherSessions(userID, keepSessionID int64) (int64, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM session_refresh_tokens WHERE session_id IN (SELECT id FROM sessions WHERE user_id = ? AND id != ?)", userID, keepSessionID); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("DELETE FROM sessions WHERE user_id = ? AND id != ?", userID, keepSessionID)
+	if err != nil {
+		return 0, err
+	}
+	return mustRows(res), tx.Commit()
+}
+
+// revokeAllAccessTx signs the user out everywhere: every session with its refresh tokens, every active OAuth
+// grant and every personal access token. It returns the number of grants revoked; by is recorded on them.
+// Callers close WebSocket connections (DisconnectUser) after commit.
+func revokeAllAccessTx(tx *sql.Tx, userID, by int64) (int, error) {
+	var grants []int64
+	rows, err := tx.Query("SELECT id FROM oauth_grants WHERE user_id = ? AND revoked_at IS NULL", userID)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			grants = append(grants, id)
+		}
+	}
+	rows.Close()
+	for _, id := range grants {
+		if err := revokeGrantTx(tx, id, by); err != nil {
+			return 0, err
+		}
+	}
+	for _, q := range []string{
+		"DELETE FROM session_refresh_tokens WHERE session_id IN (SELECT id FROM sessions WHERE user_id = ?)",
+		"DELETE FROM sessions WHERE user_id = ?",
+	} {
+		if _, err := tx.Exec(q, userID); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.Exec("UPDATE personal_access_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", time.Now().Unix(), userID); err != nil {
+		return 0, err
+	}
+	return len(grants), nil
+}
+
+// AuthRefresh exchanges a refresh token for a new access token and the next refresh token, and extends the
+// session. A refresh token already used once means it leaked: the whole session is revoked (ErrRefreshReused).
+func AuthRefresh(refreshToken string) (SessionTokens, int64, error) {
+	if refreshToken == "" {
+		return SessionTokens{}, 0, ErrRefreshInvalid
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return SessionTokens{}, 0, err
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	var sessionID, userID, expiresAt int64
+	var usedAt sql.NullInt64
+	err = tx.QueryRow(
+		`SELECT t.session_id, t.used_at, s.user_id, s.expires_at FROM session_refresh_tokens t
+		 JOIN sessions s ON s.id = t.session_id WHERE t.token_hash = ?`,
+		hashRefreshToken(refreshToken),
+	).Scan(&sessionID, &usedAt, &userID, &expiresAt)
+	if err != nil {
+		return SessionTokens{}, 0, ErrRefreshInvalid
+	}
+	if usedAt.Valid {
+		tx.Exec("DELETE FROM session_refresh_tokens WHERE session_id = ?", sessionID)
+		tx.Exec("DELETE FROM sessions WHERE id = ?", sessionID)
+		if err := tx.Commit(); err != nil {
+			return SessionTokens{}, 0, err
+		}
+		auditLog(userID, "session.refresh_reused", "session", strconv.FormatInt(sessionID, 10), "")
+		return SessionTokens{}, userID, ErrRefreshReused
+	}
+	if expiresAt <= now.Unix() {
+		return SessionTokens{}, 0, ErrRefreshInvalid
+	}
+	if err := checkBan(userID); err != nil {
+		return SessionTokens{}, userID, err
+	}
+	if _, err := tx.Exec("UPDATE session_refresh_tokens SET used_at = ? WHERE token_hash = ?", now.Unix(), hashRefreshToken(refreshToken)); err != nil {
+		return SessionTokens{}, 0, err
+	}
+	if _, err := tx.Exec("UPDATE sessions SET expires_at = ?, last_used_at = ? WHERE id = ?", now.Add(cfg.RefreshTokenTTL).Unix(), now.Unix(), sessionID); err != nil {
+		return SessionTokens{}, 0, err
+	}
+	next, err := newRefreshToken(tx, sessionID)
+	if err != nil {
+		return SessionTokens{}, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return SessionTokens{}, 0, err
+	}
+	tokens, err := signSessionTokens(userID, sessionID, next)
+	return tokens, userID, err
 }
 
 var (
 	ErrEmailExists         = errors.New("email already registered")
 	ErrInvalidCredentials  = errors.New("invalid email or password")
 	ErrRegistrationFailed  = errors.New("registration failed")
+	ErrUserBanned          = errors.New("account suspended")
 )
 
-// AuthRegister creates a user and returns user map and token. Caller must validate input length and min password.
-func AuthRegister(email, password, name string) (user gin.H, token string, err error) {
+// BanError describes an active admin ban. errors.Is(err, ErrUserBanned) is true for it.
+type BanError struct {
+	Reason    string
+	ExpiresAt int64 // 0 = permanent
+}
+
+func (e *BanError) Error() string { return ErrUserBanned.Error() }
+func (e *BanError) Unwrap() error { return ErrUserBanned }
+
+// JSON is the 403 body returned to banned users.
+func (e *BanError) JSON() gin.H {
+	h := gin.H{"error": "Account suspended", "reason": e.Reason}
+	if e.ExpiresAt > 0 {
+		h["banned_until"] = e.ExpiresAt
+	}
+	return h
+}
+
+// checkBan returns a *BanError if the user has an active (not lifted, not expired) ban and nil if not. A failed
+// lookup returns its error so callers fail closed.
+func checkBan(userID int64) error {
+	var reason string
+	var expiresAt sql.NullInt64
+	err := db.DB.QueryRow(
+		`SELECT reason, expires_at FROM admin_bans
+		 WHERE user_id = ? AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)
+		 ORDER BY created_at DESC LIMIT 1`,
+		userID, time.Now().Unix(),
+	).Scan(&reason, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ban lookup: %w", err)
+	}
+	return &BanError{Reason: reason, ExpiresAt: expiresAt.Int64}
+}
+
+// banJSON returns the 403 body for err if it is a ban, otherwise nil.
+func banJSON(err error) gin.H {
+	var be *BanError
+	if errors.As(err, &be) {
+		return be.JSON()
+	}
+	return nil
+}
+
+// loginRefusedJSON is the 403 body for an error from checkLoginAllowed, or nil for any other error.
+func loginRefusedJSON(err error) gin.H {
+	if errors.Is(err, ErrPasswordResetRequired) {
+		return gin.H{"error": err.Error(), "code": "password_reset_required"}
+	}
+	return banJSON(err)
+}
+
+// Email verification modes (EMAIL_VERIFICATION).
+const (
+	EmailVerificationOff      = "off"      // new accounts are verified at once, no confirmation email
+	EmailVerificationSoft     = "soft"     // confirmation email sent; unverified accounts only lack the verified badge
+	EmailVerificationRequired = "required" // unverified accounts cannot sell, order or transfer
+)
+
+// emailResendInterval is the minimum time between confirmation emails for one account.
+const emailResendInterval = time.Minute
+
+var (
+	ErrVerifyTokenInvalid   = errors.New("invalid verification token")
+	ErrVerifyTokenExpired   = errors.New("verification token expired")
+	ErrEmailAlreadyVerified = errors.New("email already verified")
+	ErrVerifyResendTooSoon  = errors.New("confirmation email sent recently")
+	ErrEmailNotVerified     = errors.New("email not verified")
+)
+
+func newEmailVerifyToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AuthConfirmEmail marks the account owning token as verified.
+func AuthConfirmEmail(token string) error {
+	var id int64
+	var expires sql.NullInt64
+	if db.DB.QueryRow("SELECT id, email_verify_expires FROM users WHERE email_verify_token = ?", token).Scan(&id, &expires) != nil {
+		return ErrVerifyTokenInvalid
+	}
+	if !expires.Valid || expires.Int64 <= time.Now().Unix() {
+		return ErrVerifyTokenExpired
+	}
+	_, err := db.DB.Exec("UPDATE users SET email_verified = 1, email_verify_token = NULL, email_verify_expires = NULL WHERE id = ?", id)
+	return err
+}
+
+// AuthResendConfirmEmail issues a new confirmation token (the old one stops working) and emails it.
+func AuthResendConfirmEmail(userID int64) error {
+	var email string
+	var name sql.NullString
+	var verified int
+	var sentAt sql.NullInt64
+	err := db.DB.QueryRow("SELECT email, name, COALESCE(email_verified, 0), email_verify_sent_at FROM users WHERE id = ?", userID).
+		Scan(&email, &name, &verified, &sentAt)
+	if err != nil {
+		return err
+	}
+	if verified == 1 {
+		return ErrEmailAlreadyVerified
+	}
+	now := time.Now()
+	if sentAt.Valid && now.Unix()-sentAt.Int64 < int64(emailResendInterval/time.Second) {
+		return ErrVerifyResendTooSoon
+	}
+	token := newEmailVerifyToken()
+	if _, err := db.DB.Exec("UPDATE users SET email_verify_token = ?, email_verify_expires = ?, email_verify_sent_at = ? WHERE id = ?",
+		token, now.Add(cfg.EmailVerifyTTL).Unix(), now.Unix(), userID); err != nil {
+		return err
+	}
+	sendConfirmEmail(email, name.String, token)
+	return nil
+}
+
+// AuthRegister creates a user and returns user map and session tokens. Caller must validate input length and min password.
+func AuthRegister(email, password, name string, client ClientInfo) (user gin.H, tokens SessionTokens, err error) {
 	email = strings.TrimSpace(strings.ToLower(email))
 	var id int64
 	if db.DB.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&id) == nil {
-		return nil, "", ErrEmailExists
+		return nil, SessionTokens{}, ErrEmailExists
 	}
 	hash := hashPasswordArgon2(password)
-	verifyToken := make([]byte, 32)
-	rand.Read(verifyToken)
-	// Beta: email_verified = 1 (no confirmation). Later: set 0 and send confirm link.
-	res, err := db.DB.Exec(
-		"INSERT INTO users (email, password_hash, name, role, email_verify_token, email_verified) VALUES (?, ?, ?, 'user', ?, 1)",
-		email, hash, nullStr(name), hex.EncodeToString(verifyToken),
-	)
+	var res sql.Result
+	if cfg.EmailVerification == EmailVerificationOff {
+		res, err = db.DB.Exec(
+			"INSERT INTO users (email, password_hash, name, role, email_verified) VALUES (?, ?, ?, 'user', 1)",
+			email, hash, nullStr(name),
+		)
+	} else {
+		verifyToken := newEmailVerifyToken()
+		now := time.Now()
+		res, err = db.DB.Exec(
+			"INSERT INTO users (email, password_hash, name, role, email_verify_token, email_verify_expires, email_verify_sent_at, email_verified) VALUES (?, ?, ?, 'user', ?, ?, ?, 0)",
+			email, hash, nullStr(name), verifyToken, now.Add(cfg.EmailVerifyTTL).Unix(), now.Unix(),
+		)
+		if err == nil {
+			sendConfirmEmail(email, name, verifyToken)
+		}
+	}
 	if err != nil {
-		return nil, "", ErrRegistrationFailed
+		return nil, SessionTokens{}, ErrRegistrationFailed
 	}
 	id, _ = res.LastInsertId()
-	sessionID, exp := createSession(id, "web")
-	token, _ = pqc.SignTokenWithSession(cfg.PQCPrivateKey, id, sessionID, exp)
+	tokens, err = issueSession(id, "web", client)
+	if err != nil {
+		return nil, SessionTokens{}, err
+	}
 	user = gin.H{"id": id, "email": email, "role": "user", "name": name}
-	return user, token, nil
+	return user, tokens, nil
 }
 
-// AuthLogin returns user map and token. Caller must enforce rate limit.
-func AuthLogin(email, password string) (user gin.H, token string, err error) {
-	email = strings.TrimSpace(strings.ToLower(email))
+// authenticatePassword returns the user for email and password if the account is not banned and no password
+// reset is pending (ErrPasswordResetRequired). It does not check the second factor.
+func authenticatePassword(email, password string) (int64, error) {
 	var id int64
 	var hash string
-	var role, name string
+	err := db.DB.QueryRow("SELECT id, password_hash FROM users WHERE email = ?", strings.TrimSpace(strings.ToLower(email))).Scan(&id, &hash)
+	if err != nil || !checkPassword(hash, password) {
+		return 0, ErrInvalidCredentials
+	}
+	if err := checkLoginAllowed(id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// AuthLogin returns user map and session tokens. Caller must enforce rate limit. If the user has two-factor
+// authentication enabled it returns a *MFARequiredError instead; finish with AuthLoginMFA.
+func AuthLogin(email, password string, client ClientInfo) (user gin.H, tokens SessionTokens, err error) {
+	id, err := authenticatePassword(email, password)
+	if err != nil {
+		return nil, SessionTokens{}, err
+	}
+	if mfaEnabled(id) {
+		challenge, err := createMFAChallenge(id)
+		if err != nil {
+			return nil, SessionTokens{}, err
+		}
+		return nil, SessionTokens{}, &MFARequiredError{Token: challenge, ExpiresIn: int64(mfaChallengeTTL / time.Second)}
+	}
+	return completeLogin(id, client)
+}
+
+// completeLogin returns the login response user map and a new web session for a fully authenticated user.
+func completeLogin(id int64, client ClientInfo) (gin.H, SessionTokens, error) {
+	var email, role, name string
 	var avatar sql.NullString
 	var emailVerified, phoneVerified int
-	err = db.DB.QueryRow(
-		"SELECT id, password_hash, role, name, avatar_path, COALESCE(email_verified, 0), COALESCE(phone_verified, 0) FROM users WHERE email = ?",
-		email,
-	).Scan(&id, &hash, &role, &name, &avatar, &emailVerified, &phoneVerified)
-	if err != nil || !checkPassword(hash, password) {
-		return nil, "", ErrInvalidCredentials
+	err := db.DB.QueryRow(
+		"SELECT email, role, name, avatar_path, COALESCE(email_verified, 0), COALESCE(phone_verified, 0) FROM users WHERE id = ?",
+		id,
+	).Scan(&email, &role, &name, &avatar, &emailVerified, &phoneVerified)
+	if err != nil {
+		return nil, SessionTokens{}, ErrInvalidCredentials
 	}
 	verified := emailVerified == 1 || phoneVerified == 1
-	user = gin.H{
+	user := gin.H{
 		"id": id, "email": email, "role": role, "name": name,
 		"avatar_path": avatar.String, "email_verified": emailVerified == 1, "phone_verified": phoneVerified == 1, "verified": verified,
 	}
-	sessionID, exp := createSession(id, "web")
-	token, _ = pqc.SignTokenWithSession(cfg.PQCPrivateKey, id, sessionID, exp)
-	return user, token, nil
+	tokens, err := issueSession(id, "web", client)
+	if err != nil {
+		return nil, SessionTokens{}, err
+	}
+	return user, tokens, nil
 }
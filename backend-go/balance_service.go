@@ -1,36 +1,55 @@
-// Balance service: user balance (internal units). Stub for Trade — subscriptions, rewards, payouts later.
+// Balance service: the user's USD balance (GET /users/me/balance), backed by the wallet ledger main account.
+// Amounts in the API are units (e.g. 12.5 USD); the ledger stores minor units (cents).
 package main
 
 import (
+	"math"
+	"strconv"
+	"time"
+
 	"omnixius-api/db"
+	"omnixius-api/internal/ledger"
 
 	"github.com/gin-gonic/gin"
 )
 
-// BalanceGet returns current user balance (0 if no row).
+const (
+	balanceCurrency   = walletDefaultCurrency
+	balanceMinorUnits = 100
+)
+
+func balanceJSON(minor int64) gin.H {
+	return gin.H{"balance": float64(minor) / balanceMinorUnits, "balance_minor": minor, "currency": balanceCurrency}
+}
+
+// BalanceGet returns the user's available USD balance (0 if no account).
 func BalanceGet(userID int64) gin.H {
-	var balance float64
-	err := db.DB.QueryRow("SELECT balance FROM user_balances WHERE user_id = ?", userID).Scan(&balance)
-	if err != nil {
-		balance = 0
-	}
-	return gin.H{"balance": balance}
+	return balanceJSON(ledger.Balance(db.DB, ledger.UserMain(userID, balanceCurrency)))
 }
 
-// BalanceCredit adds amount to user balance (stub: test credit). Returns new balance.
+// BalanceCredit adds amount to user balance (stub: test credit, posted from the system funding account). Returns new balance.
 func BalanceCredit(userID int64, amount float64) (gin.H, error) {
-	if amount <= 0 {
-		return nil, nil // no-op
+	minor := int64(math.Round(amount * balanceMinorUnits))
+	if minor <= 0 {
+		return nil, ledger.ErrInvalidAmount
 	}
-	_, err := db.DB.Exec(
-		`INSERT INTO user_balances (user_id, balance, updated_at) VALUES (?, ?, unixepoch())
-		 ON CONFLICT(user_id) DO UPDATE SET balance = balance + excluded.balance, updated_at = unixepoch()`,
-		userID, amount,
-	)
+	tx, err := db.DB.Begin()
 	if err != nil {
 		return nil, err
 	}
-	var newBalance float64
-	db.DB.QueryRow("SELECT balance FROM user_balances WHERE user_id = ?", userID).Scan(&newBalance)
-	return gin.H{"balance": newBalance, "credited": amount}, nil
+	defer tx.Rollback()
+	entryID, err := ledger.Transfer(tx, "credit", "credit:"+strconv.FormatInt(userID, 10),
+		ledger.System(ledger.CodeFunding, balanceCurrency), ledger.UserMain(userID, balanceCurrency), minor)
+	if err != nil {
+		return nil, err
+	}
+	if err := walletStatement(tx, userID, "deposit", balanceCurrency, minor, "credit", entryID, time.Now().Unix()); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	h := BalanceGet(userID)
+	h["credited"] = float64(minor) / balanceMinorUnits
+	return h, nil
 }
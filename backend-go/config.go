@@ -2,12 +2,12 @@ package main
 
 import (
 	"encoding/base64"
-	"log"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"omnixius-api/pqc"
 )
@@ -27,12 +27,47 @@ type Config struct {
 	// Dilithium3 (post-quantum auth tokens)
 	PQCPublicKey  []byte
 	PQCPrivateKey []byte
+	PQCKeyEncryptionKey []byte // 32-byte AES key for private keys stored in pqc_keys; empty = stored unencrypted
+	PQCAlgorithm        string    // algorithm for new signing keys: ML-DSA-65 or ML-DSA-65+Ed25519
+	PQCLegacyUntil      time.Time // pre-envelope Dilithium3 tokens verify until then; zero = no cutoff
+	AccessTokenTTL  time.Duration // lifetime of signed access tokens
+	RefreshTokenTTL time.Duration // session lifetime; each refresh extends it
+	MFAEncryptionKey []byte // 32-byte AES key for TOTP secrets; empty = two-factor enrollment disabled
+	EmailVerification string        // off, soft or required (see EmailVerification* in auth_service.go)
+	EmailVerifyTTL    time.Duration // lifetime of email confirmation links
 	// WebAuthn (Passkeys)
 	WebAuthnRPID          string   // e.g. localhost or omnixius.com
 	WebAuthnRPDisplayName string   // e.g. OMNIXIUS
 	WebAuthnRPOrigins    []string // e.g. https://localhost:3000
 	// Stack: Rust first. Optional Rust service URL (video, search, heavy compute).
 	RustServiceURL string // e.g. http://localhost:8081; empty = do not call Rust
+	// Notifications dispatcher (§16)
+	NotifyPollInterval time.Duration // how often notifications_queue is polled for due rows
+	// Wallet (§15)
+	IdempotencyTTL    time.Duration // how long Idempotency-Key responses are kept for replay
+	HoldSweepInterval time.Duration // how often expired wallet_holds are released
+	// Domain events outbox (§1.6)
+	OutboxPollInterval time.Duration // how often the event relay polls event_outbox
+	OutboxMaxAttempts  int           // failed deliveries before an event is dead-lettered for a subscriber
+	OutboxRetention    time.Duration // delivered events older than this are pruned
+	// Email (§1.1, §16): SMTP when SMTPHost is set, otherwise messages are written to MailDir (Maildir layout)
+	MailFrom     string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	MailDir      string
+	// SMS (phone verification): SMSOutboxFile set = append messages there as JSON lines, otherwise log them
+	SMSOutboxFile string
+	// Push: PushOutboxFile set = append push messages there as JSON lines; unset = no provider, push rows fail
+	PushOutboxFile string
+	// Rate limits (requests per minute per user; per IP it is RateLimitIPMultiplier times as many). 0 = unlimited.
+	RateLimitAuth         int
+	RateLimitWallet       int
+	RateLimitWrite        int
+	RateLimitRead         int
+	RateLimitIPMultiplier int
+	RateLimitMaxKeys      int // keys held by the in-memory store before least recently used ones are evicted
 }
 
 func getEnvInt(key string, defaultVal int) int {
@@ -44,6 +79,13 @@ func getEnvInt(key string, defaultVal int) int {
 	return defaultVal
 }
 
+func getEnvStr(key, defaultVal string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultVal
+}
+
 func LoadConfig() Config {
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -74,6 +116,29 @@ func LoadConfig() Config {
 		Argon2Memory:    mem,
 		Argon2Threads:    2,
 		RustServiceURL:   rustURL,
+		AccessTokenTTL:     time.Duration(getEnvInt("ACCESS_TOKEN_MINUTES", 15)) * time.Minute,
+		RefreshTokenTTL:    time.Duration(getEnvInt("REFRESH_TOKEN_DAYS", 30)) * 24 * time.Hour,
+		NotifyPollInterval: time.Duration(getEnvInt("NOTIFY_POLL_SECONDS", 5)) * time.Second,
+		IdempotencyTTL:     time.Duration(getEnvInt("IDEMPOTENCY_TTL_HOURS", 24)) * time.Hour,
+		HoldSweepInterval:  time.Duration(getEnvInt("HOLD_SWEEP_SECONDS", 60)) * time.Second,
+		OutboxPollInterval: time.Duration(getEnvInt("OUTBOX_POLL_SECONDS", 2)) * time.Second,
+		OutboxMaxAttempts:  getEnvInt("OUTBOX_MAX_ATTEMPTS", 5),
+		OutboxRetention:    time.Duration(getEnvInt("OUTBOX_RETENTION_HOURS", 168)) * time.Hour,
+		EmailVerifyTTL:     time.Duration(getEnvInt("EMAIL_VERIFY_TOKEN_HOURS", 48)) * time.Hour,
+		MailFrom:           getEnvStr("MAIL_FROM", "OMNIXIUS <no-reply@omnixius.com>"),
+		SMTPHost:           os.Getenv("SMTP_HOST"),
+		SMTPPort:           getEnvInt("SMTP_PORT", 587),
+		SMTPUsername:       os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:       os.Getenv("SMTP_PASSWORD"),
+		MailDir:            getEnvStr("MAIL_DIR", filepath.Join("db", "mail")),
+		SMSOutboxFile:      os.Getenv("SMS_OUTBOX_FILE"),
+		PushOutboxFile:     os.Getenv("PUSH_OUTBOX_FILE"),
+		RateLimitAuth:         getEnvInt("RATE_LIMIT_AUTH", 20),
+		RateLimitWallet:       getEnvInt("RATE_LIMIT_WALLET", 30),
+		RateLimitWrite:        getEnvInt("RATE_LIMIT_WRITE", 60),
+		RateLimitRead:         getEnvInt("RATE_LIMIT_READ", 300),
+		RateLimitIPMultiplier: getEnvInt("RATE_LIMIT_IP_MULTIPLIER", 4),
+		RateLimitMaxKeys:      getEnvInt("RATE_LIMIT_MAX_KEYS", 100000),
 	}
 	// PQC keys from env (base64). Required for production.
 	if b, err := base64.StdEncoding.DecodeString(os.Getenv("DILITHIUM_PUBLIC_KEY")); err == nil && len(b) > 0 {
@@ -82,14 +147,24 @@ func LoadConfig() Config {
 	if b, err := base64.StdEncoding.DecodeString(os.Getenv("DILITHIUM_PRIVATE_KEY")); err == nil && len(b) > 0 {
 		cfg.PQCPrivateKey = b
 	}
-	if len(cfg.PQCPublicKey) == 0 || len(cfg.PQCPrivateKey) == 0 {
-		priv, pub, err := pqc.GenerateKey()
-		if err != nil {
-			log.Fatal("pqc.GenerateKey: ", err)
-		}
-		cfg.PQCPrivateKey = priv
-		cfg.PQCPublicKey = pub
-		log.Print("PQC: DILITHIUM_PUBLIC_KEY/DILITHIUM_PRIVATE_KEY not set; ephemeral keys generated (tokens invalid after restart). Set env in production.")
+	// Without env keys the signing key comes from pqc_keys (generated and stored on first start, see initKeyring).
+	if b, err := base64.StdEncoding.DecodeString(os.Getenv("PQC_KEY_ENCRYPTION_KEY")); err == nil && len(b) == 32 {
+		cfg.PQCKeyEncryptionKey = b
+	}
+	if b, err := base64.StdEncoding.DecodeString(os.Getenv("MFA_ENCRYPTION_KEY")); err == nil && len(b) == 32 {
+		cfg.MFAEncryptionKey = b
+	}
+	switch cfg.EmailVerification = os.Getenv("EMAIL_VERIFICATION"); cfg.EmailVerification {
+	case EmailVerificationOff, EmailVerificationRequired:
+	default:
+		cfg.EmailVerification = EmailVerificationSoft
+	}
+	cfg.PQCAlgorithm = pqc.AlgMLDSA65
+	if os.Getenv("PQC_ALGORITHM") == pqc.AlgMLDSA65Ed25519 {
+		cfg.PQCAlgorithm = pqc.AlgMLDSA65Ed25519
+	}
+	if until := getEnvInt("PQC_LEGACY_UNTIL", 0); until > 0 {
+		cfg.PQCLegacyUntil = time.Unix(int64(until), 0)
 	}
 	// WebAuthn: derive from AppURL if not set
 	if cfg.WebAuthnRPID == "" || len(cfg.WebAuthnRPOrigins) == 0 {
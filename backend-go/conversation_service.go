@@ -215,6 +215,17 @@ func MessageSend(convID int64, uid int64, body string) (gin.H, error) {
 	}
 	db.DB.Exec("UPDATE conversations SET updated_at = unixepoch() WHERE id = ?", convID)
 	mid, _ := res.LastInsertId()
+	ev := MessageSent{MessageID: mid, ConversationID: convID, SenderID: uid}
+	if rows, err := db.DB.Query("SELECT user_id FROM conversation_participants WHERE conversation_id = ? AND user_id != ?", convID, uid); err == nil {
+		for rows.Next() {
+			var rid int64
+			if rows.Scan(&rid) == nil {
+				ev.Recipients = append(ev.Recipients, rid)
+			}
+		}
+		rows.Close()
+	}
+	publishEvent(TopicMessageSent, uid, ev)
 	return gin.H{"id": mid, "conversation_id": convID, "sender_id": uid, "body": body, "created_at": time.Now().Unix()}, nil
 }
 
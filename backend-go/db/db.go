@@ -21,9 +21,15 @@ var migrationsFS embed.FS
 
 var DB *sql.DB
 
+// Open opens the SQLite DB and runs migrations. Transactions begin IMMEDIATE (write lock taken at BEGIN) and
+// wait up to 5s for a busy lock, so concurrent read-then-write transactions serialize instead of failing or interleaving.
 func Open(dbPath string) error {
 	var err error
-	DB, err = sql.Open("sqlite", dbPath)
+	sep := "?"
+	if strings.Contains(dbPath, "?") {
+		sep = "&"
+	}
+	DB, err = sql.Open("sqlite", dbPath+sep+"_pragma=busy_timeout(5000)&_txlock=immediate")
 	if err != nil {
 		return err
 	}
@@ -35,6 +41,19 @@ func Open(dbPath string) error {
 	return RunMigrations()
 }
 
+// stripComments drops full-line "--" comments so comment text (which may contain ';') is not split into statements.
+func stripComments(s string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
 func splitStatements(s string) []string {
 	var out []string
 	for _, part := range strings.Split(s, ";") {
@@ -51,6 +70,14 @@ func RunMigrations() error {
 	if err := DB.QueryRow("SELECT version FROM schema_version WHERE id = 1").Scan(&version); err != nil {
 		return fmt.Errorf("schema_version: %w", err)
 	}
+	// Older runners skipped every migration that started with a comment but still bumped the version.
+	// Those DBs have no sessions table (013); re-apply from the start (002–019 are idempotent).
+	if version >= 13 {
+		var n int
+		if DB.QueryRow("SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'sessions'").Scan(&n) != nil {
+			version = 1
+		}
+	}
 	entries, err := migrationsFS.ReadDir("migrations")
 	if err != nil {
 		return err
@@ -78,27 +105,38 @@ func RunMigrations() error {
 		if err != nil {
 			return fmt.Errorf("migration %s: %w", f.name, err)
 		}
-		sql := strings.TrimSpace(string(body))
-		if sql == "" || strings.HasPrefix(sql, "--") {
-			goto updateVersion
+		if err := applyMigration(f.n, stripComments(string(body))); err != nil {
+			return fmt.Errorf("migration %s: %w", f.name, err)
 		}
-		for _, stmt := range splitStatements(sql) {
-			stmt = strings.TrimSpace(stmt)
-			if stmt == "" { continue }
-			if _, err = DB.Exec(stmt); err != nil {
-				if strings.Contains(err.Error(), "duplicate column name") {
-					continue
-				}
-				return fmt.Errorf("migration %s: %w", f.name, err)
-			}
+		version = f.n
+	}
+	return nil
+}
+
+// applyMigration runs one migration and bumps schema_version in a single transaction,
+// so a failing statement (e.g. in a data fold) leaves the DB at the previous version.
+func applyMigration(n int, body string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range splitStatements(body) {
+		stmt = strings.TrimSpace(stmt)
+		if stmt == "" {
+			continue
 		}
-	updateVersion:
-		if _, err = DB.Exec("UPDATE schema_version SET version = ? WHERE id = 1", f.n); err != nil {
+		if _, err := tx.Exec(stmt); err != nil {
+			if strings.Contains(err.Error(), "duplicate column name") {
+				continue
+			}
 			return err
 		}
-		version = f.n
 	}
-	return nil
+	if _, err := tx.Exec("UPDATE schema_version SET version = ? WHERE id = 1", n); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func InitUploadDirs(uploadDir string) {
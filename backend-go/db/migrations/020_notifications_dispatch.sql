@@ -0,0 +1,3 @@
+-- §16 Notifications dispatcher: last delivery error per row; index for due-row polling.
+ALTER TABLE notifications_queue ADD COLUMN last_error TEXT;
+CREATE INDEX IF NOT EXISTS idx_notif_queue_due ON notifications_queue(status, scheduled_for);
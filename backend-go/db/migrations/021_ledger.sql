@@ -0,0 +1,57 @@
+-- §15 Wallet: single double-entry ledger. Accounts per (owner, code, currency), user_id 0 = system account.
+-- Balances are integer minor units. Replaces wallet_balances (amount/hold_amount) and user_balances (float).
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL DEFAULT 0,
+  code TEXT NOT NULL,
+  currency TEXT NOT NULL,
+  balance BIGINT NOT NULL DEFAULT 0,
+  created_at INTEGER DEFAULT (unixepoch()),
+  updated_at INTEGER DEFAULT (unixepoch()),
+  UNIQUE(user_id, code, currency)
+);
+CREATE INDEX IF NOT EXISTS idx_ledger_accounts_user ON ledger_accounts(user_id);
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  type TEXT NOT NULL,
+  currency TEXT NOT NULL,
+  reference_id TEXT,
+  created_at INTEGER DEFAULT (unixepoch())
+);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_reference ON ledger_entries(reference_id);
+
+CREATE TABLE IF NOT EXISTS ledger_postings (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  entry_id INTEGER NOT NULL REFERENCES ledger_entries(id),
+  account_id INTEGER NOT NULL,
+  amount BIGINT NOT NULL,
+  created_at INTEGER DEFAULT (unixepoch())
+);
+CREATE INDEX IF NOT EXISTS idx_ledger_postings_entry ON ledger_postings(entry_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_postings_account ON ledger_postings(account_id);
+
+-- Statement rows (wallet_transactions) point at the ledger entry that moved the money.
+ALTER TABLE wallet_transactions ADD COLUMN ledger_entry_id INTEGER;
+
+-- Fold legacy balances: wallet_balances is already in minor units; user_balances was float USD units (x100 -> cents).
+INSERT INTO ledger_accounts (user_id, code, currency, balance)
+  SELECT user_id, 'main', currency, amount - hold_amount FROM wallet_balances WHERE amount - hold_amount != 0;
+INSERT INTO ledger_accounts (user_id, code, currency, balance)
+  SELECT user_id, 'hold', currency, hold_amount FROM wallet_balances WHERE hold_amount != 0;
+INSERT INTO ledger_accounts (user_id, code, currency, balance)
+  SELECT user_id, 'main', 'USD', CAST(ROUND(balance * 100) AS INTEGER) FROM user_balances WHERE CAST(ROUND(balance * 100) AS INTEGER) != 0
+  ON CONFLICT(user_id, code, currency) DO UPDATE SET balance = balance + excluded.balance;
+
+-- Balance the opening positions against a system 'opening' account: one entry per currency.
+INSERT INTO ledger_accounts (user_id, code, currency, balance)
+  SELECT 0, 'opening', currency, -SUM(balance) FROM ledger_accounts WHERE user_id != 0 GROUP BY currency;
+INSERT INTO ledger_entries (type, currency, reference_id)
+  SELECT 'opening_balance', currency, 'migration:021' FROM ledger_accounts WHERE user_id = 0 AND code = 'opening';
+INSERT INTO ledger_postings (entry_id, account_id, amount)
+  SELECT e.id, a.id, a.balance FROM ledger_accounts a
+  JOIN ledger_entries e ON e.currency = a.currency AND e.reference_id = 'migration:021'
+  WHERE a.balance != 0;
+
+DROP TABLE IF EXISTS wallet_balances;
+DROP TABLE IF EXISTS user_balances;
@@ -0,0 +1,18 @@
+-- §15 Wallet: DB-level guarantee that user accounts never go negative. With amount = main + hold this is amount >= hold_amount >= 0.
+-- System accounts (user_id 0) are counterparties and may be negative. SQLite cannot add a CHECK in place: rebuild the table.
+CREATE TABLE ledger_accounts_new (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL DEFAULT 0,
+  code TEXT NOT NULL,
+  currency TEXT NOT NULL,
+  balance BIGINT NOT NULL DEFAULT 0,
+  created_at INTEGER DEFAULT (unixepoch()),
+  updated_at INTEGER DEFAULT (unixepoch()),
+  UNIQUE(user_id, code, currency),
+  CHECK (user_id = 0 OR balance >= 0)
+);
+INSERT INTO ledger_accounts_new (id, user_id, code, currency, balance, created_at, updated_at)
+  SELECT id, user_id, code, currency, balance, created_at, updated_at FROM ledger_accounts;
+DROP TABLE ledger_accounts;
+ALTER TABLE ledger_accounts_new RENAME TO ledger_accounts;
+CREATE INDEX IF NOT EXISTS idx_ledger_accounts_user ON ledger_accounts(user_id);
@@ -0,0 +1,19 @@
+-- §15 Wallet: explicit hold state machine. status: active -> captured | released | expired (terminal).
+-- Captures set captured_at, captured_amount (partial capture allowed) and captured_to; the remainder is released (released_at).
+ALTER TABLE wallet_holds ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE wallet_holds ADD COLUMN captured_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE wallet_holds ADD COLUMN captured_to INTEGER;
+
+-- Backfill from the ledger: captures used to set released_at only.
+UPDATE wallet_holds SET status = 'captured', captured_at = released_at, released_at = NULL, captured_amount = amount,
+  captured_to = (SELECT a.user_id FROM ledger_entries e JOIN ledger_postings p ON p.entry_id = e.id JOIN ledger_accounts a ON a.id = p.account_id
+                 WHERE e.type = 'hold_capture' AND e.reference_id = 'hold:' || wallet_holds.id AND p.amount > 0 LIMIT 1)
+  WHERE released_at IS NOT NULL AND EXISTS (SELECT 1 FROM ledger_entries WHERE type = 'hold_capture' AND reference_id = 'hold:' || wallet_holds.id);
+UPDATE wallet_holds SET status = 'captured', captured_at = released_at, released_at = NULL, captured_amount = amount
+  WHERE status = 'active' AND released_at IS NOT NULL AND EXISTS (SELECT 1 FROM wallet_transactions WHERE type = 'payment' AND reference_id = 'hold:' || wallet_holds.id);
+UPDATE wallet_holds SET status = 'expired'
+  WHERE status = 'active' AND released_at IS NOT NULL AND EXISTS (SELECT 1 FROM ledger_entries WHERE type = 'hold_expired' AND reference_id = 'hold:' || wallet_holds.id);
+UPDATE wallet_holds SET status = 'released' WHERE status = 'active' AND released_at IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_wallet_holds_user_status ON wallet_holds(user_id, status);
+CREATE INDEX IF NOT EXISTS idx_wallet_holds_status_expires ON wallet_holds(status, expires_at);
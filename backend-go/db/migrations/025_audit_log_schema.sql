@@ -0,0 +1,22 @@
+-- §1.9 Audit: 013 created audit_log with (resource, resource_id, old_value, new_value, ip, user_agent), so 019's
+-- CREATE TABLE IF NOT EXISTS was a no-op and auditLog inserts (entity_type, entity_id, details) failed. Rebuild with the 019 columns.
+CREATE TABLE audit_log_new (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER REFERENCES users(id),
+  action TEXT NOT NULL,
+  entity_type TEXT NOT NULL,
+  entity_id TEXT,
+  details TEXT,
+  ip_address TEXT,
+  created_at INTEGER DEFAULT (unixepoch())
+);
+INSERT INTO audit_log_new (id, user_id, action, entity_type, entity_id, details, ip_address, created_at)
+  SELECT id, user_id, action, resource, resource_id,
+         CASE WHEN old_value IS NOT NULL THEN old_value || ' -> ' || COALESCE(new_value, '') ELSE new_value END,
+         ip, created_at
+  FROM audit_log;
+DROP TABLE audit_log;
+ALTER TABLE audit_log_new RENAME TO audit_log;
+CREATE INDEX IF NOT EXISTS idx_audit_user ON audit_log(user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_action ON audit_log(action);
+CREATE INDEX IF NOT EXISTS idx_audit_created ON audit_log(created_at);
@@ -0,0 +1,31 @@
+-- §1.6 Durable events: outbox written in the domain transaction, relayed to named subscribers.
+-- event_cursors holds each subscriber's last delivered outbox id; events that keep failing go to event_dead_letters.
+CREATE TABLE IF NOT EXISTS event_outbox (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  topic TEXT NOT NULL,
+  user_id TEXT,
+  payload TEXT NOT NULL,
+  metadata TEXT,
+  created_at INTEGER NOT NULL DEFAULT (unixepoch())
+);
+CREATE INDEX IF NOT EXISTS idx_event_outbox_created ON event_outbox(created_at);
+
+CREATE TABLE IF NOT EXISTS event_cursors (
+  subscriber TEXT PRIMARY KEY,
+  last_id INTEGER NOT NULL DEFAULT 0,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  last_error TEXT,
+  updated_at INTEGER DEFAULT (unixepoch())
+);
+
+CREATE TABLE IF NOT EXISTS event_dead_letters (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  subscriber TEXT NOT NULL,
+  outbox_id INTEGER NOT NULL,
+  topic TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  error TEXT,
+  attempts INTEGER NOT NULL,
+  created_at INTEGER DEFAULT (unixepoch())
+);
+CREATE INDEX IF NOT EXISTS idx_event_dead_letters_subscriber ON event_dead_letters(subscriber);
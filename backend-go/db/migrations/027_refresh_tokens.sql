@@ -0,0 +1,9 @@
+-- §1.1 Auth: opaque refresh tokens (SHA-256 hashes) per session. A session is one token family: every refresh
+-- marks the presented token used and issues the next; presenting a used token again revokes the session.
+CREATE TABLE IF NOT EXISTS session_refresh_tokens (
+  token_hash TEXT PRIMARY KEY,
+  session_id INTEGER NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+  created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+  used_at INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_session_refresh_tokens_session ON session_refresh_tokens(session_id);
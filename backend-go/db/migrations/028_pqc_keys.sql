@@ -0,0 +1,14 @@
+-- §1.1 Auth: token signing keyring. One 'active' key signs; 'verify' keys only verify until retire_after,
+-- so tokens signed before a rotation stay valid until they expire. private_key may be AES-GCM encrypted.
+CREATE TABLE IF NOT EXISTS pqc_keys (
+  kid TEXT PRIMARY KEY,
+  algorithm TEXT NOT NULL DEFAULT 'DILITHIUM3',
+  public_key BLOB NOT NULL,
+  private_key BLOB,
+  private_key_encrypted INTEGER NOT NULL DEFAULT 0,
+  status TEXT NOT NULL DEFAULT 'active',
+  created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+  rotated_at INTEGER,
+  retire_after INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_pqc_keys_status ON pqc_keys(status);
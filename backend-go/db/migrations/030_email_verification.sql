@@ -0,0 +1,4 @@
+-- §1.1 Email verification: confirmation tokens expire and can be resent (email_verify_sent_at throttles resends).
+ALTER TABLE users ADD COLUMN email_verify_expires INTEGER;
+ALTER TABLE users ADD COLUMN email_verify_sent_at INTEGER;
+UPDATE users SET email_verify_expires = unixepoch() + 172800 WHERE email_verify_token IS NOT NULL AND email_verified = 0;
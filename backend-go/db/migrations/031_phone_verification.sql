@@ -0,0 +1,9 @@
+-- B1 Identity: phone verification by SMS one-time code. One pending code per user; only its SHA-256 hash is stored.
+CREATE TABLE IF NOT EXISTS phone_verifications (
+  user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  phone TEXT NOT NULL,
+  code_hash TEXT NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  expires_at INTEGER NOT NULL,
+  sent_at INTEGER NOT NULL
+);
@@ -0,0 +1,11 @@
+-- §1.2 Recovery: user_recovery.recovery_hash now holds an Argon2id hash (rows with the old client hash are
+-- re-hashed on their next successful use). A restore with the recovery key must be confirmed from the
+-- account email; the link token is stored as a SHA-256 hash.
+CREATE TABLE IF NOT EXISTS recovery_confirmations (
+  token_hash TEXT PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  ip TEXT,
+  expires_at INTEGER NOT NULL,
+  created_at INTEGER NOT NULL DEFAULT (unixepoch())
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_confirmations_user_id ON recovery_confirmations(user_id);
@@ -0,0 +1,7 @@
+-- §1.1 Sessions: who opened each session (IP, user agent, coarse client type) and when it was last used
+-- (updated at most every few minutes), so users can spot sessions they do not recognise.
+ALTER TABLE sessions ADD COLUMN ip TEXT;
+ALTER TABLE sessions ADD COLUMN user_agent TEXT;
+ALTER TABLE sessions ADD COLUMN client_type TEXT;
+ALTER TABLE sessions ADD COLUMN last_used_at INTEGER;
+UPDATE sessions SET last_used_at = created_at;
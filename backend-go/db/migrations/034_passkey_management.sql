@@ -0,0 +1,11 @@
+-- Passkey management: friendly name, authenticator AAGUID, last use and a clone flag per credential;
+-- sessions remember the passkey that opened them; users.password_set is 0 for passkey-only accounts.
+ALTER TABLE webauthn_credentials ADD COLUMN name TEXT;
+ALTER TABLE webauthn_credentials ADD COLUMN aaguid TEXT;
+ALTER TABLE webauthn_credentials ADD COLUMN last_used_at INTEGER;
+ALTER TABLE webauthn_credentials ADD COLUMN clone_warning INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sessions ADD COLUMN credential_id INTEGER;
+CREATE INDEX IF NOT EXISTS idx_sessions_credential ON sessions(credential_id);
+ALTER TABLE users ADD COLUMN password_set INTEGER NOT NULL DEFAULT 1;
+-- Passkeys could only be added at sign-up, which stores an unguessable placeholder password.
+UPDATE users SET password_set = 0 WHERE id IN (SELECT user_id FROM webauthn_credentials);
@@ -0,0 +1,45 @@
+-- Fine-grained RBAC: named roles carry permissions and are granted to users on top of users.role.
+-- users.role = 'admin' stays the superuser (every permission). Builtin roles can be edited but not deleted.
+CREATE TABLE IF NOT EXISTS roles (
+  name TEXT PRIMARY KEY,
+  description TEXT NOT NULL DEFAULT '',
+  builtin INTEGER NOT NULL DEFAULT 0,
+  created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+  updated_at INTEGER NOT NULL DEFAULT (unixepoch())
+);
+CREATE TABLE IF NOT EXISTS role_permissions (
+  role TEXT NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+  permission TEXT NOT NULL,
+  PRIMARY KEY (role, permission)
+);
+CREATE TABLE IF NOT EXISTS user_roles (
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  role TEXT NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+  granted_by INTEGER,
+  created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+  PRIMARY KEY (user_id, role)
+);
+CREATE INDEX IF NOT EXISTS idx_user_roles_role ON user_roles(role);
+
+INSERT OR IGNORE INTO roles (name, description, builtin) VALUES
+  ('moderator', 'Handles user reports and product takedowns', 1),
+  ('support', 'Reads user and order data to help customers', 1),
+  ('finance', 'Reads and adjusts user wallets', 1);
+INSERT OR IGNORE INTO role_permissions (role, permission) VALUES
+  ('moderator', 'stats.read'),
+  ('moderator', 'reports.read'),
+  ('moderator', 'reports.manage'),
+  ('moderator', 'products.takedown'),
+  ('moderator', 'users.read'),
+  ('support', 'users.read'),
+  ('support', 'orders.read'),
+  ('support', 'reports.read'),
+  ('finance', 'users.read'),
+  ('finance', 'orders.read'),
+  ('finance', 'wallet.read'),
+  ('finance', 'wallet.adjust');
+
+-- Product takedowns hide a listing from the catalogue and from new orders without deleting it.
+ALTER TABLE products ADD COLUMN taken_down_at INTEGER;
+ALTER TABLE products ADD COLUMN takedown_reason TEXT;
+ALTER TABLE products ADD COLUMN taken_down_by INTEGER;
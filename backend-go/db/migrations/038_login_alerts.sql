@@ -0,0 +1,25 @@
+-- New-device sign-in alerts: devices records each client family (user agent family) and network (IP prefix)
+-- an account signs in from. A sign-in from an unfamiliar family creates a login_alerts row; its emailed
+-- "this wasn't me" token signs the account out everywhere and requires a password reset before the next password login.
+ALTER TABLE devices ADD COLUMN ua_family TEXT;
+ALTER TABLE devices ADD COLUMN ip_prefix TEXT;
+ALTER TABLE devices ADD COLUMN last_ip TEXT;
+CREATE INDEX IF NOT EXISTS idx_devices_user_family ON devices(user_id, ua_family);
+
+CREATE TABLE IF NOT EXISTS login_alerts (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  session_id INTEGER,
+  device_id INTEGER,
+  token_hash TEXT NOT NULL UNIQUE,
+  ip TEXT,
+  user_agent TEXT,
+  ua_family TEXT NOT NULL,
+  suspicious INTEGER NOT NULL DEFAULT 0,
+  created_at INTEGER NOT NULL,
+  expires_at INTEGER NOT NULL,
+  reported_at INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_login_alerts_user ON login_alerts(user_id);
+
+ALTER TABLE users ADD COLUMN password_reset_required INTEGER NOT NULL DEFAULT 0;
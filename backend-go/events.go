@@ -0,0 +1,250 @@
+// Domain events (§1.6): typed payloads written to the durable outbox (event_outbox) with the domain change and
+// relayed at-least-once to the built-in subscribers: audit_log, WebSocket fan-out, notifications queue, and the
+// in-memory EventBus for in-process listeners.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"omnixius-api/db"
+	"omnixius-api/internal/event"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	TopicOrderStatusChanged  = "order.status_changed"
+	TopicSlotBooked          = "slot.booked"
+	TopicSubscriptionCreated = "subscription.created"
+	TopicMessageSent         = "message.sent"
+	TopicWalletTransfer      = "wallet.transfer"
+	TopicUserBanned          = "user.banned"
+)
+
+// OrderStatusChanged is published when an order moves between statuses.
+type OrderStatusChanged struct {
+	OrderID  int64  `json:"order_id"`
+	BuyerID  int64  `json:"buyer_id"`
+	SellerID int64  `json:"seller_id"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+}
+
+// SlotBooked is published when a buyer books a service slot (an order is created for it).
+type SlotBooked struct {
+	SlotID    int64 `json:"slot_id"`
+	ProductID int64 `json:"product_id"`
+	OrderID   int64 `json:"order_id"`
+	BuyerID   int64 `json:"buyer_id"`
+	SellerID  int64 `json:"seller_id"`
+	SlotAt    int64 `json:"slot_at"`
+}
+
+// SubscriptionCreated is published when a user subscribes to a subscription listing.
+type SubscriptionCreated struct {
+	SubscriptionID int64 `json:"subscription_id"`
+	ProductID      int64 `json:"product_id"`
+	SubscriberID   int64 `json:"subscriber_id"`
+	SellerID       int64 `json:"seller_id"`
+}
+
+// MessageSent is published for each new message; Recipients are the other conversation participants.
+type MessageSent struct {
+	MessageID      int64   `json:"message_id"`
+	ConversationID int64   `json:"conversation_id"`
+	SenderID       int64   `json:"sender_id"`
+	Recipients     []int64 `json:"recipients"`
+}
+
+// WalletTransferred is published after a wallet transfer commits. Amount is in minor units.
+type WalletTransferred struct {
+	FromUserID    int64  `json:"from_user_id"`
+	ToUserID      int64  `json:"to_user_id"`
+	Currency      string `json:"currency"`
+	Amount        int64  `json:"amount"`
+	LedgerEntryID int64  `json:"ledger_entry_id"`
+}
+
+// UserBanned is published when an admin bans a user. ExpiresAt 0 = permanent.
+type UserBanned struct {
+	UserID    int64  `json:"user_id"`
+	AdminID   int64  `json:"admin_id"`
+	Reason    string `json:"reason"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+}
+
+// auditEntity is implemented by payloads recorded in audit_log.
+type auditEntity interface {
+	auditEntity() (entityType string, entityID int64)
+}
+
+func (e OrderStatusChanged) auditEntity() (string, int64)  { return "order", e.OrderID }
+func (e SlotBooked) auditEntity() (string, int64)          { return "product_slot", e.SlotID }
+func (e SubscriptionCreated) auditEntity() (string, int64) { return "subscription", e.SubscriptionID }
+func (e MessageSent) auditEntity() (string, int64)         { return "message", e.MessageID }
+func (e WalletTransferred) auditEntity() (string, int64)   { return "ledger_entry", e.LedgerEntryID }
+func (e UserBanned) auditEntity() (string, int64)          { return "user", e.UserID }
+
+var (
+	bus    *event.EventBus
+	outbox *event.Outbox
+)
+
+// initEventBus creates the bus and the outbox and registers the built-in durable subscribers.
+// Subscriber names are cursor keys in event_cursors; do not rename them.
+func initEventBus() {
+	bus = event.NewEventBus()
+	outbox = event.NewOutbox(db.DB, decodeEventPayload)
+	if cfg.OutboxMaxAttempts > 0 {
+		outbox.MaxAttempts = cfg.OutboxMaxAttempts
+	}
+	if cfg.OutboxRetention > 0 {
+		outbox.Retention = cfg.OutboxRetention
+	}
+	subs := []struct {
+		name    string
+		handler event.EventHandler
+	}{
+		{"audit", auditEventHandler},
+		{"ws", wsEventHandler},
+		{"notify", notifyEventHandler},
+		{"bus", busForwardHandler},
+	}
+	for _, s := range subs {
+		if err := outbox.Subscribe(s.name, "#", s.handler); err != nil {
+			log.Printf("event: subscribe %s: %v", s.name, err)
+		}
+	}
+}
+
+// startEventRelay delivers outbox events every interval (and right after each publish).
+func startEventRelay(interval time.Duration) {
+	if outbox == nil {
+		return
+	}
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	outbox.Start(interval)
+}
+
+// publishEvent stores payload on topic with the acting user, outside any transaction. No-op before initEventBus.
+func publishEvent(topic string, actorID int64, payload interface{}) {
+	if outbox == nil {
+		return
+	}
+	if err := outbox.Enqueue(db.DB, topic, payload, strconv.FormatInt(actorID, 10), nil); err != nil {
+		log.Printf("event: publish %s: %v", topic, err)
+		return
+	}
+	outbox.Wake()
+}
+
+// publishEventTx stores the event in tx so it commits with the domain change. Call wakeEventRelay after Commit.
+func publishEventTx(tx *sql.Tx, topic string, actorID int64, payload interface{}) error {
+	if outbox == nil {
+		return nil
+	}
+	return outbox.Enqueue(tx, topic, payload, strconv.FormatInt(actorID, 10), nil)
+}
+
+// wakeEventRelay asks the relay to deliver pending events now.
+func wakeEventRelay() {
+	if outbox != nil {
+		outbox.Wake()
+	}
+}
+
+// decodeEventPayload restores the typed payload of a stored event; unknown topics stay raw JSON.
+func decodeEventPayload(topic string, data []byte) (interface{}, error) {
+	switch topic {
+	case TopicOrderStatusChanged:
+		return decodeAs[OrderStatusChanged](data)
+	case TopicSlotBooked:
+		return decodeAs[SlotBooked](data)
+	case TopicSubscriptionCreated:
+		return decodeAs[SubscriptionCreated](data)
+	case TopicMessageSent:
+		return decodeAs[MessageSent](data)
+	case TopicWalletTransfer:
+		return decodeAs[WalletTransferred](data)
+	case TopicUserBanned:
+		return decodeAs[UserBanned](data)
+	}
+	return json.RawMessage(data), nil
+}
+
+func decodeAs[T any](data []byte) (interface{}, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// busForwardHandler republishes relayed events on the in-memory bus for ad-hoc in-process subscribers.
+func busForwardHandler(_ context.Context, ev event.Event) error {
+	return bus.Publish(ev.Topic, ev.Payload, ev.UserID, ev.Metadata)
+}
+
+func auditEventHandler(_ context.Context, ev event.Event) error {
+	entity, ok := ev.Payload.(auditEntity)
+	if !ok {
+		return nil
+	}
+	entityType, entityID := entity.auditEntity()
+	actorID, _ := strconv.ParseInt(ev.UserID, 10, 64)
+	details, _ := json.Marshal(ev.Payload)
+	return auditLog(actorID, ev.Topic, entityType, strconv.FormatInt(entityID, 10), string(details))
+}
+
+// wsEventHandler fans events out to the affected users' WebSocket connections.
+func wsEventHandler(_ context.Context, ev event.Event) error {
+	switch p := ev.Payload.(type) {
+	case OrderStatusChanged:
+		msg := gin.H{"order_id": p.OrderID, "status": p.To}
+		BroadcastToUser(p.BuyerID, "order:status", msg)
+		BroadcastToUser(p.SellerID, "order:status", msg)
+	case SlotBooked:
+		BroadcastToUser(p.SellerID, "slot:booked", gin.H{"slot_id": p.SlotID, "product_id": p.ProductID, "order_id": p.OrderID, "slot_at": p.SlotAt})
+	case SubscriptionCreated:
+		BroadcastToUser(p.SellerID, "subscription:new", gin.H{"subscription_id": p.SubscriptionID, "product_id": p.ProductID})
+	case MessageSent:
+		for _, uid := range p.Recipients {
+			BroadcastToUser(uid, "relay:message", gin.H{"conversation_id": p.ConversationID, "message_id": p.MessageID})
+		}
+	case WalletTransferred:
+		msg := gin.H{"from_user_id": p.FromUserID, "to_user_id": p.ToUserID, "currency": p.Currency, "amount": p.Amount}
+		BroadcastToUser(p.FromUserID, "wallet:transfer", msg)
+		BroadcastToUser(p.ToUserID, "wallet:transfer", msg)
+	}
+	return nil
+}
+
+// notifyEventHandler queues in-app notifications for the counterparty of an action.
+func notifyEventHandler(_ context.Context, ev event.Event) error {
+	actorID, _ := strconv.ParseInt(ev.UserID, 10, 64)
+	switch p := ev.Payload.(type) {
+	case OrderStatusChanged:
+		to := p.BuyerID
+		if actorID == p.BuyerID {
+			to = p.SellerID
+		}
+		data, _ := json.Marshal(gin.H{"order_id": p.OrderID, "status": p.To})
+		return enqueueNotification(to, "order_status", "in_app", fmt.Sprintf("Order #%d %s", p.OrderID, p.To), "Order status changed from "+p.From+" to "+p.To+".", string(data))
+	case SlotBooked:
+		data, _ := json.Marshal(gin.H{"order_id": p.OrderID, "slot_id": p.SlotID})
+		return enqueueNotification(p.SellerID, "slot_booked", "in_app", "New booking", fmt.Sprintf("A slot was booked. Order #%d.", p.OrderID), string(data))
+	case SubscriptionCreated:
+		data, _ := json.Marshal(gin.H{"subscription_id": p.SubscriptionID, "product_id": p.ProductID})
+		return enqueueNotification(p.SellerID, "subscription_new", "in_app", "New subscriber", fmt.Sprintf("Someone subscribed to product #%d.", p.ProductID), string(data))
+	case WalletTransferred:
+		data, _ := json.Marshal(p)
+		return enqueueNotification(p.ToUserID, "wallet_transfer_in", "in_app", "Money received", fmt.Sprintf("You received %d %s (minor units).", p.Amount, p.Currency), string(data))
+	}
+	return nil
+}
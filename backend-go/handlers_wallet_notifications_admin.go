@@ -3,6 +3,7 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -14,35 +15,11 @@ import (
 
 // --- Wallet ---
 func handleWalletBalances(c *gin.Context) {
-	uid := getUserID(c)
-	rows, err := db.DB.Query(
-		"SELECT currency, amount, hold_amount, updated_at FROM wallet_balances WHERE user_id = ?",
-		uid,
-	)
+	list, err := WalletBalances(getUserID(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load balances"})
 		return
 	}
-	defer rows.Close()
-	var list []gin.H
-	for rows.Next() {
-		var currency string
-		var amount, holdAmount int64
-		var updatedAt sql.NullInt64
-		if rows.Scan(&currency, &amount, &holdAmount, &updatedAt) != nil {
-			continue
-		}
-		list = append(list, gin.H{
-			"currency":    currency,
-			"amount":      amount,
-			"hold_amount": holdAmount,
-			"available":   amount - holdAmount,
-			"updated_at":  updatedAt.Int64,
-		})
-	}
-	if list == nil {
-		list = []gin.H{}
-	}
 	c.JSON(http.StatusOK, gin.H{"balances": list})
 }
 
@@ -97,62 +74,18 @@ func handleWalletTransfer(c *gin.Context) {
 		return
 	}
 	if body.Currency == "" {
-		body.Currency = "USD"
-	}
-	if body.ToUserID == uid {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot transfer to self"})
-		return
-	}
-	// Ensure sender has wallet_balances row and enough balance
-	var amount, holdAmount int64
-	err := db.DB.QueryRow(
-		"SELECT amount, hold_amount FROM wallet_balances WHERE user_id = ? AND currency = ?",
-		uid, body.Currency,
-	).Scan(&amount, &holdAmount)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "insufficient balance"})
-		return
-	}
-	if amount-holdAmount < body.Amount {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "insufficient balance"})
-		return
-	}
-	now := time.Now().Unix()
-	tx, err := db.DB.Begin()
-	if err != nil {
+		body.Currency = walletDefaultCurrency
+	}
+	switch err := WalletTransfer(uid, body.ToUserID, body.Currency, body.Amount); {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	case errors.Is(err, ErrWalletSelfTransfer), errors.Is(err, ErrWalletInsufficient):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrWalletRecipientMissing):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "transfer failed"})
-		return
 	}
-	defer tx.Rollback()
-	_, err = tx.Exec(
-		"UPDATE wallet_balances SET amount = amount - ?, updated_at = ? WHERE user_id = ? AND currency = ?",
-		body.Amount, now, uid, body.Currency,
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "transfer failed"})
-		return
-	}
-	_, err = tx.Exec(
-		"INSERT INTO wallet_balances (user_id, currency, amount, hold_amount, updated_at) VALUES (?, ?, ?, 0, ?) ON CONFLICT(user_id, currency) DO UPDATE SET amount = amount + ?, updated_at = ?",
-		body.ToUserID, body.Currency, body.Amount, now, body.Amount, now,
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "transfer failed"})
-		return
-	}
-	_, _ = tx.Exec(
-		"INSERT INTO wallet_transactions (user_id, type, currency, amount, fee, status, reference_id, created_at, completed_at) VALUES (?, 'transfer_out', ?, ?, 0, 'completed', ?, ?, ?)",
-		uid, body.Currency, -body.Amount, strconv.FormatInt(body.ToUserID, 10), now, now,
-	)
-	_, _ = tx.Exec(
-		"INSERT INTO wallet_transactions (user_id, type, currency, amount, fee, status, reference_id, created_at, completed_at) VALUES (?, 'transfer_in', ?, ?, 0, 'completed', ?, ?, ?)",
-		body.ToUserID, body.Currency, body.Amount, strconv.FormatInt(uid, 10), now, now,
-	)
-	if err = tx.Commit(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "transfer failed"})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
 func handleWalletBalanceByCurrency(c *gin.Context) {
@@ -162,22 +95,7 @@ func handleWalletBalanceByCurrency(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "currency required"})
 		return
 	}
-	var amount, holdAmount int64
-	var updatedAt sql.NullInt64
-	err := db.DB.QueryRow(
-		"SELECT amount, hold_amount, updated_at FROM wallet_balances WHERE user_id = ? AND currency = ?",
-		uid, currency,
-	).Scan(&amount, &holdAmount, &updatedAt)
-	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"currency": currency, "amount": 0, "hold_amount": 0, "available": 0, "updated_at": 0,
-		})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{
-		"currency": currency, "amount": amount, "hold_amount": holdAmount,
-		"available": amount - holdAmount, "updated_at": updatedAt.Int64,
-	})
+	c.JSON(http.StatusOK, WalletBalance(uid, currency))
 }
 
 func handleWalletTransactionByID(c *gin.Context) {
@@ -292,106 +210,80 @@ func handleWalletHold(c *gin.Context) {
 		return
 	}
 	if body.ExpiresIn <= 0 {
-		body.ExpiresIn = 86400 * 7 // 7 days
+		body.ExpiresIn = int(walletHoldDefaultTTL / time.Second)
 	}
 	expiresAt := time.Now().Unix() + int64(body.ExpiresIn)
-	var amount, holdAmount int64
-	err := db.DB.QueryRow(
-		"SELECT amount, hold_amount FROM wallet_balances WHERE user_id = ? AND currency = ?",
-		uid, body.Currency,
-	).Scan(&amount, &holdAmount)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "insufficient balance"})
-		return
-	}
-	if amount-holdAmount < body.Amount {
+	holdID, err := WalletHold(uid, body.OrderID, body.Currency, body.Amount, expiresAt)
+	if errors.Is(err, ErrWalletInsufficient) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "insufficient balance"})
 		return
 	}
-	now := time.Now().Unix()
-	tx, err := db.DB.Begin()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "hold failed"})
 		return
 	}
-	defer tx.Rollback()
-	var orderID interface{}
-	if body.OrderID != nil {
-		orderID = *body.OrderID
-	}
-	res, err := tx.Exec(
-		"INSERT INTO wallet_holds (user_id, order_id, currency, amount, expires_at) VALUES (?, ?, ?, ?, ?)",
-		uid, orderID, body.Currency, body.Amount, expiresAt,
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "hold failed"})
+	c.JSON(http.StatusOK, gin.H{"id": holdID, "expires_at": expiresAt})
+}
+
+func handleWalletHoldsList(c *gin.Context) {
+	states, ok := parseHoldStates(c.Query("status"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be active, captured, released or expired (comma-separated)"})
 		return
 	}
-	holdID, _ := res.LastInsertId()
-	_, err = tx.Exec(
-		"UPDATE wallet_balances SET hold_amount = hold_amount + ?, updated_at = ? WHERE user_id = ? AND currency = ?",
-		body.Amount, now, uid, body.Currency,
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "hold failed"})
-		return
+	limit := 50
+	if l := c.Query("limit"); l != "" {
+		if n, err := strconv.Atoi(l); err == nil && n > 0 && n <= 100 {
+			limit = n
+		}
 	}
-	if err = tx.Commit(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "hold failed"})
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	list, err := WalletHolds(getUserID(c), states, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load holds"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"id": holdID, "expires_at": expiresAt})
+	c.JSON(http.StatusOK, gin.H{"holds": list})
 }
 
-func handleWalletHoldRelease(c *gin.Context) {
-	uid := getUserID(c)
+func handleWalletHoldGet(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
-	var holdUserID int64
-	var currency string
-	var amount int64
-	var releasedAt sql.NullInt64
-	err = db.DB.QueryRow(
-		"SELECT user_id, currency, amount, released_at FROM wallet_holds WHERE id = ?",
-		id,
-	).Scan(&holdUserID, &currency, &amount, &releasedAt)
+	h, err := WalletHoldGet(getUserID(c), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		walletHoldError(c, err, "failed to load hold")
 		return
 	}
-	if holdUserID != uid {
+	c.JSON(http.StatusOK, h)
+}
+
+// walletHoldError maps hold service errors to responses. fallback is the 500 message.
+func walletHoldError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrHoldNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	case errors.Is(err, ErrHoldForbidden):
 		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-		return
+	case errors.Is(err, ErrHoldClosed), errors.Is(err, ErrHoldOrderLinked), errors.Is(err, ErrHoldPayeeRequired),
+		errors.Is(err, ErrHoldCaptureAmount), errors.Is(err, ErrHoldPartialOrder), errors.Is(err, ErrHoldExpired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
 	}
-	if releasedAt.Valid {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "hold already released"})
-		return
-	}
-	now := time.Now().Unix()
-	tx, err := db.DB.Begin()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "release failed"})
-		return
-	}
-	defer tx.Rollback()
-	_, err = tx.Exec("UPDATE wallet_holds SET released_at = ? WHERE id = ?", now, id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "release failed"})
-		return
-	}
-	_, err = tx.Exec(
-		"UPDATE wallet_balances SET hold_amount = hold_amount - ?, updated_at = ? WHERE user_id = ? AND currency = ?",
-		amount, now, uid, currency,
-	)
+}
+
+func handleWalletHoldRelease(c *gin.Context) {
+	uid := getUserID(c)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "release failed"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
-	if err = tx.Commit(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "release failed"})
+	if err := WalletHoldRelease(uid, id); err != nil {
+		walletHoldError(c, err, "release failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"ok": true})
@@ -405,71 +297,12 @@ func handleWalletHoldCapture(c *gin.Context) {
 		return
 	}
 	var body struct {
-		ToUserID int64 `json:"to_user_id"` // seller_id for trade
+		ToUserID int64 `json:"to_user_id"` // payee for holds without an order; order holds always pay orders.seller_id
+		Amount   int64 `json:"amount"`     // partial capture; 0 = full
 	}
-	if err := c.ShouldBindJSON(&body); err != nil || body.ToUserID <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "to_user_id required"})
-		return
-	}
-	var holdUserID int64
-	var currency string
-	var amount int64
-	var releasedAt sql.NullInt64
-	err = db.DB.QueryRow(
-		"SELECT user_id, currency, amount, released_at FROM wallet_holds WHERE id = ?",
-		id,
-	).Scan(&holdUserID, &currency, &amount, &releasedAt)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
-		return
-	}
-	if releasedAt.Valid {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "hold already released or captured"})
-		return
-	}
-	// Caller must be the user who owns the hold (buyer confirming delivery)
-	if holdUserID != uid {
-		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-		return
-	}
-	now := time.Now().Unix()
-	tx, err := db.DB.Begin()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "capture failed"})
-		return
-	}
-	defer tx.Rollback()
-	_, err = tx.Exec("UPDATE wallet_holds SET released_at = ? WHERE id = ?", now, id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "capture failed"})
-		return
-	}
-	_, err = tx.Exec(
-		"UPDATE wallet_balances SET hold_amount = hold_amount - ?, updated_at = ? WHERE user_id = ? AND currency = ?",
-		amount, now, holdUserID, currency,
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "capture failed"})
-		return
-	}
-	_, err = tx.Exec(
-		"INSERT INTO wallet_balances (user_id, currency, amount, hold_amount, updated_at) VALUES (?, ?, ?, 0, ?) ON CONFLICT(user_id, currency) DO UPDATE SET amount = amount + ?, updated_at = ?",
-		body.ToUserID, currency, amount, now, amount, now,
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "capture failed"})
-		return
-	}
-	_, _ = tx.Exec(
-		"INSERT INTO wallet_transactions (user_id, type, currency, amount, fee, status, reference_id, created_at, completed_at) VALUES (?, 'payment', ?, ?, 0, 'completed', ?, ?, ?)",
-		body.ToUserID, currency, amount, "hold:"+strconv.FormatInt(id, 10), now, now,
-	)
-	_, _ = tx.Exec(
-		"INSERT INTO wallet_transactions (user_id, type, currency, amount, fee, status, reference_id, created_at, completed_at) VALUES (?, 'payment', ?, ?, 0, 'completed', ?, ?, ?)",
-		holdUserID, currency, -amount, "hold:"+strconv.FormatInt(id, 10), now, now,
-	)
-	if err = tx.Commit(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "capture failed"})
+	_ = c.ShouldBindJSON(&body)
+	if err := WalletHoldCapture(uid, id, body.ToUserID, body.Amount); err != nil {
+		walletHoldError(c, err, "capture failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"ok": true})
@@ -669,12 +502,7 @@ func handleNotificationsTest(c *gin.Context) {
 	uid := getUserID(c)
 	title := "Test notification"
 	body := "This is a test notification from OMNIXIUS."
-	now := time.Now().Unix()
-	_, err := db.DB.Exec(
-		"INSERT INTO notifications_queue (user_id, type, channel, title, body, status, scheduled_for) VALUES (?, ?, ?, ?, ?, 'pending', ?)",
-		uid, "test", "websocket", title, body, now,
-	)
-	if err != nil {
+	if err := enqueueNotification(uid, "test", "websocket", title, body, ""); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enqueue"})
 		return
 	}
@@ -940,6 +768,23 @@ func handleAdminUserBan(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "reason required"})
 		return
 	}
+	if userID == adminID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot ban yourself"})
+		return
+	}
+	actorRole, _ := c.Get("userRole")
+	role, _ := actorRole.(string)
+	if err := staffCanActOn(adminID, role, userID); err != nil {
+		switch {
+		case errors.Is(err, ErrRoleUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		case errors.Is(err, ErrRoleOutranked):
+			c.JSON(http.StatusForbidden, gin.H{"error": "cannot ban an admin or an account with permissions you lack", "code": "role_outranked"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed"})
+		}
+		return
+	}
 	var expiresAt interface{}
 	if body.ExpiresAt != nil {
 		expiresAt = *body.ExpiresAt
@@ -953,6 +798,14 @@ func handleAdminUserBan(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed"})
 		return
 	}
+	// Banned user loses access immediately: revoke sessions and drop live WebSocket connections.
+	db.DB.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+	DisconnectUser(userID)
+	ev := UserBanned{UserID: userID, AdminID: adminID, Reason: body.Reason}
+	if body.ExpiresAt != nil {
+		ev.ExpiresAt = *body.ExpiresAt
+	}
+	publishEvent(TopicUserBanned, adminID, ev)
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
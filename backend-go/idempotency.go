@@ -0,0 +1,119 @@
+// Idempotency-Key support (§15 Wallet): a retried POST with the same key replays the stored response instead of moving money twice.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"omnixius-api/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+const idempotencyMaxKeyLen = 255
+
+// idempotencyWriter captures the response body so it can be stored for replays.
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotent makes an authenticated POST idempotent when the client sends an Idempotency-Key header.
+// Keys are per user and live for cfg.IdempotencyTTL. Same key + same request replays the stored response
+// (header Idempotent-Replayed: true); same key + different method/path/body is 422; a request still running is 409.
+// 5xx responses and handler panics are not stored, so the client can retry with the same key.
+func idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > idempotencyMaxKeyLen {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key too long"})
+			return
+		}
+		uid := getUserID(c)
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(c.Request.Method+" "+c.Request.URL.Path+"\n"), body...))
+		hash := hex.EncodeToString(sum[:])
+
+		now := time.Now().Unix()
+		_, _ = db.DB.Exec("DELETE FROM idempotency_keys WHERE expires_at <= ?", now)
+		res, err := db.DB.Exec(
+			"INSERT INTO idempotency_keys (user_id, key, request_hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT(user_id, key) DO NOTHING",
+			uid, key, hash, now, now+int64(cfg.IdempotencyTTL/time.Second),
+		)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed"})
+			return
+		}
+		if mustRows(res) == 0 {
+			idempotencyReplay(c, uid, key, hash)
+			return
+		}
+
+		release := func() { _, _ = db.DB.Exec("DELETE FROM idempotency_keys WHERE user_id = ? AND key = ?", uid, key) }
+		defer func() {
+			if r := recover(); r != nil {
+				release()
+				panic(r)
+			}
+		}()
+		w := &idempotencyWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		status := w.Status()
+		if status >= 500 {
+			release()
+			return
+		}
+		_, _ = db.DB.Exec(
+			"UPDATE idempotency_keys SET status_code = ?, response_body = ? WHERE user_id = ? AND key = ?",
+			status, w.body.Bytes(), uid, key,
+		)
+	}
+}
+
+// idempotencyReplay answers a request whose key is already stored.
+func idempotencyReplay(c *gin.Context, uid int64, key, hash string) {
+	var storedHash string
+	var status sql.NullInt64
+	var body []byte
+	err := db.DB.QueryRow(
+		"SELECT request_hash, status_code, response_body FROM idempotency_keys WHERE user_id = ? AND key = ?",
+		uid, key,
+	).Scan(&storedHash, &status, &body)
+	switch {
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed"})
+	case storedHash != hash:
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key already used with a different request"})
+	case !status.Valid:
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still in progress"})
+	default:
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(int(status.Int64), "application/json; charset=utf-8", body)
+		c.Abort()
+	}
+}
@@ -1,13 +1,29 @@
 // Package event provides §1.6 EventBus in-memory implementation (doc v4.0).
+// Delivery is asynchronous and in publish order (one dispatcher goroutine); handler errors and panics
+// are reported to the bus error handler. Topics are dot-separated; subscriptions may use wildcards.
 package event
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	// ErrClosed is returned by Publish after Close.
+	ErrClosed = errors.New("event: bus closed")
+	// ErrQueueFull is returned by Publish when the event was dropped because the queue is full.
+	ErrQueueFull = errors.New("event: queue full, event dropped")
+)
+
+// queueSize is the number of undelivered events Publish buffers; beyond it events are dropped.
+const queueSize = 1024
+
 // Event is a single event (§1.6.2).
 type Event struct {
 	ID        string
@@ -21,9 +37,13 @@ type Event struct {
 // EventHandler is called for each event (§1.6.2).
 type EventHandler func(ctx context.Context, ev Event) error
 
+// ErrorHandler receives handler failures (returned errors and recovered panics).
+type ErrorHandler func(ev Event, err error)
+
 // subEntry holds handler and id for removal.
 type subEntry struct {
 	id      int
+	pattern string
 	handler EventHandler
 }
 
@@ -35,51 +55,96 @@ type Subscription struct {
 
 // EventBus provides Publish and Subscribe (§1.6.2).
 type EventBus struct {
-	mu        sync.RWMutex
-	subs      map[string][]subEntry
-	idCounter int
+	mu      sync.RWMutex // guards subs, subID, onError
+	subs    []subEntry
+	subID   int
+	onError ErrorHandler
+	seq     uint64       // event IDs, atomic
+	dropped uint64       // events dropped on a full queue, atomic
+	epoch   int64        // bus start, keeps IDs unique across restarts
+	queueMu sync.RWMutex // guards closed and sends on queue
+	closed  bool
+	queue   chan Event
+	pending int // published, not yet delivered; guarded by pendMu
+	pendMu  sync.Mutex
+	pendC   *sync.Cond
+	done    chan struct{}
 }
 
-// NewEventBus returns a new in-memory event bus.
+// NewEventBus returns a new in-memory event bus and starts its dispatcher.
 func NewEventBus() *EventBus {
-	return &EventBus{subs: make(map[string][]subEntry)}
+	b := &EventBus{
+		epoch: time.Now().UnixNano(),
+		queue: make(chan Event, queueSize),
+		done:  make(chan struct{}),
+		onError: func(ev Event, err error) {
+			log.Printf("event: handler for %s (%s) failed: %v", ev.Topic, ev.ID, err)
+		},
+	}
+	b.pendC = sync.NewCond(&b.pendMu)
+	go b.run()
+	return b
 }
 
-// Publish sends an event to all subscribers of the topic.
+// SetErrorHandler replaces the default (log) error handler.
+func (b *EventBus) SetErrorHandler(h ErrorHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onError = h
+}
+
+// Publish queues an event for all subscribers whose pattern matches topic. It never blocks: handlers run later,
+// and when the queue is full the event is dropped, counted (Dropped) and ErrQueueFull returned. Callers that
+// need guaranteed delivery publish through an Outbox. Events with no matching subscriber are dropped.
 func (b *EventBus) Publish(topic string, payload interface{}, userID string, metadata map[string]string) error {
-	b.mu.RLock()
-	entries := b.subs[topic]
-	if len(entries) == 0 {
-		b.mu.RUnlock()
+	if !b.hasSubscriber(topic) {
 		return nil
 	}
-	entries = append([]subEntry(nil), entries...)
-	b.mu.RUnlock()
-
-	b.idCounter++
 	ev := Event{
-		ID:        fmt.Sprintf("ev-%d", b.idCounter),
+		ID:        fmt.Sprintf("ev-%d-%d", b.epoch, atomic.AddUint64(&b.seq, 1)),
 		Topic:     topic,
 		Timestamp: time.Now().UTC(),
 		UserID:    userID,
 		Payload:   payload,
 		Metadata:  metadata,
 	}
-	ctx := context.Background()
-	for _, e := range entries {
-		_ = e.handler(ctx, ev)
+	b.queueMu.RLock()
+	defer b.queueMu.RUnlock()
+	if b.closed {
+		return ErrClosed
 	}
-	return nil
+	b.pendMu.Lock()
+	b.pending++
+	b.pendMu.Unlock()
+	select {
+	case b.queue <- ev:
+		return nil
+	default:
+		b.pendMu.Lock()
+		b.pending--
+		if b.pending == 0 {
+			b.pendC.Broadcast()
+		}
+		b.pendMu.Unlock()
+		atomic.AddUint64(&b.dropped, 1)
+		return ErrQueueFull
+	}
+}
+
+// Dropped returns the number of events dropped because the queue was full.
+func (b *EventBus) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
 }
 
-// Subscribe adds a handler for topic. Returns a Subscription for Unsubscribe.
+// Subscribe adds a handler for a topic pattern. Returns a Subscription for Unsubscribe.
+// Patterns match dot-separated segments: "*" matches exactly one segment, "#" (last) matches any remaining
+// segments including none. "order.*" matches "order.status_changed"; "#" matches everything.
 func (b *EventBus) Subscribe(topic string, handler EventHandler) *Subscription {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	b.idCounter++
-	id := b.idCounter
-	b.subs[topic] = append(b.subs[topic], subEntry{id: id, handler: handler})
-	return &Subscription{topic: topic, id: id}
+	b.subID++
+	b.subs = append(b.subs, subEntry{id: b.subID, pattern: topic, handler: handler})
+	return &Subscription{topic: topic, id: b.subID}
 }
 
 // Unsubscribe removes the subscription.
@@ -89,16 +154,99 @@ func (b *EventBus) Unsubscribe(sub *Subscription) {
 	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	list := b.subs[sub.topic]
-	for i, e := range list {
+	for i, e := range b.subs {
 		if e.id == sub.id {
-			b.subs[sub.topic] = append(list[:i], list[i+1:]...)
+			b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
 			break
 		}
 	}
 }
 
-// Close is a no-op for in-memory bus (for interface compatibility).
+// Wait blocks until every event published so far has been delivered.
+func (b *EventBus) Wait() {
+	b.pendMu.Lock()
+	defer b.pendMu.Unlock()
+	for b.pending > 0 {
+		b.pendC.Wait()
+	}
+}
+
+// Close stops accepting events and returns after queued events are delivered.
 func (b *EventBus) Close() error {
+	b.queueMu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.queueMu.Unlock()
+	<-b.done
 	return nil
 }
+
+func (b *EventBus) hasSubscriber(topic string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, e := range b.subs {
+		if Match(e.pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *EventBus) run() {
+	defer close(b.done)
+	for ev := range b.queue {
+		b.deliver(ev)
+		b.pendMu.Lock()
+		b.pending--
+		if b.pending == 0 {
+			b.pendC.Broadcast()
+		}
+		b.pendMu.Unlock()
+	}
+}
+
+func (b *EventBus) deliver(ev Event) {
+	b.mu.RLock()
+	var handlers []EventHandler
+	for _, e := range b.subs {
+		if Match(e.pattern, ev.Topic) {
+			handlers = append(handlers, e.handler)
+		}
+	}
+	onError := b.onError
+	b.mu.RUnlock()
+	ctx := context.Background()
+	for _, h := range handlers {
+		if err := callHandler(ctx, h, ev); err != nil && onError != nil {
+			onError(ev, err)
+		}
+	}
+}
+
+func callHandler(ctx context.Context, h EventHandler, ev Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, ev)
+}
+
+// Match reports whether topic matches pattern ("*" = one segment, trailing "#" = any remaining segments).
+func Match(pattern, topic string) bool {
+	if pattern == topic || pattern == "#" {
+		return true
+	}
+	ps, ts := strings.Split(pattern, "."), strings.Split(topic, ".")
+	for i, p := range ps {
+		if p == "#" && i == len(ps)-1 {
+			return true
+		}
+		if i >= len(ts) || (p != "*" && p != ts[i]) {
+			return false
+		}
+	}
+	return len(ps) == len(ts)
+}
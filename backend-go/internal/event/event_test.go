@@ -0,0 +1,98 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"order.status_changed", "order.status_changed", true},
+		{"order.*", "order.status_changed", true},
+		{"order.*", "order", false},
+		{"order.*", "order.a.b", false},
+		{"*.created", "subscription.created", true},
+		{"order.#", "order", true},
+		{"order.#", "order.a.b", true},
+		{"#", "anything.at.all", true},
+		{"wallet.*", "order.status_changed", false},
+	}
+	for _, c := range cases {
+		if got := Match(c.pattern, c.topic); got != c.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", c.pattern, c.topic, got, c.want)
+		}
+	}
+}
+
+func TestEventBus_AsyncDeliveryErrorsAndIDs(t *testing.T) {
+	b := NewEventBus()
+	defer b.Close()
+	var mu sync.Mutex
+	var failures []error
+	b.SetErrorHandler(func(ev Event, err error) {
+		mu.Lock()
+		failures = append(failures, err)
+		mu.Unlock()
+	})
+	ids := map[string]bool{}
+	var order []string
+	b.Subscribe("order.*", func(_ context.Context, ev Event) error {
+		ids[ev.ID] = true
+		order = append(order, ev.Payload.(string))
+		return nil
+	})
+	b.Subscribe("order.failed", func(context.Context, Event) error { return errors.New("boom") })
+	b.Subscribe("order.failed", func(context.Context, Event) error { panic("bad handler") })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Publish("order.created", "x", "1", nil)
+		}()
+	}
+	wg.Wait()
+	b.Publish("order.failed", "last", "1", nil)
+	b.Wait()
+
+	if len(ids) != 51 {
+		t.Errorf("got %d distinct event IDs, want 51", len(ids))
+	}
+	if order[len(order)-1] != "last" {
+		t.Errorf("events delivered out of order: last = %q", order[len(order)-1])
+	}
+	if len(failures) != 2 {
+		t.Errorf("got %d reported failures, want 2 (error + panic): %v", len(failures), failures)
+	}
+	b.Close()
+	if err := b.Publish("order.created", "x", "1", nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("publish after close: got %v, want ErrClosed", err)
+	}
+}
+
+func TestEventBus_FullQueueDropsWithoutBlocking(t *testing.T) {
+	b := NewEventBus()
+	defer b.Close()
+	release := make(chan struct{})
+	b.Subscribe("slow", func(context.Context, Event) error {
+		<-release
+		return nil
+	})
+	full := 0
+	for i := 0; i < queueSize+10; i++ {
+		if errors.Is(b.Publish("slow", i, "1", nil), ErrQueueFull) {
+			full++
+		}
+	}
+	if full == 0 || uint64(full) != b.Dropped() {
+		t.Errorf("got %d ErrQueueFull, Dropped() = %d; want equal and > 0", full, b.Dropped())
+	}
+	close(release)
+	b.Wait()
+}
@@ -0,0 +1,253 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Execer is satisfied by *sql.DB and *sql.Tx.
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// DecodeFunc turns a stored JSON payload back into the typed payload for topic.
+type DecodeFunc func(topic string, data []byte) (interface{}, error)
+
+// Outbox is a durable event log in SQLite (event_outbox). Events are written in the same transaction as the
+// domain change (Enqueue) and delivered by a relay to named subscribers, each with its own cursor
+// (event_cursors). Delivery is at-least-once and in order per subscriber; Event.ID is stable across redeliveries.
+// A subscriber that fails one event MaxAttempts times gets it dead-lettered (event_dead_letters) and moves on.
+type Outbox struct {
+	db          *sql.DB
+	decode      DecodeFunc
+	MaxAttempts int
+	BatchSize   int
+	Retention   time.Duration // delivered events older than this are pruned by the relay; 0 keeps everything
+
+	mu   sync.Mutex // guards subs; held for a whole relay pass
+	subs []outboxSub
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+type outboxSub struct {
+	name    string
+	pattern string
+	handler EventHandler
+}
+
+// NewOutbox returns an outbox on db. decode may be nil (payloads are then json.RawMessage).
+func NewOutbox(db *sql.DB, decode DecodeFunc) *Outbox {
+	return &Outbox{db: db, decode: decode, MaxAttempts: 5, BatchSize: 100, Retention: 7 * 24 * time.Hour, wake: make(chan struct{}, 1)}
+}
+
+// Enqueue stores an event. Pass the domain transaction so the event commits (or rolls back) with the change.
+func (o *Outbox) Enqueue(ex Execer, topic string, payload interface{}, userID string, metadata map[string]string) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var meta interface{}
+	if len(metadata) > 0 {
+		b, _ := json.Marshal(metadata)
+		meta = string(b)
+	}
+	_, err = ex.Exec(
+		"INSERT INTO event_outbox (topic, user_id, payload, metadata, created_at) VALUES (?, ?, ?, ?, ?)",
+		topic, userID, string(data), meta, time.Now().Unix(),
+	)
+	return err
+}
+
+// Subscribe registers a durable subscriber. name identifies its cursor and must stay stable across restarts;
+// a new name starts at the current end of the outbox (no backfill of older events).
+func (o *Outbox) Subscribe(name, pattern string, handler EventHandler) error {
+	if _, err := o.db.Exec(
+		"INSERT INTO event_cursors (subscriber, last_id, updated_at) VALUES (?, (SELECT COALESCE(MAX(id), 0) FROM event_outbox), ?) ON CONFLICT(subscriber) DO NOTHING",
+		name, time.Now().Unix(),
+	); err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.subs = append(o.subs, outboxSub{name: name, pattern: pattern, handler: handler})
+	return nil
+}
+
+// Wake asks the relay to run now. Never blocks.
+func (o *Outbox) Wake() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the relay (and pruning) every interval, or when woken, until Stop.
+func (o *Outbox) Start(interval time.Duration) {
+	o.stop, o.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(o.done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			o.RelayOnce()
+			if o.Retention > 0 {
+				if _, err := o.Prune(time.Now().Add(-o.Retention)); err != nil {
+					log.Printf("outbox: prune: %v", err)
+				}
+			}
+			select {
+			case <-t.C:
+			case <-o.wake:
+			case <-o.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the relay started by Start.
+func (o *Outbox) Stop() {
+	if o.stop != nil {
+		close(o.stop)
+		<-o.done
+	}
+}
+
+// RelayOnce delivers pending events to every subscriber (up to BatchSize each). Returns the number of deliveries.
+func (o *Outbox) RelayOnce() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := 0
+	for _, s := range o.subs {
+		n += o.relaySub(s)
+	}
+	return n
+}
+
+type outboxRow struct {
+	id                     int64
+	topic, userID, payload string
+	metadata               sql.NullString
+	createdAt              int64
+}
+
+func (o *Outbox) relaySub(s outboxSub) int {
+	var lastID int64
+	var attempts int
+	if err := o.db.QueryRow("SELECT last_id, attempts FROM event_cursors WHERE subscriber = ?", s.name).Scan(&lastID, &attempts); err != nil {
+		log.Printf("outbox: cursor %s: %v", s.name, err)
+		return 0
+	}
+	rows, err := o.db.Query(
+		"SELECT id, topic, COALESCE(user_id, ''), payload, metadata, created_at FROM event_outbox WHERE id > ? ORDER BY id LIMIT ?",
+		lastID, o.BatchSize,
+	)
+	if err != nil {
+		log.Printf("outbox: read for %s: %v", s.name, err)
+		return 0
+	}
+	var batch []outboxRow
+	for rows.Next() {
+		var r outboxRow
+		if rows.Scan(&r.id, &r.topic, &r.userID, &r.payload, &r.metadata, &r.createdAt) == nil {
+			batch = append(batch, r)
+		}
+	}
+	rows.Close()
+
+	delivered := 0
+	for _, r := range batch {
+		if !Match(s.pattern, r.topic) {
+			o.advance(s.name, r.id)
+			continue
+		}
+		err := o.deliver(s, r)
+		if err == nil {
+			o.advance(s.name, r.id)
+			delivered++
+			continue
+		}
+		attempts++
+		if attempts >= o.MaxAttempts {
+			if dlErr := o.deadLetter(s.name, r, err, attempts); dlErr != nil {
+				log.Printf("outbox: dead-letter for %s: %v", s.name, dlErr)
+				break
+			}
+			attempts = 0
+			continue
+		}
+		// Keep per-subscriber order: retry this event on the next pass.
+		o.db.Exec("UPDATE event_cursors SET attempts = ?, last_error = ?, updated_at = ? WHERE subscriber = ?", attempts, err.Error(), time.Now().Unix(), s.name)
+		break
+	}
+	return delivered
+}
+
+func (o *Outbox) deliver(s outboxSub, r outboxRow) error {
+	var payload interface{} = json.RawMessage(r.payload)
+	if o.decode != nil {
+		p, err := o.decode(r.topic, []byte(r.payload))
+		if err != nil {
+			return fmt.Errorf("decode: %w", err)
+		}
+		payload = p
+	}
+	var meta map[string]string
+	if r.metadata.Valid {
+		_ = json.Unmarshal([]byte(r.metadata.String), &meta)
+	}
+	ev := Event{
+		ID:        "evt-" + strconv.FormatInt(r.id, 10),
+		Topic:     r.topic,
+		Timestamp: time.Unix(r.createdAt, 0).UTC(),
+		UserID:    r.userID,
+		Payload:   payload,
+		Metadata:  meta,
+	}
+	return callHandler(context.Background(), s.handler, ev)
+}
+
+func (o *Outbox) advance(name string, id int64) {
+	o.db.Exec("UPDATE event_cursors SET last_id = ?, attempts = 0, last_error = NULL, updated_at = ? WHERE subscriber = ?", id, time.Now().Unix(), name)
+}
+
+// deadLetter records the failed event and moves the subscriber's cursor past it in one transaction.
+func (o *Outbox) deadLetter(name string, r outboxRow, cause error, attempts int) error {
+	log.Printf("outbox: %s gave up on event %d (%s) after %d attempts: %v", name, r.id, r.topic, attempts, cause)
+	tx, err := o.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	if _, err := tx.Exec(
+		"INSERT INTO event_dead_letters (subscriber, outbox_id, topic, payload, error, attempts, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		name, r.id, r.topic, r.payload, cause.Error(), attempts, now,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE event_cursors SET last_id = ?, attempts = 0, last_error = NULL, updated_at = ? WHERE subscriber = ?", r.id, now, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Prune deletes events older than before that every subscriber cursor has moved past.
+func (o *Outbox) Prune(before time.Time) (int64, error) {
+	res, err := o.db.Exec(
+		"DELETE FROM event_outbox WHERE created_at < ? AND id <= (SELECT COALESCE(MIN(last_id), 0) FROM event_cursors)",
+		before.Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
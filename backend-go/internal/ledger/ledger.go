@@ -0,0 +1,218 @@
+// Package ledger provides §15 Wallet double-entry bookkeeping: accounts per owner and currency,
+// balanced journal entries, integer minor units (e.g. cents).
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Account codes. User accounts: main (spendable) and hold (reserved by wallet holds).
+// System accounts (owner 0) are counterparties for money entering or leaving the platform and may go negative.
+const (
+	CodeMain       = "main"
+	CodeHold       = "hold"
+	CodeFunding    = "funding"    // deposits, test credits
+	CodeOpening    = "opening"    // balances migrated from legacy tables
+	CodeAdjustment = "adjustment" // manual corrections by finance staff
+)
+
+// SystemOwner is the owner ID of system accounts.
+const SystemOwner int64 = 0
+
+var (
+	ErrInvalidAmount     = errors.New("ledger: amount must be non-zero")
+	ErrUnbalanced        = errors.New("ledger: postings must sum to zero")
+	ErrCurrencyMismatch  = errors.New("ledger: postings must share one currency")
+	ErrInsufficientFunds = errors.New("ledger: insufficient funds")
+)
+
+// Account identifies a ledger account by owner, code and currency.
+type Account struct {
+	Owner    int64
+	Code     string
+	Currency string
+}
+
+// UserMain is the user's spendable account in currency.
+func UserMain(userID int64, currency string) Account {
+	return Account{Owner: userID, Code: CodeMain, Currency: currency}
+}
+
+// UserHold is the user's reserved (held) account in currency.
+func UserHold(userID int64, currency string) Account {
+	return Account{Owner: userID, Code: CodeHold, Currency: currency}
+}
+
+// System returns a system account.
+func System(code, currency string) Account {
+	return Account{Owner: SystemOwner, Code: code, Currency: currency}
+}
+
+// Posting changes one account balance by Amount (negative = debit from the account).
+type Posting struct {
+	Account Account
+	Amount  int64
+}
+
+// Entry is one journal entry. Postings must be in one currency and sum to zero.
+type Entry struct {
+	Type      string
+	Reference string
+	Postings  []Posting
+}
+
+// Queryer is satisfied by *sql.DB and *sql.Tx.
+type Queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Post validates and writes the entry inside tx and returns the entry ID.
+// User accounts cannot go below zero (ErrInsufficientFunds); the check and the debit are one conditional UPDATE,
+// so concurrent posts cannot overdraw an account.
+func Post(tx *sql.Tx, e Entry) (int64, error) {
+	if len(e.Postings) < 2 {
+		return 0, ErrUnbalanced
+	}
+	currency := e.Postings[0].Account.Currency
+	var sum int64
+	for _, p := range e.Postings {
+		if p.Amount == 0 {
+			return 0, ErrInvalidAmount
+		}
+		if p.Account.Currency != currency {
+			return 0, ErrCurrencyMismatch
+		}
+		sum += p.Amount
+	}
+	if sum != 0 {
+		return 0, ErrUnbalanced
+	}
+	now := time.Now().Unix()
+	res, err := tx.Exec(
+		"INSERT INTO ledger_entries (type, currency, reference_id, created_at) VALUES (?, ?, ?, ?)",
+		e.Type, currency, e.Reference, now,
+	)
+	if err != nil {
+		return 0, err
+	}
+	entryID, _ := res.LastInsertId()
+	for _, p := range e.Postings {
+		accountID, err := ensureAccount(tx, p.Account)
+		if err != nil {
+			return 0, err
+		}
+		if err := applyPosting(tx, accountID, p, now); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO ledger_postings (entry_id, account_id, amount, created_at) VALUES (?, ?, ?, ?)",
+			entryID, accountID, p.Amount, now,
+		); err != nil {
+			return 0, err
+		}
+	}
+	return entryID, nil
+}
+
+// Transfer posts amount (positive) from one account to another.
+func Transfer(tx *sql.Tx, entryType, reference string, from, to Account, amount int64) (int64, error) {
+	if amount <= 0 {
+		return 0, ErrInvalidAmount
+	}
+	return Post(tx, Entry{
+		Type:      entryType,
+		Reference: reference,
+		Postings:  []Posting{{Account: from, Amount: -amount}, {Account: to, Amount: amount}},
+	})
+}
+
+// applyPosting changes the account balance. Debits from user accounts only apply if the result stays >= 0.
+func applyPosting(tx *sql.Tx, accountID int64, p Posting, now int64) error {
+	if p.Amount > 0 || p.Account.Owner == SystemOwner {
+		_, err := tx.Exec("UPDATE ledger_accounts SET balance = balance + ?, updated_at = ? WHERE id = ?", p.Amount, now, accountID)
+		return err
+	}
+	res, err := tx.Exec(
+		"UPDATE ledger_accounts SET balance = balance + ?, updated_at = ? WHERE id = ? AND balance + ? >= 0",
+		p.Amount, now, accountID, p.Amount,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInsufficientFunds
+	}
+	return nil
+}
+
+func ensureAccount(tx *sql.Tx, a Account) (int64, error) {
+	if _, err := tx.Exec(
+		"INSERT INTO ledger_accounts (user_id, code, currency) VALUES (?, ?, ?) ON CONFLICT(user_id, code, currency) DO NOTHING",
+		a.Owner, a.Code, a.Currency,
+	); err != nil {
+		return 0, err
+	}
+	var id int64
+	err := tx.QueryRow("SELECT id FROM ledger_accounts WHERE user_id = ? AND code = ? AND currency = ?", a.Owner, a.Code, a.Currency).Scan(&id)
+	return id, err
+}
+
+// Balance returns the account balance (0 if the account does not exist yet).
+func Balance(q Queryer, a Account) int64 {
+	var balance int64
+	_ = q.QueryRow("SELECT balance FROM ledger_accounts WHERE user_id = ? AND code = ? AND currency = ?", a.Owner, a.Code, a.Currency).Scan(&balance)
+	return balance
+}
+
+// UserBalance is a user's position in one currency: Available (main) and Held (hold). Total = Available + Held.
+type UserBalance struct {
+	Currency  string
+	Available int64
+	Held      int64
+	UpdatedAt int64
+}
+
+// Total is the user's full balance including held funds.
+func (b UserBalance) Total() int64 { return b.Available + b.Held }
+
+// UserBalances returns the user's balances per currency, ordered by currency.
+func UserBalances(q Queryer, userID int64) ([]UserBalance, error) {
+	rows, err := q.Query(
+		`SELECT currency,
+		        COALESCE(SUM(CASE WHEN code = ? THEN balance END), 0),
+		        COALESCE(SUM(CASE WHEN code = ? THEN balance END), 0),
+		        COALESCE(MAX(updated_at), 0)
+		 FROM ledger_accounts WHERE user_id = ? AND code IN (?, ?) GROUP BY currency ORDER BY currency`,
+		CodeMain, CodeHold, userID, CodeMain, CodeHold,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []UserBalance
+	for rows.Next() {
+		var b UserBalance
+		if err := rows.Scan(&b.Currency, &b.Available, &b.Held, &b.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// UserBalanceIn returns the user's balance in one currency (zero values if none).
+func UserBalanceIn(q Queryer, userID int64, currency string) UserBalance {
+	var b UserBalance
+	b.Currency = currency
+	_ = q.QueryRow(
+		`SELECT COALESCE(SUM(CASE WHEN code = ? THEN balance END), 0),
+		        COALESCE(SUM(CASE WHEN code = ? THEN balance END), 0),
+		        COALESCE(MAX(updated_at), 0)
+		 FROM ledger_accounts WHERE user_id = ? AND currency = ? AND code IN (?, ?)`,
+		CodeMain, CodeHold, userID, currency, CodeMain, CodeHold,
+	).Scan(&b.Available, &b.Held, &b.UpdatedAt)
+	return b
+}
@@ -0,0 +1,50 @@
+package mail
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRender_EscapesHTMLAndKeepsLinks(t *testing.T) {
+	msg, err := Render(TemplateConfirmEmail, map[string]any{"Name": "<b>Ann</b>", "Link": "https://app.test/confirm?token=abc&x=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Confirm your email address" {
+		t.Errorf("subject %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "https://app.test/confirm?token=abc&x=1") || !strings.Contains(msg.Text, "Hello <b>Ann</b>") {
+		t.Errorf("text body:\n%s", msg.Text)
+	}
+	if strings.Contains(msg.HTML, "<b>Ann</b>") || !strings.Contains(msg.HTML, `href="https://app.test/confirm?token=abc&amp;x=1"`) {
+		t.Errorf("html body:\n%s", msg.HTML)
+	}
+	if _, err := Render("nope", nil); err == nil {
+		t.Error("unknown template should fail")
+	}
+}
+
+func TestMaildir_WritesMultipartMessage(t *testing.T) {
+	m, err := NewMaildir(t.TempDir(), "OMNIXIUS <no-reply@omnixius.test>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send(Message{To: "not an address", Text: "x"}); err == nil {
+		t.Error("invalid recipient should fail")
+	}
+	if err := m.Send(Message{To: "ann@example.com", Subject: "Привет", Text: "plain", HTML: "<p>html</p>"}); err != nil {
+		t.Fatal(err)
+	}
+	paths, _ := m.Delivered()
+	if len(paths) != 1 {
+		t.Fatalf("delivered %d messages, want 1", len(paths))
+	}
+	raw, _ := os.ReadFile(paths[0])
+	s := string(raw)
+	for _, want := range []string{"To: ann@example.com\r\n", "Subject: =?utf-8?q?", "multipart/alternative", "text/plain", "<p>html</p>", "@omnixius.test>"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("message missing %q:\n%s", want, s)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Maildir writes each message to Dir/new as a .eml file (Maildir layout: written to tmp, then renamed).
+// For development and tests; any mail client or `cat` can read the files.
+type Maildir struct {
+	Dir  string
+	From string
+}
+
+// NewMaildir returns a Maildir sink, creating tmp/, new/ and cur/ under dir.
+func NewMaildir(dir, from string) (*Maildir, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &Maildir{Dir: dir, From: from}, nil
+}
+
+func (m *Maildir) Send(msg Message) error {
+	body, err := msg.Bytes(m.From)
+	if err != nil {
+		return err
+	}
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + "." + randomID() + ".eml"
+	tmp := filepath.Join(m.Dir, "tmp", name)
+	if err := os.WriteFile(tmp, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(m.Dir, "new", name))
+}
+
+// Delivered returns the paths of messages in new/, oldest first.
+func (m *Maildir) Delivered() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(m.Dir, "new", "*.eml"))
+	sort.Strings(paths)
+	return paths, err
+}
@@ -0,0 +1,59 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Template names.
+const (
+	TemplateConfirmEmail  = "confirm_email"
+	TemplatePasswordReset = "password_reset"
+	TemplateNewDevice     = "new_device"
+	TemplateNotification  = "notification"
+	TemplateRecovery      = "recovery_confirm"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+// Each template has name.txt.tmpl and name.html.tmpl; both define "subject", the HTML one also "content"
+// (rendered inside layout.html.tmpl).
+var (
+	textTemplates = map[string]*texttemplate.Template{}
+	htmlTemplates = map[string]*htmltemplate.Template{}
+)
+
+func init() {
+	for _, name := range []string{TemplateConfirmEmail, TemplatePasswordReset, TemplateNewDevice, TemplateNotification, TemplateRecovery} {
+		textTemplates[name] = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/"+name+".txt.tmpl"))
+		htmlTemplates[name] = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/layout.html.tmpl", "templates/"+name+".html.tmpl"))
+	}
+}
+
+// Render builds the message for template name with data; the caller sets To.
+func Render(name string, data any) (Message, error) {
+	tt, ok := textTemplates[name]
+	if !ok {
+		return Message{}, fmt.Errorf("mail: unknown template %q", name)
+	}
+	var subject, text, html bytes.Buffer
+	if err := tt.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := tt.Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+	if err := htmlTemplates[name].ExecuteTemplate(&html, "layout.html.tmpl", data); err != nil {
+		return Message{}, err
+	}
+	return Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
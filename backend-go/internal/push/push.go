@@ -0,0 +1,60 @@
+// Package push delivers push notifications to device tokens through a pluggable Sender. Only a development
+// stand-in lives here (file); an FCM/APNs/Web Push gateway implements Sender the same way.
+package push
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrTokenInvalid is returned by a Sender when the provider rejected the token for good (app uninstalled,
+// subscription expired); the caller should forget it.
+var ErrTokenInvalid = errors.New("push: token no longer valid")
+
+// Message is one notification for one device token.
+type Message struct {
+	Token    string
+	Platform string // web, ios, android
+	Title    string
+	Body     string
+	Data     string // optional JSON
+}
+
+// Sender delivers one message.
+type Sender interface {
+	Send(m Message) error
+}
+
+// FileSender appends each message as a JSON line {"token","platform","title","body","data","sent_at"} to Path.
+type FileSender struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// NewFileSender returns a FileSender writing to path.
+func NewFileSender(path string) *FileSender {
+	return &FileSender{Path: path}
+}
+
+func (s *FileSender) Send(m Message) error {
+	line, err := json.Marshal(map[string]any{
+		"token": m.Token, "platform": m.Platform, "title": m.Title, "body": m.Body, "data": m.Data, "sent_at": time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
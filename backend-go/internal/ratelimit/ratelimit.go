@@ -0,0 +1,119 @@
+// Package ratelimit implements GCRA (generic cell rate algorithm) rate limiting. Each key keeps a single
+// timestamp, the theoretical arrival time (TAT), so a Store can live in process memory or in a shared
+// backend (e.g. one Redis key per limiter key, updated by a script) when several instances must agree.
+package ratelimit
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Limit allows Count requests per Window; the full Count may be used as a burst.
+type Limit struct {
+	Count  int
+	Window time.Duration
+}
+
+// Result describes one decision, in the shape of the RateLimit-* response headers.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the budget is full again
+	RetryAfter time.Duration // until the next request is allowed (0 when allowed)
+}
+
+// interval is the time one request "costs".
+func (l Limit) interval() time.Duration {
+	return l.Window / time.Duration(l.Count)
+}
+
+// Apply takes one request at now against a key whose stored TAT is tat (zero for a new key). It returns the
+// TAT to store and the decision; a denied request leaves the TAT unchanged.
+func (l Limit) Apply(tat, now time.Time) (time.Time, Result) {
+	if l.Count <= 0 || l.Window <= 0 {
+		return tat, Result{Allowed: true}
+	}
+	if tat.Before(now) {
+		tat = now
+	}
+	t := l.interval()
+	next := tat.Add(t)
+	if allowAt := next.Add(-l.Window); now.Before(allowAt) {
+		return tat, Result{Limit: l.Count, Reset: tat.Sub(now), RetryAfter: allowAt.Sub(now)}
+	}
+	used := next.Sub(now)
+	return next, Result{
+		Allowed:   true,
+		Limit:     l.Count,
+		Remaining: int((l.Window - used) / t),
+		Reset:     used,
+	}
+}
+
+// Store keeps limiter state. Take must apply l to key atomically.
+type Store interface {
+	Take(key string, l Limit, now time.Time) (Result, error)
+}
+
+type entry struct {
+	key string
+	tat time.Time
+}
+
+// MemoryStore is an in-process Store bounded to maxKeys entries. A key whose budget has fully refilled
+// (TAT in the past) carries no state and is dropped; beyond maxKeys the least recently used key is evicted.
+type MemoryStore struct {
+	mu      sync.Mutex
+	maxKeys int
+	order   *list.List // front = most recently used
+	keys    map[string]*list.Element
+}
+
+// NewMemoryStore returns an empty MemoryStore holding at most maxKeys keys (minimum 1).
+func NewMemoryStore(maxKeys int) *MemoryStore {
+	if maxKeys < 1 {
+		maxKeys = 1
+	}
+	return &MemoryStore{maxKeys: maxKeys, order: list.New(), keys: make(map[string]*list.Element)}
+}
+
+// Take implements Store.
+func (s *MemoryStore) Take(key string, l Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tat time.Time
+	el, ok := s.keys[key]
+	if ok {
+		tat = el.Value.(*entry).tat
+	}
+	tat, res := l.Apply(tat, now)
+	if ok {
+		el.Value.(*entry).tat = tat
+		s.order.MoveToFront(el)
+	} else {
+		s.keys[key] = s.order.PushFront(&entry{key: key, tat: tat})
+	}
+	s.evict(now)
+	return res, nil
+}
+
+// evict drops expired keys from the cold end and keeps the store within maxKeys.
+func (s *MemoryStore) evict(now time.Time) {
+	for el := s.order.Back(); el != nil; el = s.order.Back() {
+		e := el.Value.(*entry)
+		if len(s.keys) <= s.maxKeys && e.tat.After(now) {
+			return
+		}
+		s.order.Remove(el)
+		delete(s.keys, e.key)
+	}
+}
+
+// Len returns the number of keys held.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.keys)
+}
@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_BurstRefillAndRetryAfter(t *testing.T) {
+	s := NewMemoryStore(10)
+	l := Limit{Count: 5, Window: 5 * time.Minute}
+	now := time.Unix(1_700_000_000, 0)
+	for i := 4; i >= 0; i-- {
+		r, _ := s.Take("ip:1", l, now)
+		if !r.Allowed || r.Remaining != i || r.Limit != 5 {
+			t.Fatalf("request %d: %+v", 5-i, r)
+		}
+	}
+	r, _ := s.Take("ip:1", l, now)
+	if r.Allowed || r.RetryAfter != time.Minute || r.Reset != 5*time.Minute {
+		t.Fatalf("over budget: %+v", r)
+	}
+	if r, _ := s.Take("ip:2", l, now); !r.Allowed {
+		t.Error("keys must not share a budget")
+	}
+	if r, _ := s.Take("ip:1", l, now.Add(time.Minute)); !r.Allowed || r.Remaining != 0 {
+		t.Errorf("after one interval: %+v", r)
+	}
+	if r, _ := s.Take("ip:1", l, now.Add(time.Hour)); !r.Allowed || r.Remaining != 4 {
+		t.Errorf("after full refill: %+v", r)
+	}
+}
+
+func TestMemoryStore_EvictsExpiredAndLeastRecentlyUsed(t *testing.T) {
+	s := NewMemoryStore(3)
+	l := Limit{Count: 2, Window: time.Minute}
+	now := time.Unix(1_700_000_000, 0)
+	for i := 0; i < 3; i++ {
+		s.Take("k"+strconv.Itoa(i), l, now)
+	}
+	s.Take("k0", l, now) // k0 is now the most recently used and exhausted
+	s.Take("k3", l, now)
+	if s.Len() != 3 {
+		t.Fatalf("len = %d, want 3", s.Len())
+	}
+	if r, _ := s.Take("k0", l, now); r.Allowed {
+		t.Error("recently used key was evicted")
+	}
+	// Once every budget has refilled, stale keys are dropped.
+	s.Take("k4", l, now.Add(time.Hour))
+	if s.Len() != 1 {
+		t.Errorf("len after refill = %d, want 1", s.Len())
+	}
+}
@@ -0,0 +1,53 @@
+// Package sms sends text messages through a pluggable Sender. Only development stand-ins live here
+// (log and file); a carrier gateway implements Sender the same way.
+package sms
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Sender delivers one text message to an E.164 number.
+type Sender interface {
+	Send(to, text string) error
+}
+
+// LogSender writes messages to the process log.
+type LogSender struct{}
+
+func (LogSender) Send(to, text string) error {
+	log.Printf("SMS to %s: %s", to, text)
+	return nil
+}
+
+// FileSender appends each message as a JSON line {"to","text","sent_at"} to Path.
+type FileSender struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// NewFileSender returns a FileSender writing to path.
+func NewFileSender(path string) *FileSender {
+	return &FileSender{Path: path}
+}
+
+func (s *FileSender) Send(to, text string) error {
+	line, err := json.Marshal(map[string]any{"to": to, "text": text, "sent_at": time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,35 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// RFC 6238 Appendix B, SHA-1 seed "12345678901234567890" (8-digit vectors, last 6 digits).
+func TestCodeAt_RFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	for unix, want := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"} {
+		got, err := CodeAt(secret, unix/Period)
+		if err != nil || got != want {
+			t.Errorf("t=%d: got %s (err %v), want %s", unix, got, err, want)
+		}
+	}
+}
+
+func TestValidate_SkewAndReplay(t *testing.T) {
+	secret, _ := GenerateSecret()
+	now := time.Now()
+	prev, _ := CodeAt(secret, Step(now)-1)
+	step, ok := Validate(secret, prev, now, 0)
+	if !ok || step != Step(now)-1 {
+		t.Fatalf("previous step code rejected")
+	}
+	if _, ok := Validate(secret, prev, now, step); ok {
+		t.Error("replayed code accepted")
+	}
+	old, _ := CodeAt(secret, Step(now)-3)
+	if _, ok := Validate(secret, old, now, 0); ok {
+		t.Error("code outside the skew window accepted")
+	}
+}
@@ -0,0 +1,98 @@
+// Package useragent classifies User-Agent strings coarsely (browser family, OS, device type). It is meant
+// for showing sessions to users and spotting unfamiliar clients, not for feature detection.
+package useragent
+
+import "strings"
+
+// Device types.
+const (
+	Desktop = "desktop"
+	Mobile  = "mobile"
+	Tablet  = "tablet"
+	Bot     = "bot"
+	CLI     = "cli" // curl, scripts, HTTP libraries
+	Unknown = "unknown"
+)
+
+// Info is the parsed form of a User-Agent.
+type Info struct {
+	Browser string // e.g. "Chrome", "Firefox", "Safari", "Edge", "curl"; "" if unknown
+	OS      string // e.g. "Windows", "macOS", "iOS", "Android", "Linux"; "" if unknown
+	Device  string // one of the device type constants
+}
+
+// Family is "Browser/OS" with "Other" for unknown parts, stable across browser and OS version upgrades.
+func (i Info) Family() string {
+	b, o := i.Browser, i.OS
+	if b == "" {
+		b = "Other"
+	}
+	if o == "" {
+		o = "Other"
+	}
+	return b + "/" + o
+}
+
+// Label is a human-readable description, e.g. "Chrome on Windows".
+func (i Info) Label() string {
+	switch {
+	case i.Browser != "" && i.OS != "":
+		return i.Browser + " on " + i.OS
+	case i.Browser != "":
+		return i.Browser
+	case i.OS != "":
+		return i.OS
+	}
+	return "Unknown client"
+}
+
+// Parse classifies ua. Order matters: Edge and Opera also send "Chrome", Chrome also sends "Safari".
+func Parse(ua string) Info {
+	s := strings.ToLower(ua)
+	if s == "" {
+		return Info{Device: Unknown}
+	}
+	var info Info
+	for _, b := range []struct{ token, name string }{
+		{"edg/", "Edge"}, {"opr/", "Opera"}, {"samsungbrowser", "Samsung Internet"}, {"firefox/", "Firefox"},
+		{"fxios/", "Firefox"}, {"crios/", "Chrome"}, {"chrome/", "Chrome"}, {"safari/", "Safari"},
+		{"curl/", "curl"}, {"wget/", "Wget"}, {"python-requests", "Python"}, {"go-http-client", "Go"},
+		{"okhttp", "OkHttp"}, {"postmanruntime", "Postman"},
+	} {
+		if strings.Contains(s, b.token) {
+			info.Browser = b.name
+			break
+		}
+	}
+	switch {
+	case strings.Contains(s, "iphone"), strings.Contains(s, "ipad"), strings.Contains(s, "ipod"):
+		info.OS = "iOS"
+	case strings.Contains(s, "android"):
+		info.OS = "Android"
+	case strings.Contains(s, "windows"):
+		info.OS = "Windows"
+	case strings.Contains(s, "mac os x"), strings.Contains(s, "macintosh"):
+		info.OS = "macOS"
+	case strings.Contains(s, "cros"):
+		info.OS = "ChromeOS"
+	case strings.Contains(s, "linux"):
+		info.OS = "Linux"
+	}
+	switch {
+	case strings.Contains(s, "bot"), strings.Contains(s, "spider"), strings.Contains(s, "crawl"):
+		info.Device = Bot
+	case strings.Contains(s, "ipad"), strings.Contains(s, "tablet"),
+		info.OS == "Android" && !strings.Contains(s, "mobile"):
+		info.Device = Tablet
+	case strings.Contains(s, "mobi"), strings.Contains(s, "iphone"), strings.Contains(s, "ipod"):
+		info.Device = Mobile
+	case info.Browser == "curl" || info.Browser == "Wget" || info.Browser == "Python" || info.Browser == "Go" ||
+		info.Browser == "OkHttp" || info.Browser == "Postman":
+		info.Device = CLI
+	case info.OS != "":
+		info.Device = Desktop
+	default:
+		info.Device = Unknown
+	}
+	return info
+}
@@ -0,0 +1,24 @@
+package useragent
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		ua, family, device string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", "Chrome/Windows", Desktop},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0", "Edge/Windows", Desktop},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1", "Safari/iOS", Mobile},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", "Chrome/Android", Mobile},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14.1; rv:121.0) Gecko/20100101 Firefox/121.0", "Firefox/macOS", Desktop},
+		{"curl/8.4.0", "curl/Other", CLI},
+		{"Googlebot/2.1 (+http://www.google.com/bot.html)", "Other/Other", Bot},
+		{"", "Other/Other", Unknown},
+	}
+	for _, tc := range cases {
+		info := Parse(tc.ua)
+		if info.Family() != tc.family || info.Device != tc.device {
+			t.Errorf("Parse(%q) = %s %s, want %s %s", tc.ua, info.Family(), info.Device, tc.family, tc.device)
+		}
+	}
+}
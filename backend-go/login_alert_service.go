@@ -0,0 +1,233 @@
+// New-device sign-in alerts (§1.1): every new session is compared with the account's known devices and recent
+// sessions by user agent family and IP prefix. An unfamiliar client triggers an in-app notification and an
+// email whose "this wasn't me" link signs the account out everywhere and requires a password reset.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"time"
+
+	"omnixius-api/db"
+	"omnixius-api/internal/mail"
+	"omnixius-api/internal/useragent"
+)
+
+const (
+	loginAlertTTL         = 7 * 24 * time.Hour
+	loginHistorySessions  = 100 // most recent sessions compared with a new sign-in
+	passwordResetTokenTTL = time.Hour
+)
+
+var (
+	ErrLoginAlertInvalid     = errors.New("alert link invalid, expired or already used")
+	ErrPasswordResetRequired = errors.New("password reset required; use the link sent to your email")
+)
+
+// checkLoginAllowed refuses a new sign-in for a banned account (*BanError) or one that must reset its password
+// after a reported sign-in (ErrPasswordResetRequired). Every login path calls it before opening a session.
+func checkLoginAllowed(userID int64) error {
+	if err := checkBan(userID); err != nil {
+		return err
+	}
+	var resetRequired int
+	if err := db.DB.QueryRow("SELECT password_reset_required FROM users WHERE id = ?", userID).Scan(&resetRequired); err != nil {
+		return err
+	}
+	if resetRequired == 1 {
+		return ErrPasswordResetRequired
+	}
+	return nil
+}
+
+// ipPrefix is the network a sign-in came from: /24 for IPv4, /48 for IPv6 ("" if ip does not parse).
+func ipPrefix(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	p, _ := addr.Prefix(bits)
+	return p.String()
+}
+
+// noteLoginDevice records the client of a new session in devices and alerts the user when the account has
+// signed in before but never from this user agent family. Recovery sessions are recorded without an alert
+// (the restore already emails the user). Errors are logged: a failed alert must not fail the login.
+func noteLoginDevice(userID, sessionID int64, kind string, client ClientInfo) {
+	info := useragent.Parse(client.UserAgent)
+	family, prefix := info.Family(), ipPrefix(client.IP)
+	now := time.Now()
+
+	var deviceID int64
+	var history, knownNetwork int
+	db.DB.QueryRow("SELECT id FROM devices WHERE user_id = ? AND ua_family = ? ORDER BY last_used DESC LIMIT 1", userID, family).Scan(&deviceID)
+	db.DB.QueryRow("SELECT COUNT(*) FROM devices WHERE user_id = ?", userID).Scan(&history)
+	if prefix != "" {
+		db.DB.QueryRow("SELECT COUNT(*) FROM devices WHERE user_id = ? AND ip_prefix = ?", userID, prefix).Scan(&knownNetwork)
+	}
+	knownDevice := deviceID != 0
+	rows, err := db.DB.Query("SELECT COALESCE(user_agent, ''), COALESCE(ip, '') FROM sessions WHERE user_id = ? AND id != ? ORDER BY last_used_at DESC LIMIT ?",
+		userID, sessionID, loginHistorySessions)
+	if err == nil {
+		for rows.Next() {
+			var ua, ip string
+			if rows.Scan(&ua, &ip) != nil {
+				continue
+			}
+			history++
+			knownDevice = knownDevice || useragent.Parse(ua).Family() == family
+			if prefix != "" && ipPrefix(ip) == prefix {
+				knownNetwork++
+			}
+		}
+		rows.Close()
+	}
+
+	if deviceID != 0 {
+		db.DB.Exec("UPDATE devices SET last_used = ?, last_ip = ?, ip_prefix = ? WHERE id = ?", now.Unix(), nullStr(client.IP), nullStr(prefix), deviceID)
+	} else {
+		res, err := db.DB.Exec(
+			"INSERT INTO devices (user_id, name, ua_family, ip_prefix, last_ip, last_used, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			userID, info.Label(), family, nullStr(prefix), nullStr(client.IP), now.Unix(), now.Unix(),
+		)
+		if err != nil {
+			log.Printf("Login alerts: record device for user %d: %v", userID, err)
+			return
+		}
+		deviceID, _ = res.LastInsertId()
+	}
+	if history == 0 || knownDevice || kind == "recovery" {
+		return
+	}
+	if err := sendLoginAlert(userID, sessionID, deviceID, info, client, knownNetwork == 0, now); err != nil {
+		log.Printf("Login alerts: user %d: %v", userID, err)
+	}
+}
+
+// sendLoginAlert stores the alert and notifies the user in-app and by email (email is sent regardless of
+// notification settings: it is a security message).
+func sendLoginAlert(userID, sessionID, deviceID int64, info useragent.Info, client ClientInfo, suspicious bool, now time.Time) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	tok := hex.EncodeToString(b)
+	ua := client.UserAgent
+	if len(ua) > 512 {
+		ua = ua[:512]
+	}
+	susp := 0
+	if suspicious {
+		susp = 1
+	}
+	res, err := db.DB.Exec(
+		`INSERT INTO login_alerts (user_id, session_id, device_id, token_hash, ip, user_agent, ua_family, suspicious, created_at, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, sessionID, deviceID, sha256Hex(tok), nullStr(client.IP), nullStr(ua), info.Family(), susp, now.Unix(), now.Add(loginAlertTTL).Unix(),
+	)
+	if err != nil {
+		return err
+	}
+	alertID, _ := res.LastInsertId()
+	ip := client.IP
+	if ip == "" {
+		ip = "unknown"
+	}
+	body := "IP address " + ip + ". If this wasn't you, use the link in the email we sent you."
+	if suspicious {
+		body = "Unfamiliar device and network, IP address " + ip + ". If this wasn't you, use the link in the email we sent you."
+	}
+	data, _ := json.Marshal(map[string]any{"alert_id": alertID, "session_id": sessionID, "suspicious": suspicious})
+	if err := enqueueNotification(userID, "login_new_device", "in_app", "New sign-in from "+info.Label(), body, string(data)); err != nil {
+		return err
+	}
+	var email string
+	if db.DB.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email) == nil && email != "" {
+		sendTemplateMailAsync(email, mail.TemplateNewDevice, map[string]any{
+			"Device":     info.Label(),
+			"IP":         ip,
+			"Time":       now.UTC().Format("2 Jan 2006 15:04 UTC"),
+			"Suspicious": suspicious,
+			"Link":       appLink("/app/login-alert.html", url.Values{"token": {tok}}),
+		})
+	}
+	auditLog(userID, "login.new_device", "session", strconv.FormatInt(sessionID, 10), info.Family()+" "+client.IP)
+	return nil
+}
+
+// ReportLoginAlert handles "this wasn't me": the account may be compromised, so every session (with its refresh
+// tokens), OAuth grant and personal access token is revoked, the alerted device forgotten and a password reset
+// required (a reset link is emailed). Sign-in methods added since the reported session opened (passkeys, a new
+// recovery key, TOTP enrollment and backup codes) are removed. The link works once.
+func ReportLoginAlert(token, ip string) (int64, error) {
+	if token == "" {
+		return 0, ErrLoginAlertInvalid
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	var alertID, userID, sessionID, deviceID, since int64
+	var email string
+	err = tx.QueryRow(
+		`SELECT a.id, a.user_id, COALESCE(a.session_id, 0), COALESCE(a.device_id, 0), u.email, COALESCE(s.created_at, a.created_at)
+		 FROM login_alerts a JOIN users u ON u.id = a.user_id LEFT JOIN sessions s ON s.id = a.session_id
+		 WHERE a.token_hash = ? AND a.reported_at IS NULL AND a.expires_at > ?`,
+		sha256Hex(token), now.Unix(),
+	).Scan(&alertID, &userID, &sessionID, &deviceID, &email, &since)
+	if err != nil {
+		return 0, ErrLoginAlertInvalid
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return 0, err
+	}
+	resetToken := hex.EncodeToString(b)
+	revoked, err := revokeAllAccessTx(tx, userID, userID)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("DELETE FROM webauthn_credentials WHERE user_id = ? AND created_at >= ?", userID, since)
+	if err != nil {
+		return 0, err
+	}
+	passkeys := mustRows(res)
+	for _, st := range []struct {
+		q    string
+		args []any
+	}{
+		{"UPDATE login_alerts SET reported_at = ? WHERE id = ?", []any{now.Unix(), alertID}},
+		{"DELETE FROM devices WHERE id = ? AND user_id = ?", []any{deviceID, userID}},
+		{"DELETE FROM user_recovery WHERE user_id = ? AND created_at >= ?", []any{userID, since}},
+		{"DELETE FROM user_totp WHERE user_id = ? AND created_at >= ?", []any{userID, since}},
+		{"DELETE FROM user_backup_codes WHERE user_id = ? AND created_at >= ?", []any{userID, since}},
+		{"DELETE FROM mfa_challenges WHERE user_id = ?", []any{userID}},
+		{"DELETE FROM recovery_confirmations WHERE user_id = ?", []any{userID}},
+		{"UPDATE users SET password_reset_required = 1, reset_token = ?, reset_token_expires = ? WHERE id = ?",
+			[]any{resetToken, now.Add(passwordResetTokenTTL).Unix(), userID}},
+	} {
+		if _, err := tx.Exec(st.q, st.args...); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	DisconnectUser(userID)
+	auditLog(userID, "login.reported", "session", strconv.FormatInt(sessionID, 10), ip+" revoked_grants="+strconv.Itoa(revoked)+" removed_passkeys="+strconv.FormatInt(passkeys, 10))
+	sendPasswordResetEmail(email, resetToken)
+	return userID, nil
+}
@@ -0,0 +1,90 @@
+// Transactional email: confirmation, password reset, new-device and notification mails go through mailer.
+package main
+
+import (
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+
+	"omnixius-api/internal/mail"
+)
+
+var (
+	mailer      mail.Mailer
+	pendingMail sync.WaitGroup // background sends in flight
+)
+
+// initMailer uses SMTP when SMTP_HOST is set, otherwise a Maildir sink under MAIL_DIR (dev and tests).
+func initMailer() error {
+	if cfg.SMTPHost != "" {
+		mailer = mail.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+		log.Printf("Mail: SMTP %s:%d", cfg.SMTPHost, cfg.SMTPPort)
+		return nil
+	}
+	m, err := mail.NewMaildir(cfg.MailDir, cfg.MailFrom)
+	if err != nil {
+		return err
+	}
+	mailer = m
+	log.Printf("Mail: SMTP_HOST not set; writing messages to %s", m.Dir)
+	return nil
+}
+
+// appLink returns an absolute link into the frontend app (APP_URL, or this server when unset).
+func appLink(path string, query url.Values) string {
+	base := cfg.AppURL
+	if base == "" {
+		base = "http://localhost:" + cfg.Port
+	}
+	link := base + path
+	if len(query) > 0 {
+		link += "?" + query.Encode()
+	}
+	return link
+}
+
+// sendTemplateMail renders template name with data and sends it to to.
+func sendTemplateMail(to, name string, data map[string]any) error {
+	msg, err := mail.Render(name, data)
+	if err != nil {
+		return err
+	}
+	msg.To = to
+	return mailer.Send(msg)
+}
+
+// sendTemplateMailAsync sends in the background and logs failures, so a slow SMTP server does not hold the
+// request and response times do not reveal whether an account exists.
+func sendTemplateMailAsync(to, name string, data map[string]any) {
+	pendingMail.Add(1)
+	go func() {
+		defer pendingMail.Done()
+		if err := sendTemplateMail(to, name, data); err != nil {
+			log.Printf("Mail: %s to %s failed: %v", name, maskEmail(to), err)
+		}
+	}()
+}
+
+func sendConfirmEmail(to, name, token string) {
+	sendTemplateMailAsync(to, mail.TemplateConfirmEmail, map[string]any{
+		"Name": name,
+		"Link": appLink("/app/confirm-email.html", url.Values{"token": {token}}),
+	})
+}
+
+func sendPasswordResetEmail(to, token string) {
+	sendTemplateMailAsync(to, mail.TemplatePasswordReset, map[string]any{
+		"Link":      appLink("/app/reset-password.html", url.Values{"token": {token}}),
+		"ExpiresIn": "1 hour",
+	})
+}
+
+// maskEmail keeps logs free of full addresses: "an***@example.com".
+func maskEmail(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at <= 2 {
+		return "***" + email[max(at, 0):]
+	}
+	return email[:2] + "***" + email[at:]
+}
@@ -19,25 +19,20 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"omnixius-api/db"
-	"omnixius-api/pqc"
+	"omnixius-api/internal/ledger"
+	"omnixius-api/internal/useragent"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
-	"golang.org/x/time/rate"
 )
 
 var cfg Config
 
-// per-IP login rate limit: 5 attempts per 15 min
-var loginLimitMu sync.Mutex
-var loginLimiters = make(map[string]*rate.Limiter)
-
 func main() {
 	cfg = LoadConfig()
 	if !filepath.IsAbs(cfg.SiteRoot) {
@@ -52,12 +47,29 @@ func main() {
 	if err := db.Open(dbPath); err != nil {
 		panic(err)
 	}
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		os.Exit(runKeysCommand(os.Args[2:]))
+	}
+	if err := initKeyring(); err != nil {
+		log.Fatal("PQC keyring: ", err)
+	}
+	startKeyringReloader(time.Minute)
 	db.InitUploadDirs(cfg.UploadDir)
 	if err := initWebAuthn(); err != nil {
 		log.Printf("WebAuthn init skipped: %v (Passkeys endpoints will return 503)", err)
 	}
+	if err := initMailer(); err != nil {
+		log.Fatal("Mail: ", err)
+	}
+	initSMSSender()
+	initPushSender()
+	initRateLimiter()
 
 	initWSHub()
+	initEventBus()
+	startEventRelay(cfg.OutboxPollInterval)
+	startNotificationDispatcher(cfg.NotifyPollInterval)
+	startHoldExpirySweeper(cfg.HoldSweepInterval)
 	// Stack order: Rust first. Ping Rust service if configured.
 	if cfg.RustServiceURL != "" {
 		client := &http.Client{Timeout: 2 * time.Second}
@@ -84,14 +96,22 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
+	r.GET("/.well-known/jwks.json", handleJWKS)
+
 	r.GET("/register", handleRegisterPage)
 	r.POST("/register", handleRegisterForm)
 	r.GET("/login", handleLoginPage)
 	r.POST("/login", handleLoginForm)
+	r.GET("/oauth/authorize", handleOAuthAuthorize)
+	r.POST("/oauth/authorize", handleOAuthAuthorize)
+	r.POST("/oauth/token", handleOAuthToken)
+	r.POST("/oauth/revoke", handleOAuthRevoke)
 
 	api := r.Group("/api")
 	api.POST("/auth/register", handleRegister)
 	api.POST("/auth/login", handleLogin)
+	api.POST("/auth/login/2fa", handleLoginMFA)
+	api.POST("/auth/refresh", handleAuthRefresh)
 	api.POST("/auth/register/begin", handlePasskeyRegisterBegin)
 	api.POST("/auth/register/complete", handlePasskeyRegisterComplete)
 	api.POST("/auth/login/begin", handlePasskeyLoginBegin)
@@ -101,6 +121,8 @@ func main() {
 	api.POST("/auth/reset-password", handleResetPassword)
 	api.POST("/auth/recovery/verify", handleRecoveryVerify)
 	api.POST("/auth/recovery/restore", handleRecoveryRestore)
+	api.POST("/auth/recovery/confirm", handleRecoveryConfirm)
+	api.POST("/auth/login-alerts/report", handleLoginAlertReport)
 	api.POST("/seed-test-user", handleSeedTestUser)
 
 	auth := api.Group("")
@@ -109,17 +131,38 @@ func main() {
 	auth.PATCH("/users/me", handleUserUpdate)
 	auth.DELETE("/users/me", handleUserDelete)
 	auth.GET("/auth/sessions", handleAuthSessionsList)
+	auth.POST("/auth/sessions/revoke-others", handleAuthSessionsRevokeOthers)
 	auth.DELETE("/auth/sessions/:id", handleAuthSessionDelete)
+	auth.GET("/auth/passkeys", handlePasskeysList)
+	auth.PATCH("/auth/passkeys/:id", handlePasskeyRename)
+	auth.DELETE("/auth/passkeys/:id", handlePasskeyDelete)
 	auth.GET("/auth/devices", handleAuthDevicesList)
 	auth.DELETE("/auth/devices/:id", handleAuthDeviceDelete)
 	auth.POST("/auth/recovery/generate", handleRecoveryGenerate)
+	auth.GET("/auth/tokens", handlePATList)
+	auth.POST("/auth/tokens", handlePATCreate)
+	auth.DELETE("/auth/tokens/:id", handlePATRevoke)
 	auth.POST("/auth/change-password", handleChangePassword)
+	auth.GET("/oauth/clients", handleOAuthClientsList)
+	auth.POST("/oauth/clients", handleOAuthClientCreate)
+	auth.DELETE("/oauth/clients/:id", handleOAuthClientDelete)
+	auth.GET("/oauth/grants", handleOAuthGrantsList)
+	auth.DELETE("/oauth/grants/:id", handleOAuthGrantRevoke)
+	auth.POST("/auth/confirm-email/resend", handleConfirmEmailResend)
+	auth.GET("/auth/2fa", handleMFAStatus)
+	auth.POST("/auth/2fa/totp/enroll", handleMFAEnroll)
+	auth.POST("/auth/2fa/totp/confirm", handleMFAConfirm)
+	auth.POST("/auth/2fa/disable", handleMFADisable)
+	auth.POST("/auth/2fa/backup-codes", handleMFABackupCodes)
 	api.GET("/ws", handleWSWithQueryToken)
 	auth.GET("/users/me/orders", handleUserOrders)
 	auth.GET("/users/me/balance", handleBalanceGet)
 	auth.POST("/users/me/balance/credit", handleBalanceCredit)
 	auth.POST("/users/me/avatar", handleUserAvatar)
 	auth.POST("/users/me/heartbeat", handleUserHeartbeat)
+	auth.POST("/users/me/phone", handlePhoneStart)
+	auth.POST("/users/me/phone/verify", handlePhoneVerify)
+	auth.DELETE("/users/me/phone", handlePhoneRemove)
 
 	api.GET("/professionals/search", handleProfessionalsSearch)
 
@@ -127,24 +170,25 @@ func main() {
 	api.GET("/products/categories", handleProductsCategories)
 	api.GET("/products/:id", handleProductGet)
 	auth.GET("/products/:id/closed-content", handleProductClosedContent)
-	auth.POST("/products", handleProductCreate)
+	auth.POST("/products", verifiedEmailRequired(), handleProductCreate)
 	auth.PATCH("/products/:id", handleProductUpdate)
 	auth.DELETE("/products/:id", handleProductDelete)
 	api.GET("/products/:id/slots", handleSlotsList)
-	auth.POST("/products/:id/slots", handleSlotsAdd)
-	auth.POST("/products/:id/slots/:sid/book", handleSlotBook)
+	auth.POST("/products/:id/slots", verifiedEmailRequired(), handleSlotsAdd)
+	auth.POST("/products/:id/slots/:sid/book", verifiedEmailRequired(), handleSlotBook)
 
 	api.GET("/users/:id", handleUserPublic)
-	auth.POST("/subscriptions", handleSubscriptionCreate)
+	auth.POST("/subscriptions", verifiedEmailRequired(), handleSubscriptionCreate)
 	auth.GET("/subscriptions/my", handleSubscriptionsMy)
 
 	auth.GET("/orders/my", handleOrdersMy)
 	auth.GET("/orders/:id", handleOrderGet)
-	auth.POST("/orders", handleOrderCreate)
+	auth.POST("/orders", verifiedEmailRequired(), handleOrderCreate)
 	auth.PATCH("/orders/:id", handleOrderUpdate)
+	auth.POST("/orders/:id/pay", verifiedEmailRequired(), idempotent(), handleOrderPay)
 
 	auth.GET("/remittances/my", handleRemittancesMy)
-	auth.POST("/remittances", handleRemittanceCreate)
+	auth.POST("/remittances", verifiedEmailRequired(), handleRemittanceCreate)
 
 	auth.GET("/conversations", handleConversationsList)
 	auth.GET("/conversations/unread-count", handleConversationsUnreadCount)
@@ -174,13 +218,15 @@ func main() {
 	auth.GET("/wallet/balances/:currency", handleWalletBalanceByCurrency)
 	auth.GET("/wallet/transactions", handleWalletTransactions)
 	auth.GET("/wallet/transactions/:id", handleWalletTransactionByID)
-	auth.POST("/wallet/transfer", handleWalletTransfer)
+	auth.POST("/wallet/transfer", verifiedEmailRequired(), idempotent(), handleWalletTransfer)
 	auth.POST("/wallet/transfer/verify", handleWalletTransferVerify)
 	auth.GET("/wallet/deposit/addresses", handleWalletDepositAddressesList)
 	auth.POST("/wallet/deposit/addresses", handleWalletDepositAddressCreate)
-	auth.POST("/wallet/hold", handleWalletHold)
+	auth.POST("/wallet/hold", idempotent(), handleWalletHold)
 	auth.POST("/wallet/hold/:id/release", handleWalletHoldRelease)
-	auth.POST("/wallet/hold/:id/capture", handleWalletHoldCapture)
+	auth.POST("/wallet/hold/:id/capture", idempotent(), handleWalletHoldCapture)
+	auth.GET("/wallet/holds", handleWalletHoldsList)
+	auth.GET("/wallet/holds/:id", handleWalletHoldGet)
 	auth.POST("/wallet/export", handleWalletExport)
 	auth.POST("/wallet/import", handleWalletImport)
 
@@ -195,15 +241,33 @@ func main() {
 	auth.POST("/notifications/test", handleNotificationsTest)
 
 	// Admin (§18) — requires admin role
-	adminGroup := api.Group("/admin", authRequired(), adminRequired())
-	adminGroup.GET("/stats", handleAdminStats)
-	adminGroup.GET("/reports", handleAdminReportsList)
-	adminGroup.GET("/reports/:id", handleAdminReportGet)
-	adminGroup.POST("/reports/:id/assign", handleAdminReportAssign)
-	adminGroup.POST("/reports/:id/resolve", handleAdminReportResolve)
-	adminGroup.GET("/users/:id", handleAdminUserGet)
-	adminGroup.POST("/users/:id/ban", handleAdminUserBan)
-	adminGroup.POST("/users/:id/unban", handleAdminUserUnban)
+	// Admin routes check one permission each (rbac_service.go); users.role = admin holds all of them.
+	adminGroup := api.Group("/admin", authRequired())
+	adminGroup.GET("/stats", permissionRequired(PermStatsRead), handleAdminStats)
+	adminGroup.GET("/reports", permissionRequired(PermReportsRead), handleAdminReportsList)
+	adminGroup.GET("/reports/:id", permissionRequired(PermReportsRead), handleAdminReportGet)
+	adminGroup.POST("/reports/:id/assign", permissionRequired(PermReportsManage), handleAdminReportAssign)
+	adminGroup.POST("/reports/:id/resolve", permissionRequired(PermReportsManage), handleAdminReportResolve)
+	adminGroup.POST("/products/:id/takedown", permissionRequired(PermProductsTakedown), handleAdminProductTakedown)
+	adminGroup.POST("/products/:id/restore", permissionRequired(PermProductsTakedown), handleAdminProductRestore)
+	adminGroup.GET("/orders/:id", permissionRequired(PermOrdersRead), handleAdminOrderGet)
+	adminGroup.GET("/users/:id", permissionRequired(PermUsersRead), handleAdminUserGet)
+	adminGroup.GET("/users/:id/orders", permissionRequired(PermOrdersRead), handleAdminUserOrders)
+	adminGroup.GET("/users/:id/wallet", permissionRequired(PermWalletRead), handleAdminUserWallet)
+	adminGroup.POST("/users/:id/wallet/adjust", permissionRequired(PermWalletAdjust), idempotent(), handleAdminWalletAdjust)
+	adminGroup.POST("/users/:id/ban", permissionRequired(PermUsersBan), handleAdminUserBan)
+	adminGroup.POST("/users/:id/unban", permissionRequired(PermUsersBan), handleAdminUserUnban)
+	adminGroup.GET("/oauth/grants", permissionRequired(PermOAuthManage), handleAdminOAuthGrants)
+	adminGroup.POST("/oauth/grants/:id/revoke", permissionRequired(PermOAuthManage), handleAdminOAuthGrantRevoke)
+	adminGroup.GET("/keys", adminRequired(), handleAdminKeysList)
+	adminGroup.POST("/keys/rotate", adminRequired(), handleAdminKeysRotate)
+	adminGroup.GET("/permissions", adminRequired(), handleAdminPermissions)
+	adminGroup.GET("/roles", adminRequired(), handleAdminRolesList)
+	adminGroup.PUT("/roles/:name", adminRequired(), handleAdminRolePut)
+	adminGroup.DELETE("/roles/:name", adminRequired(), handleAdminRoleDelete)
+	adminGroup.GET("/users/:id/roles", adminRequired(), handleAdminUserRoles)
+	adminGroup.POST("/users/:id/roles", adminRequired(), handleAdminUserRoleGrant)
+	adminGroup.DELETE("/users/:id/roles/:role", adminRequired(), handleAdminUserRoleRevoke)
 	api.POST("/reports", authRequired(), handleReportCreate)
 
 	spaRoot := filepath.Join(cfg.SiteRoot, "web", "dist")
@@ -344,7 +408,8 @@ func corsMiddleware() gin.HandlerFunc {
 			}
 		}
 		c.Header("Access-Control-Allow-Methods", "GET,POST,PATCH,DELETE,OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key")
+		c.Header("Access-Control-Expose-Headers", "Idempotent-Replayed")
 		c.Header("Access-Control-Allow-Credentials", "true")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -354,18 +419,6 @@ func corsMiddleware() gin.HandlerFunc {
 	}
 }
 
-func rateLimitMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		limiter := rate.NewLimiter(rate.Every(time.Minute/200), 200)
-		if !limiter.Allow() {
-			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
-			c.Abort()
-			return
-		}
-		c.Next()
-	}
-}
-
 func authRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tok := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
@@ -374,34 +427,82 @@ func authRequired() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-		uid, _, sessionID, err := pqc.VerifyToken(cfg.PQCPublicKey, tok)
-		if err != nil {
-			c.JSON(401, gin.H{"error": "Invalid token"})
+		var uid, sessionID int64
+		var scopes []string
+		if strings.HasPrefix(tok, patPrefix) {
+			var err error
+			if uid, scopes, err = AuthenticatePAT(tok, c.ClientIP()); err != nil {
+				c.JSON(401, gin.H{"error": "Invalid token"})
+				c.Abort()
+				return
+			}
+		} else {
+			claims, err := verifyAccessToken(tok)
+			if err != nil {
+				c.JSON(401, gin.H{"error": "Invalid token"})
+				c.Abort()
+				return
+			}
+			uid, sessionID, scopes = claims.UserID, claims.SessionID, claims.Scopes
+			if claims.GrantID != 0 {
+				if !oauthGrantActive(claims.GrantID, uid) {
+					c.JSON(401, gin.H{"error": "Authorization revoked"})
+					c.Abort()
+					return
+				}
+				if scopes == nil {
+					scopes = []string{}
+				}
+			}
+		}
+		// Scoped tokens reach only the route groups their scopes cover (see scopeGroups).
+		if scopes != nil && !scopeAllowed(scopes, c.Request.Method, c.FullPath()) {
+			c.JSON(403, gin.H{"error": "Token scope does not allow this request", "code": "insufficient_scope", "required_scope": requiredScope(c.Request.Method, c.FullPath())})
 			c.Abort()
 			return
 		}
 		if sessionID != 0 {
-			var n int
-			if db.DB.QueryRow("SELECT 1 FROM sessions WHERE id = ? AND user_id = ? AND expires_at > ?", sessionID, uid, time.Now().Unix()).Scan(&n) != nil || n == 0 {
+			var lastUsed sql.NullInt64
+			now := time.Now()
+			if db.DB.QueryRow("SELECT last_used_at FROM sessions WHERE id = ? AND user_id = ? AND expires_at > ?", sessionID, uid, now.Unix()).Scan(&lastUsed) != nil {
 				c.JSON(401, gin.H{"error": "Session invalid or expired"})
 				c.Abort()
 				return
 			}
+			if !lastUsed.Valid || now.Unix()-lastUsed.Int64 >= int64(sessionTouchInterval/time.Second) {
+				db.DB.Exec("UPDATE sessions SET last_used_at = ? WHERE id = ?", now.Unix(), sessionID)
+			}
 		}
 		var email, role string
 		var name, avatar sql.NullString
 		var verified int
-		err = db.DB.QueryRow("SELECT id, email, role, name, avatar_path, email_verified FROM users WHERE id = ?", uid).Scan(
+		err := db.DB.QueryRow("SELECT id, email, role, name, avatar_path, email_verified FROM users WHERE id = ?", uid).Scan(
 			&uid, &email, &role, &name, &avatar, &verified)
 		if err != nil {
 			c.JSON(401, gin.H{"error": "User not found"})
 			c.Abort()
 			return
 		}
+		if err := checkBan(uid); err != nil {
+			if h := banJSON(err); h != nil {
+				c.JSON(403, h)
+			} else {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service unavailable"})
+			}
+			c.Abort()
+			return
+		}
 		c.Set("userID", uid)
+		c.Set("sessionID", sessionID)
+		c.Set("tokenScopes", scopes)
 		c.Set("userRole", role)
+		c.Set("emailVerified", verified == 1)
 		c.Set("userName", name)
 		c.Set("userAvatar", avatar)
+		if !rateLimitUser(c, uid) {
+			abortRateLimited(c)
+			return
+		}
 		_, _ = db.DB.Exec("UPDATE users SET last_seen_at = unixepoch() WHERE id = ?", uid)
 		c.Next()
 	}
@@ -414,8 +515,10 @@ func handleWSWithQueryToken(c *gin.Context) {
 		c.JSON(401, gin.H{"error": "token required"})
 		return
 	}
-	uid, _, sessionID, err := pqc.VerifyToken(cfg.PQCPublicKey, tok)
-	if err != nil {
+	claims, err := verifyAccessToken(tok)
+	uid, sessionID := claims.UserID, claims.SessionID
+	// Scoped (OAuth) tokens do not cover the realtime channel.
+	if err != nil || claims.Scopes != nil || claims.GrantID != 0 {
 		c.JSON(401, gin.H{"error": "Invalid token"})
 		return
 	}
@@ -426,6 +529,14 @@ func handleWSWithQueryToken(c *gin.Context) {
 			return
 		}
 	}
+	if err := checkBan(uid); err != nil {
+		if h := banJSON(err); h != nil {
+			c.JSON(403, h)
+		} else {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service unavailable"})
+		}
+		return
+	}
 	c.Set("userID", uid)
 	handleWS(c)
 }
@@ -442,6 +553,19 @@ func adminRequired() gin.HandlerFunc {
 	}
 }
 
+// verifiedEmailRequired blocks users who have not confirmed their email when EMAIL_VERIFICATION=required.
+// Runs after authRequired.
+func verifiedEmailRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cfg.EmailVerification == EmailVerificationRequired && !c.GetBool("emailVerified") {
+			c.JSON(403, gin.H{"error": "Confirm your email address first", "code": "email_unverified"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
 func getUserID(c *gin.Context) int64 {
 	v, _ := c.Get("userID")
 	if id, ok := v.(int64); ok {
@@ -450,9 +574,19 @@ func getUserID(c *gin.Context) int64 {
 	return 0
 }
 
-func auditLog(userID int64, action, entityType, entityID, details string) {
-	_, _ = db.DB.Exec("INSERT INTO audit_log (user_id, action, entity_type, entity_id, details) VALUES (?, ?, ?, ?, ?)",
+// getSessionID returns the session of the access token (0 for tokens not bound to a session).
+func getSessionID(c *gin.Context) int64 {
+	v, _ := c.Get("sessionID")
+	if id, ok := v.(int64); ok {
+		return id
+	}
+	return 0
+}
+
+func auditLog(userID int64, action, entityType, entityID, details string) error {
+	_, err := db.DB.Exec("INSERT INTO audit_log (user_id, action, entity_type, entity_id, details) VALUES (?, ?, ?, ?, ?)",
 		userID, action, entityType, entityID, details)
+	return err
 }
 
 // getOptionalUserID returns user ID if valid Bearer token present; otherwise 0 (for optional-auth routes).
@@ -466,8 +600,9 @@ func getOptionalUserID(c *gin.Context) int64 {
 	if tok == "" {
 		return 0
 	}
-	uid, _, sessionID, err := pqc.VerifyToken(cfg.PQCPublicKey, tok)
-	if err != nil {
+	claims, err := verifyAccessToken(tok)
+	uid, sessionID := claims.UserID, claims.SessionID
+	if err != nil || claims.Scopes != nil || claims.GrantID != 0 {
 		return 0
 	}
 	if sessionID != 0 {
@@ -480,26 +615,10 @@ func getOptionalUserID(c *gin.Context) int64 {
 	if db.DB.QueryRow("SELECT 1 FROM users WHERE id = ?", uid).Scan(&n) != nil {
 		return 0
 	}
-	return uid
-}
-
-func getLoginLimiter(ip string) *rate.Limiter {
-	loginLimitMu.Lock()
-	defer loginLimitMu.Unlock()
-	if l, ok := loginLimiters[ip]; ok {
-		return l
-	}
-	maxAttempts := cfg.MaxLoginAttempts
-	if maxAttempts <= 0 {
-		maxAttempts = 5
-	}
-	// Localhost: allow many attempts for dev and auto-login retries
-	if ip == "127.0.0.1" || ip == "::1" {
-		maxAttempts = 100
+	if checkBan(uid) != nil {
+		return 0
 	}
-	l := rate.NewLimiter(rate.Every(15*time.Minute/time.Duration(maxAttempts)), maxAttempts)
-	loginLimiters[ip] = l
-	return l
+	return uid
 }
 
 const (
@@ -562,7 +681,7 @@ func handleRegister(c *gin.Context) {
 	if len(body.Name) > maxNameLen {
 		body.Name = body.Name[:maxNameLen]
 	}
-	user, token, err := AuthRegister(body.Email, body.Password, body.Name)
+	user, tokens, err := AuthRegister(body.Email, body.Password, body.Name, clientInfo(c))
 	if err != nil {
 		switch {
 		case errors.Is(err, ErrEmailExists):
@@ -574,7 +693,7 @@ func handleRegister(c *gin.Context) {
 		}
 		return
 	}
-	c.JSON(201, gin.H{"user": user, "token": token})
+	c.JSON(201, withTokens(gin.H{"user": user}, tokens))
 }
 
 // handleSeedTestUser creates a test user only when the DB has zero users. For local dev.
@@ -591,12 +710,13 @@ func handleSeedTestUser(c *gin.Context) {
 		c.JSON(200, gin.H{"ok": true, "message": "Users already exist. Use existing account or register.", "test_email": testUserEmail, "test_password": testUserPassword})
 		return
 	}
-	user, token, err := AuthRegister(testUserEmail, testUserPassword, "Test User")
+	user, tokens, err := AuthRegister(testUserEmail, testUserPassword, "Test User", clientInfo(c))
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(201, gin.H{"ok": true, "message": "Test user created. You can sign in.", "user": user, "token": token, "test_email": testUserEmail, "test_password": testUserPassword})
+	db.DB.Exec("UPDATE users SET email_verified = 1, email_verify_token = NULL WHERE id = ?", user["id"])
+	c.JSON(201, withTokens(gin.H{"ok": true, "message": "Test user created. You can sign in.", "user": user, "test_email": testUserEmail, "test_password": testUserPassword}, tokens))
 }
 
 	const registerPageHTML = `<!DOCTYPE html>
@@ -705,7 +825,7 @@ func handleRegisterForm(c *gin.Context) {
 		return
 	}
 
-	user, token, err := AuthRegister(email, password, name)
+	user, tokens, err := AuthRegister(email, password, name, clientInfo(c))
 	if err != nil {
 		if errors.Is(err, ErrEmailExists) {
 			serveRegisterError(c, "Email already registered", email, name)
@@ -717,7 +837,7 @@ func handleRegisterForm(c *gin.Context) {
 
 	if cfg.AppURL != "" {
 		apiBase := c.Request.URL.Scheme + "://" + c.Request.Host
-		redirectURL := cfg.AppURL + "/app/dashboard.html?token=" + url.QueryEscape(token) + "&api_url=" + url.QueryEscape(apiBase)
+		redirectURL := cfg.AppURL + "/app/dashboard.html?token=" + url.QueryEscape(tokens.Token) + "&refresh_token=" + url.QueryEscape(tokens.RefreshToken) + "&api_url=" + url.QueryEscape(apiBase)
 		if u, ok := user["name"]; ok && u != nil {
 			redirectURL += "&name=" + url.QueryEscape(stringOrEmpty(user["name"]))
 		}
@@ -728,7 +848,7 @@ func handleRegisterForm(c *gin.Context) {
 		return
 	}
 	c.Header("Content-Type", "text/html; charset=utf-8")
-	c.String(200, `<html><body><p>Account created. Token: `+token+`</p><p><a href="/register">Back to register</a></p></body></html>`)
+	c.String(200, `<html><body><p>Account created. Token: `+tokens.Token+`</p><p><a href="/register">Back to register</a></p></body></html>`)
 }
 
 func stringOrEmpty(v interface{}) string {
@@ -762,9 +882,7 @@ func handleLoginPage(c *gin.Context) {
 }
 
 func handleLoginForm(c *gin.Context) {
-	ip := c.ClientIP()
-	limiter := getLoginLimiter(ip)
-	if !limiter.Allow() {
+	if !allowLogin(c) {
 		serveLoginError(c, "Too many attempts. Try again later.", c.PostForm("email"))
 		return
 	}
@@ -774,14 +892,30 @@ func handleLoginForm(c *gin.Context) {
 		serveLoginError(c, "Email required", "")
 		return
 	}
-	user, token, err := AuthLogin(email, password)
+	user, tokens, err := AuthLogin(email, password, clientInfo(c))
 	if err != nil {
+		if errors.Is(err, ErrUserBanned) {
+			serveLoginError(c, "Account suspended", email)
+			return
+		}
+		if errors.Is(err, ErrMFARequired) {
+			serveLoginError(c, "Two-factor authentication is enabled; sign in from the app", email)
+			return
+		}
+		if errors.Is(err, ErrPasswordResetRequired) {
+			serveLoginError(c, "Password reset required; use the link sent to your email", email)
+			return
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			serveLoginError(c, "Sign-in failed. Try again later.", email)
+			return
+		}
 		serveLoginError(c, "Invalid email or password", email)
 		return
 	}
 	if cfg.AppURL != "" {
 		apiBase := c.Request.URL.Scheme + "://" + c.Request.Host
-		redirectURL := cfg.AppURL + "/app/dashboard.html?token=" + url.QueryEscape(token) + "&api_url=" + url.QueryEscape(apiBase)
+		redirectURL := cfg.AppURL + "/app/dashboard.html?token=" + url.QueryEscape(tokens.Token) + "&refresh_token=" + url.QueryEscape(tokens.RefreshToken) + "&api_url=" + url.QueryEscape(apiBase)
 		if u, ok := user["name"]; ok && u != nil {
 			redirectURL += "&name=" + url.QueryEscape(stringOrEmpty(user["name"]))
 		}
@@ -790,7 +924,7 @@ func handleLoginForm(c *gin.Context) {
 		return
 	}
 	c.Header("Content-Type", "text/html; charset=utf-8")
-	c.String(200, `<html><body><p>Signed in. Token: `+token+`</p><p><a href="/login">Back to login</a></p></body></html>`)
+	c.String(200, `<html><body><p>Signed in. Token: `+tokens.Token+`</p><p><a href="/login">Back to login</a></p></body></html>`)
 }
 
 func serveLoginError(c *gin.Context, errMsg, email string) {
@@ -801,9 +935,7 @@ func serveLoginError(c *gin.Context, errMsg, email string) {
 }
 
 func handleLogin(c *gin.Context) {
-	ip := c.ClientIP()
-	limiter := getLoginLimiter(ip)
-	if !limiter.Allow() {
+	if !allowLogin(c) {
 		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many login attempts. Try again later."})
 		return
 	}
@@ -820,16 +952,53 @@ func handleLogin(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "Email and password required"})
 		return
 	}
-	user, token, err := AuthLogin(body.Email, body.Password)
+	user, tokens, err := AuthLogin(body.Email, body.Password, clientInfo(c))
 	if err != nil {
+		var mfa *MFARequiredError
+		if errors.As(err, &mfa) {
+			c.JSON(200, mfa.JSON())
+			return
+		}
 		if errors.Is(err, ErrInvalidCredentials) {
 			c.JSON(401, gin.H{"error": "Invalid email or password"})
 			return
 		}
+		if h := loginRefusedJSON(err); h != nil {
+			c.JSON(403, h)
+			return
+		}
 		c.JSON(500, gin.H{"error": "Login failed"})
 		return
 	}
-	c.JSON(200, gin.H{"user": user, "token": token})
+	c.JSON(200, withTokens(gin.H{"user": user}, tokens))
+}
+
+// handleAuthRefresh exchanges a refresh token for a new access token and the next refresh token.
+func handleAuthRefresh(c *gin.Context) {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.RefreshToken == "" {
+		c.JSON(400, gin.H{"error": "refresh_token required"})
+		return
+	}
+	tokens, _, err := AuthRefresh(body.RefreshToken)
+	if err != nil {
+		if h := banJSON(err); h != nil {
+			c.JSON(403, h)
+			return
+		}
+		switch {
+		case errors.Is(err, ErrRefreshInvalid):
+			c.JSON(401, gin.H{"error": "Invalid or expired refresh token"})
+		case errors.Is(err, ErrRefreshReused):
+			c.JSON(401, gin.H{"error": "Refresh token already used; session revoked"})
+		default:
+			c.JSON(500, gin.H{"error": "Refresh failed"})
+		}
+		return
+	}
+	c.JSON(200, withTokens(gin.H{}, tokens))
 }
 
 func handleConfirmEmail(c *gin.Context) {
@@ -838,14 +1007,33 @@ func handleConfirmEmail(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "Token required"})
 		return
 	}
-	res, err := db.DB.Exec("UPDATE users SET email_verified = 1, email_verify_token = NULL WHERE email_verify_token = ?", tok)
-	if err != nil || mustRows(res) == 0 {
+	if err := AuthConfirmEmail(tok); err != nil {
+		if errors.Is(err, ErrVerifyTokenExpired) {
+			c.JSON(400, gin.H{"error": "Token expired", "code": "token_expired"})
+			return
+		}
 		c.JSON(400, gin.H{"error": "Invalid token"})
 		return
 	}
 	c.JSON(200, gin.H{"ok": true})
 }
 
+// handleConfirmEmailResend emails a new confirmation link to the signed-in user.
+func handleConfirmEmailResend(c *gin.Context) {
+	err := AuthResendConfirmEmail(getUserID(c))
+	switch {
+	case err == nil:
+		c.JSON(200, gin.H{"ok": true})
+	case errors.Is(err, ErrEmailAlreadyVerified):
+		c.JSON(400, gin.H{"error": "Email already verified"})
+	case errors.Is(err, ErrVerifyResendTooSoon):
+		c.Header("Retry-After", strconv.Itoa(int(emailResendInterval/time.Second)))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Confirmation email sent recently. Try again later."})
+	default:
+		c.JSON(500, gin.H{"error": "Failed to send confirmation email"})
+	}
+}
+
 func handleForgotPassword(c *gin.Context) {
 	var body struct{ Email string `json:"email"` }
 	c.ShouldBindJSON(&body)
@@ -864,6 +1052,7 @@ func handleForgotPassword(c *gin.Context) {
 	resetToken := hex.EncodeToString(tok)
 	exp := time.Now().Add(time.Hour).Unix()
 	db.DB.Exec("UPDATE users SET reset_token = ?, reset_token_expires = ? WHERE id = ?", resetToken, exp, id)
+	sendPasswordResetEmail(body.Email, resetToken)
 	c.JSON(200, gin.H{"ok": true})
 }
 
@@ -882,8 +1071,14 @@ func handleResetPassword(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "Invalid or expired token"})
 		return
 	}
-	db.DB.Exec("UPDATE users SET password_hash = ?, reset_token = NULL, reset_token_expires = NULL WHERE id = ?", hashPasswordArgon2(body.Password), id)
-	c.JSON(200, gin.H{"ok": true})
+	if _, err := db.DB.Exec("UPDATE users SET password_hash = ?, password_set = 1, password_reset_required = 0, reset_token = NULL, reset_token_expires = NULL WHERE id = ?", hashPasswordArgon2(body.Password), id); err != nil {
+		c.JSON(500, gin.H{"error": "Password reset failed"})
+		return
+	}
+	// A reset is the way back after a compromise: every session is signed out, as on a password change.
+	revoked, _ := revokeOtherSessions(id, 0)
+	auditLog(id, "password.reset", "user", strconv.FormatInt(id, 10), "revoked_sessions="+strconv.FormatInt(revoked, 10))
+	c.JSON(200, gin.H{"ok": true, "revoked_sessions": revoked})
 }
 
 const argon2SaltLen = 16
@@ -921,14 +1116,14 @@ func checkPasswordBcrypt(stored, password string) bool {
 func handleUserMe(c *gin.Context) {
 	id := getUserID(c)
 	var email, role, name string
-	var avatar sql.NullString
+	var avatar, phone sql.NullString
 	var emailVerified, phoneVerified int
-	if db.DB.QueryRow("SELECT email, role, name, avatar_path, COALESCE(email_verified, 0), COALESCE(phone_verified, 0) FROM users WHERE id = ?", id).Scan(&email, &role, &name, &avatar, &emailVerified, &phoneVerified) != nil {
+	if db.DB.QueryRow("SELECT email, role, name, avatar_path, phone, COALESCE(email_verified, 0), COALESCE(phone_verified, 0) FROM users WHERE id = ?", id).Scan(&email, &role, &name, &avatar, &phone, &emailVerified, &phoneVerified) != nil {
 		c.JSON(404, gin.H{"error": "User not found"})
 		return
 	}
 	verified := emailVerified == 1 || phoneVerified == 1
-	c.JSON(200, gin.H{"id": id, "email": email, "role": role, "name": name, "avatar_path": avatar.String, "email_verified": emailVerified == 1, "phone_verified": phoneVerified == 1, "verified": verified})
+	c.JSON(200, gin.H{"id": id, "email": email, "role": role, "name": name, "avatar_path": avatar.String, "phone": nullStr(phone.String), "email_verified": emailVerified == 1, "phone_verified": phoneVerified == 1, "verified": verified, "permissions": userPermissions(id, role)})
 }
 
 func handleUserUpdate(c *gin.Context) {
@@ -960,6 +1155,60 @@ func handleUserUpdate(c *gin.Context) {
 	handleUserMe(c)
 }
 
+// handlePhoneStart sends an SMS code to {phone}; the number becomes the account's phone once verified.
+func handlePhoneStart(c *gin.Context) {
+	var body struct {
+		Phone string `json:"phone"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Phone == "" {
+		c.JSON(400, gin.H{"error": "phone required"})
+		return
+	}
+	phone, err := PhoneStartVerification(getUserID(c), body.Phone)
+	switch {
+	case err == nil:
+		c.JSON(200, gin.H{"ok": true, "phone": phone, "expires_in": int64(phoneCodeTTL / time.Second)})
+	case errors.Is(err, ErrPhoneInvalid), errors.Is(err, ErrPhoneAlreadyVerified):
+		c.JSON(400, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrPhoneTaken):
+		c.JSON(409, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrPhoneResendTooSoon):
+		c.Header("Retry-After", strconv.Itoa(int(phoneResendInterval/time.Second)))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(502, gin.H{"error": "Failed to send verification code"})
+	}
+}
+
+func handlePhoneVerify(c *gin.Context) {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Code == "" {
+		c.JSON(400, gin.H{"error": "code required"})
+		return
+	}
+	phone, err := PhoneVerify(getUserID(c), body.Code)
+	switch {
+	case err == nil:
+		c.JSON(200, gin.H{"ok": true, "phone": phone, "phone_verified": true})
+	case errors.Is(err, ErrPhoneTooManyAttempts):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrPhoneNoPending), errors.Is(err, ErrPhoneCodeExpired), errors.Is(err, ErrPhoneCodeInvalid):
+		c.JSON(400, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": "Verification failed"})
+	}
+}
+
+func handlePhoneRemove(c *gin.Context) {
+	if err := PhoneRemove(getUserID(c)); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to remove phone"})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true})
+}
+
 func handleUserHeartbeat(c *gin.Context) {
 	uid := getUserID(c)
 	db.DB.Exec("UPDATE users SET last_seen_at = unixepoch() WHERE id = ?", uid)
@@ -1126,8 +1375,14 @@ func handleChangePassword(c *gin.Context) {
 		return
 	}
 	newHash := hashPasswordArgon2(body.NewPassword)
-	db.DB.Exec("UPDATE users SET password_hash = ?, updated_at = unixepoch() WHERE id = ?", newHash, uid)
-	c.JSON(200, gin.H{"ok": true})
+	if _, err := db.DB.Exec("UPDATE users SET password_hash = ?, password_reset_required = 0, updated_at = unixepoch() WHERE id = ?", newHash, uid); err != nil {
+		c.JSON(500, gin.H{"error": "Password change failed"})
+		return
+	}
+	// A changed password signs out every other session; the one making the change stays signed in.
+	revoked, _ := revokeOtherSessions(uid, getSessionID(c))
+	auditLog(uid, "password.changed", "user", strconv.FormatInt(uid, 10), "revoked_sessions="+strconv.FormatInt(revoked, 10))
+	c.JSON(200, gin.H{"ok": true, "revoked_sessions": revoked})
 }
 
 func handleUserDelete(c *gin.Context) {
@@ -1163,6 +1418,10 @@ func handleBalanceCredit(c *gin.Context) {
 		return
 	}
 	h, err := BalanceCredit(getUserID(c), body.Amount)
+	if errors.Is(err, ledger.ErrInvalidAmount) {
+		c.JSON(400, gin.H{"error": "amount too small"})
+		return
+	}
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed"})
 		return
@@ -1242,7 +1501,7 @@ func handleProductsList(c *gin.Context) {
 			offset = n
 		}
 	}
-	qry := `SELECT p.id, p.title, p.price, p.category, p.location, p.image_path, COALESCE(p.is_service, 0), COALESCE(p.is_subscription, 0), p.created_at, u.id, u.name, COALESCE(u.email_verified, 0), COALESCE(u.phone_verified, 0) FROM products p JOIN users u ON u.id = p.user_id WHERE 1=1`
+	qry := `SELECT p.id, p.title, p.price, p.category, p.location, p.image_path, COALESCE(p.is_service, 0), COALESCE(p.is_subscription, 0), p.created_at, u.id, u.name, COALESCE(u.email_verified, 0), COALESCE(u.phone_verified, 0) FROM products p JOIN users u ON u.id = p.user_id WHERE p.taken_down_at IS NULL`
 	args := []interface{}{}
 	if uid := c.Query("user_id"); uid != "" {
 		if uidNum, err := strconv.ParseInt(uid, 10, 64); err == nil {
@@ -1303,7 +1562,7 @@ func handleProductsList(c *gin.Context) {
 }
 
 func handleProductsCategories(c *gin.Context) {
-	rows, _ := db.DB.Query("SELECT DISTINCT category FROM products ORDER BY category")
+	rows, _ := db.DB.Query("SELECT DISTINCT category FROM products WHERE taken_down_at IS NULL ORDER BY category")
 	var list []string
 	if rows != nil {
 		defer rows.Close()
@@ -1626,11 +1885,13 @@ func handleOrderGet(c *gin.Context) {
 	var price float64
 	var img sql.NullString
 	var urgent int
+	var paymentStatus string
+	var holdID sql.NullInt64
 	err = db.DB.QueryRow(
-		`SELECT o.id, o.product_id, o.buyer_id, o.seller_id, o.status, o.created_at, COALESCE(o.urgent, 0), p.title, p.price, p.image_path
+		`SELECT o.id, o.product_id, o.buyer_id, o.seller_id, o.status, o.created_at, COALESCE(o.urgent, 0), o.payment_status, o.hold_id, p.title, p.price, p.image_path
 		 FROM orders o JOIN products p ON p.id = o.product_id WHERE o.id = ? AND (o.buyer_id = ? OR o.seller_id = ?)`,
 		id, uid, uid,
-	).Scan(&oid, &pid, &buyerID, &sellerID, &status, &createdAt, &urgent, &title, &price, &img)
+	).Scan(&oid, &pid, &buyerID, &sellerID, &status, &createdAt, &urgent, &paymentStatus, &holdID, &title, &price, &img)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
@@ -1638,6 +1899,7 @@ func handleOrderGet(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"id": oid, "product_id": pid, "buyer_id": buyerID, "seller_id": sellerID,
 		"status": status, "created_at": createdAt, "urgent": urgent == 1, "title": title, "price": price, "image_path": img.String,
+		"payment_status": paymentStatus, "hold_id": holdID.Int64,
 	})
 }
 
@@ -1680,11 +1942,7 @@ func handleOrderCreate(c *gin.Context) {
 			if body.Urgent {
 				title = "Urgent order"
 			}
-			_, _ = db.DB.Exec(
-				"INSERT INTO notifications_queue (user_id, type, channel, title, body, data, status) VALUES (?, 'order_new', 'in_app', ?, ?, ?, 'pending')",
-				sellerID, title, "Order #"+strconv.FormatInt(oid, 10)+" — view details and accept or decline.", dataJSON,
-			)
-			BroadcastToUser(sellerID, "notification", gin.H{"type": "order_new", "title": title, "order_id": oid})
+			_ = enqueueNotification(sellerID, "order_new", "in_app", title, "Order #"+strconv.FormatInt(oid, 10)+" — view details and accept or decline.", dataJSON)
 		}
 	}
 	auditLog(uid, "order_created", "order", strconv.FormatInt(h["id"].(int64), 10), "")
@@ -1698,9 +1956,9 @@ func handleOrderUpdate(c *gin.Context) {
 		InstallmentPlan string `json:"installment_plan"`
 	}
 	c.ShouldBindJSON(&body)
-	var buyer, seller int64
+	var orderID, buyer, seller int64
 	var currentStatus string
-	if db.DB.QueryRow("SELECT buyer_id, seller_id, status FROM orders WHERE id = ?", idStr).Scan(&buyer, &seller, &currentStatus) != nil {
+	if db.DB.QueryRow("SELECT id, buyer_id, seller_id, status FROM orders WHERE id = ?", idStr).Scan(&orderID, &buyer, &seller, &currentStatus) != nil {
 		c.JSON(404, gin.H{"error": "Order not found"})
 		return
 	}
@@ -1714,13 +1972,14 @@ func handleOrderUpdate(c *gin.Context) {
 			c.JSON(400, gin.H{"error": "Invalid status"})
 			return
 		}
-		// State machine: pending -> confirmed|cancelled; confirmed -> completed; cancelled/completed terminal
+		// State machine: pending -> confirmed|cancelled; confirmed -> completed (or cancelled while payment is held,
+		// checked by OrderApplyStatus); cancelled/completed terminal
 		allowed := false
 		switch currentStatus {
 		case "pending":
 			allowed = body.Status == "confirmed" || body.Status == "cancelled"
 		case "confirmed":
-			allowed = body.Status == "completed"
+			allowed = body.Status == "completed" || body.Status == "cancelled"
 		case "cancelled", "completed":
 			allowed = false
 		default:
@@ -1730,10 +1989,26 @@ func handleOrderUpdate(c *gin.Context) {
 			c.JSON(400, gin.H{"error": "Cannot change status from " + currentStatus + " to " + body.Status})
 			return
 		}
-		db.DB.Exec("UPDATE orders SET status = ?, updated_at = unixepoch() WHERE id = ?", body.Status, idStr)
-		auditLog(uid, "order_status_changed", "order", idStr, currentStatus+" -> "+body.Status)
-		BroadcastToUser(buyer, "order:status", gin.H{"order_id": idStr, "status": body.Status})
-		BroadcastToUser(seller, "order:status", gin.H{"order_id": idStr, "status": body.Status})
+		if err := OrderApplyStatus(uid, orderID, currentStatus, body.Status); err != nil {
+			if errors.Is(err, ErrOrderNotCancellable) {
+				c.JSON(400, gin.H{"error": "Cannot change status from confirmed to cancelled: " + err.Error()})
+				return
+			}
+			if errors.Is(err, ErrOrderCompleteBuyer) {
+				c.JSON(403, gin.H{"error": "Only the buyer can complete a paid order"})
+				return
+			}
+			if errors.Is(err, ErrOrderStatusChanged) {
+				c.JSON(409, gin.H{"error": "Order status changed, reload and retry"})
+				return
+			}
+			if errors.Is(err, ErrHoldExpired) {
+				c.JSON(409, gin.H{"error": "Payment hold expired; the buyer must pay again", "code": "hold_expired"})
+				return
+			}
+			c.JSON(500, gin.H{"error": "Failed to update order"})
+			return
+		}
 	}
 	if body.InstallmentPlan == "requested" || body.InstallmentPlan == "installments" {
 		db.DB.Exec("UPDATE orders SET installment_plan = 'requested', updated_at = unixepoch() WHERE id = ?", idStr)
@@ -1748,6 +2023,27 @@ func handleOrderUpdate(c *gin.Context) {
 	c.JSON(200, out)
 }
 
+func handleOrderPay(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(400, gin.H{"error": "invalid id"})
+		return
+	}
+	h, err := OrderPay(getUserID(c), id)
+	switch {
+	case err == nil:
+		c.JSON(200, h)
+	case errors.Is(err, ErrOrderNotFound):
+		c.JSON(404, gin.H{"error": "Order not found"})
+	case errors.Is(err, ErrOrderNotBuyer):
+		c.JSON(403, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrOrderNotPayable), errors.Is(err, ErrWalletInsufficient):
+		c.JSON(400, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": "Payment failed"})
+	}
+}
+
 func handleRemittanceCreate(c *gin.Context) {
 	var body struct {
 		ToIdentifier string  `json:"to_identifier"`
@@ -1894,10 +2190,6 @@ func handleMessageSend(c *gin.Context) {
 		c.JSON(500, gin.H{"error": "Failed"})
 		return
 	}
-	var otherID int64
-	if db.DB.QueryRow("SELECT user_id FROM conversation_participants WHERE conversation_id = ? AND user_id != ?", cid, getUserID(c)).Scan(&otherID) == nil && otherID != 0 {
-		BroadcastToUser(otherID, "relay:message", gin.H{"conversation_id": cid, "message_id": h["id"]})
-	}
 	c.JSON(201, h)
 }
 
@@ -2206,25 +2498,50 @@ func handleVaultDeleteFile(c *gin.Context) {
 
 func handleAuthSessionsList(c *gin.Context) {
 	uid := getUserID(c)
-	rows, err := db.DB.Query("SELECT id, device_name, created_at, expires_at FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY created_at DESC", uid, time.Now().Unix())
+	current := getSessionID(c)
+	rows, err := db.DB.Query(
+		`SELECT id, device_name, ip, user_agent, client_type, created_at, COALESCE(last_used_at, created_at), expires_at
+		 FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY COALESCE(last_used_at, created_at) DESC`, uid, time.Now().Unix())
 	if err != nil {
 		c.JSON(500, gin.H{"error": "failed to list sessions"})
 		return
 	}
 	defer rows.Close()
-	var list []gin.H
+	list := []gin.H{}
 	for rows.Next() {
 		var id int64
 		var deviceName string
-		var createdAt, expiresAt int64
-		if rows.Scan(&id, &deviceName, &createdAt, &expiresAt) != nil {
+		var ip, ua, clientType sql.NullString
+		var createdAt, lastUsedAt, expiresAt int64
+		if rows.Scan(&id, &deviceName, &ip, &ua, &clientType, &createdAt, &lastUsedAt, &expiresAt) != nil {
 			continue
 		}
-		list = append(list, gin.H{"id": id, "device_name": deviceName, "created_at": createdAt, "expires_at": expiresAt})
+		client := useragent.Parse(ua.String)
+		if clientType.Valid {
+			client.Device = clientType.String
+		}
+		list = append(list, gin.H{
+			"id": id, "device_name": deviceName, "ip": ip.String, "user_agent": ua.String,
+			"client_type": client.Device, "label": client.Label(),
+			"created_at": createdAt, "last_used_at": lastUsedAt, "expires_at": expiresAt,
+			"current": id == current,
+		})
 	}
 	c.JSON(200, gin.H{"sessions": list})
 }
 
+// handleAuthSessionsRevokeOthers signs out every session except the one making the request.
+func handleAuthSessionsRevokeOthers(c *gin.Context) {
+	uid := getUserID(c)
+	revoked, err := revokeOtherSessions(uid, getSessionID(c))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+	auditLog(uid, "session.revoked_others", "session", strconv.FormatInt(getSessionID(c), 10), strconv.FormatInt(revoked, 10))
+	c.JSON(200, gin.H{"revoked": revoked})
+}
+
 func handleAuthSessionDelete(c *gin.Context) {
 	uid := getUserID(c)
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
@@ -2247,7 +2564,7 @@ func handleAuthSessionDelete(c *gin.Context) {
 
 func handleAuthDevicesList(c *gin.Context) {
 	uid := getUserID(c)
-	rows, err := db.DB.Query("SELECT id, name, last_used, created_at FROM devices WHERE user_id = ? ORDER BY created_at DESC", uid)
+	rows, err := db.DB.Query("SELECT id, name, COALESCE(last_ip, ''), last_used, created_at FROM devices WHERE user_id = ? ORDER BY created_at DESC", uid)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "failed to list devices"})
 		return
@@ -2256,17 +2573,17 @@ func handleAuthDevicesList(c *gin.Context) {
 	var list []gin.H
 	for rows.Next() {
 		var id int64
-		var name string
+		var name, lastIP string
 		var lastUsed sql.NullInt64
 		var createdAt int64
-		if rows.Scan(&id, &name, &lastUsed, &createdAt) != nil {
+		if rows.Scan(&id, &name, &lastIP, &lastUsed, &createdAt) != nil {
 			continue
 		}
 		lu := interface{}(nil)
 		if lastUsed.Valid {
 			lu = lastUsed.Int64
 		}
-		list = append(list, gin.H{"id": id, "name": name, "last_used": lu, "created_at": createdAt})
+		list = append(list, gin.H{"id": id, "name": name, "last_ip": lastIP, "last_used": lu, "created_at": createdAt})
 	}
 	c.JSON(200, gin.H{"devices": list})
 }
@@ -2300,11 +2617,7 @@ func handleRecoveryGenerate(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "recoveryHash required"})
 		return
 	}
-	_, err := db.DB.Exec(
-		"INSERT OR REPLACE INTO user_recovery (user_id, recovery_hash, created_at) VALUES (?, ?, ?)",
-		uid, body.RecoveryHash, time.Now().Unix(),
-	)
-	if err != nil {
+	if err := RecoverySetKey(uid, body.RecoveryHash); err != nil {
 		c.JSON(500, gin.H{"error": "failed to save recovery"})
 		return
 	}
@@ -2312,40 +2625,115 @@ func handleRecoveryGenerate(c *gin.Context) {
 	c.JSON(200, gin.H{"ok": true})
 }
 
+// recoveryBody is the unauthenticated recovery request: the account email and the client-derived recovery key.
+type recoveryBody struct {
+	Email        string `json:"email"`
+	RecoveryHash string `json:"recoveryHash"`
+}
+
+// bindRecovery parses the body and applies the rate limits; it writes the error response when ok is false.
+func bindRecovery(c *gin.Context) (body recoveryBody, ok bool) {
+	if err := c.ShouldBindJSON(&body); err != nil || body.Email == "" || body.RecoveryHash == "" {
+		c.JSON(400, gin.H{"error": "email and recoveryHash required"})
+		return body, false
+	}
+	body.Email = strings.TrimSpace(strings.ToLower(body.Email))
+	if !recoveryAllowed(c, body.Email) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many recovery attempts. Try again later."})
+		return body, false
+	}
+	return body, true
+}
+
 func handleRecoveryVerify(c *gin.Context) {
+	body, ok := bindRecovery(c)
+	if !ok {
+		return
+	}
+	if _, err := RecoveryCheck(body.Email, body.RecoveryHash); err != nil {
+		c.JSON(400, gin.H{"error": "invalid email or recovery phrase"})
+		return
+	}
+	c.JSON(200, gin.H{"valid": true})
+}
+
+// handleRecoveryRestore checks email + recovery key. With a fresh passkey session of the same account in
+// Authorization it restores at once; otherwise it emails a confirmation link (finish with /auth/recovery/confirm).
+func handleRecoveryRestore(c *gin.Context) {
+	body, ok := bindRecovery(c)
+	if !ok {
+		return
+	}
+	userID, err := RecoveryCheck(body.Email, body.RecoveryHash)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid email or recovery phrase"})
+		return
+	}
+	if tok := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); tok != "" {
+		if claims, err := verifyAccessToken(tok); err == nil && claims.UserID == userID && recoveryPasskeyConfirmed(userID, claims.SessionID) {
+			tokens, err := recoveryComplete(userID, "passkey", clientInfo(c))
+			if err != nil {
+				if h := loginRefusedJSON(err); h != nil {
+					c.JSON(403, h)
+					return
+				}
+				c.JSON(500, gin.H{"error": "failed to create session"})
+				return
+			}
+			c.JSON(200, withTokens(gin.H{"user_id": userID}, tokens))
+			return
+		}
+	}
+	if err := RecoveryRequestConfirmation(userID, c.ClientIP()); err != nil {
+		c.JSON(500, gin.H{"error": "failed to start recovery"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"confirmation_required": "email", "expires_in": int64(recoveryConfirmTTL / time.Second)})
+}
+
+// handleRecoveryConfirm finishes a restore with the token from the recovery email.
+func handleRecoveryConfirm(c *gin.Context) {
 	var body struct {
-		RecoveryHash string `json:"recoveryHash"`
+		Token string `json:"token"`
 	}
-	if err := c.ShouldBindJSON(&body); err != nil || body.RecoveryHash == "" {
-		c.JSON(400, gin.H{"error": "recoveryHash required"})
+	if err := c.ShouldBindJSON(&body); err != nil || body.Token == "" {
+		c.JSON(400, gin.H{"error": "token required"})
 		return
 	}
-	var userID int64
-	if db.DB.QueryRow("SELECT user_id FROM user_recovery WHERE recovery_hash = ?", body.RecoveryHash).Scan(&userID) != nil {
-		c.JSON(400, gin.H{"error": "invalid recovery phrase"})
+	userID, tokens, err := RecoveryConfirm(body.Token, clientInfo(c))
+	if err != nil {
+		if errors.Is(err, ErrRecoveryConfirmInvalid) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if h := loginRefusedJSON(err); h != nil {
+			c.JSON(403, h)
+			return
+		}
+		c.JSON(500, gin.H{"error": "failed to create session"})
 		return
 	}
-	c.JSON(200, gin.H{"valid": true, "userId": userID})
+	c.JSON(200, withTokens(gin.H{"user_id": userID}, tokens))
 }
 
-func handleRecoveryRestore(c *gin.Context) {
+// handleLoginAlertReport is the "this wasn't me" link of a new-device email (see login_alert_service.go).
+func handleLoginAlertReport(c *gin.Context) {
 	var body struct {
-		RecoveryHash string `json:"recoveryHash"`
+		Token string `json:"token"`
 	}
-	if err := c.ShouldBindJSON(&body); err != nil || body.RecoveryHash == "" {
-		c.JSON(400, gin.H{"error": "recoveryHash required"})
+	if err := c.ShouldBindJSON(&body); err != nil || body.Token == "" {
+		c.JSON(400, gin.H{"error": "token required"})
 		return
 	}
-	var userID int64
-	if db.DB.QueryRow("SELECT user_id FROM user_recovery WHERE recovery_hash = ?", body.RecoveryHash).Scan(&userID) != nil {
-		c.JSON(400, gin.H{"error": "invalid recovery phrase"})
+	if _, err := ReportLoginAlert(body.Token, c.ClientIP()); err != nil {
+		if errors.Is(err, ErrLoginAlertInvalid) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": "failed to secure account"})
 		return
 	}
-	db.DB.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
-	sessionID, exp := createSession(userID, "recovery")
-	token, _ := pqc.SignTokenWithSession(cfg.PQCPrivateKey, userID, sessionID, exp)
-	auditLog(userID, "recovery.restore", "user_recovery", "", "")
-	c.JSON(200, gin.H{"token": token, "user_id": userID})
+	c.JSON(200, gin.H{"ok": true, "password_reset_required": true})
 }
 
 func nullStrToString(s sql.NullString) string {
@@ -1,24 +1,57 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"io"
+	"mime/quotedprintable"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"omnixius-api/db"
+	"omnixius-api/internal/event"
+	"omnixius-api/internal/mail"
+	"omnixius-api/internal/totp"
+	"omnixius-api/pqc"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/webauthn"
 )
 
 func setupTestDB(t *testing.T) {
 	t.Helper()
-	if err := db.Open(":memory:"); err != nil {
+	// File DB: each pooled connection to ":memory:" would see its own empty database.
+	if err := db.Open(filepath.Join(t.TempDir(), "test.db")); err != nil {
 		t.Fatal(err)
 	}
 	t.Cleanup(func() { db.DB.Close() })
 	cfg = LoadConfig()
+	cfg.MailDir = filepath.Join(t.TempDir(), "mail")
+	if err := initKeyring(); err != nil {
+		t.Fatal(err)
+	}
+	if err := initMailer(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pendingMail.Wait)
+	cfg.SMSOutboxFile = filepath.Join(t.TempDir(), "sms.jsonl")
+	initSMSSender()
+	initPushSender()
+	initRateLimiter()
 	gin.SetMode(gin.TestMode)
 }
 
@@ -96,3 +129,1834 @@ func TestMessageSend_NoAuth_Returns401(t *testing.T) {
 		t.Errorf("got status %d, want 401", w.Code)
 	}
 }
+
+func TestNotificationDispatcher_DeliverRetryAndSettings(t *testing.T) {
+	setupTestDB(t)
+	res, err := db.DB.Exec("INSERT INTO users (email, password_hash, name) VALUES ('n@test.com', 'x', 'N')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, _ := res.LastInsertId()
+	notificationSenders["flaky"] = func(n queuedNotification) error { return errors.New("boom") }
+	t.Cleanup(func() { delete(notificationSenders, "flaky") })
+
+	enqueueNotification(uid, "test", "websocket", "ws", "body", `{"order_id":1}`)
+	enqueueNotification(uid, "test", "push", "push", "body", "")
+	enqueueNotification(uid, "test", "flaky", "flaky", "body", "")
+	db.DB.Exec("INSERT INTO notifications_user_settings (user_id, email_enabled, push_enabled) VALUES (?, 0, 1)", uid)
+	enqueueNotification(uid, "test", "email", "email", "body", "")
+
+	now := time.Now()
+	if n := dispatchDueNotifications(now); n != 4 {
+		t.Fatalf("processed %d rows, want 4", n)
+	}
+	status := func(title string) (st string, attempts int) {
+		db.DB.QueryRow("SELECT status, attempts FROM notifications_queue WHERE title = ?", title).Scan(&st, &attempts)
+		return
+	}
+	if st, _ := status("ws"); st != "sent" {
+		t.Errorf("websocket: status %q, want sent", st)
+	}
+	if st, _ := status("push"); st != "failed" {
+		t.Errorf("push without tokens: status %q, want failed", st)
+	}
+	if st, _ := status("email"); st != "skipped" {
+		t.Errorf("email disabled: status %q, want skipped", st)
+	}
+	if st, attempts := status("flaky"); st != "pending" || attempts != 1 {
+		t.Errorf("flaky after 1st try: status %q attempts %d, want pending/1", st, attempts)
+	}
+	// Not due again until backoff passes.
+	if n := dispatchDueNotifications(now); n != 0 {
+		t.Errorf("processed %d rows before backoff, want 0", n)
+	}
+	dispatchDueNotifications(now.Add(time.Hour))
+	dispatchDueNotifications(now.Add(2 * time.Hour))
+	if st, attempts := status("flaky"); st != "failed" || attempts != 3 {
+		t.Errorf("flaky after max attempts: status %q attempts %d, want failed/3", st, attempts)
+	}
+
+	// A push token without a provider is not reported as delivered; with one, the message goes out.
+	db.DB.Exec("INSERT INTO notifications_push_tokens (user_id, token, platform, last_used) VALUES (?, 'tok-1', 'web', 1)", uid)
+	enqueueNotification(uid, "test", "push", "push-noprovider", "body", "")
+	dispatchDueNotifications(time.Now())
+	var lastUsed int64
+	var st, lastErr string
+	db.DB.QueryRow("SELECT status, COALESCE(last_error, '') FROM notifications_queue WHERE title = 'push-noprovider'").Scan(&st, &lastErr)
+	db.DB.QueryRow("SELECT last_used FROM notifications_push_tokens WHERE user_id = ?", uid).Scan(&lastUsed)
+	if st != "failed" || lastErr != errNotifyNoPushProvider.Error() || lastUsed != 1 {
+		t.Errorf("push without provider: status %q (%q), last_used %d", st, lastErr, lastUsed)
+	}
+	cfg.PushOutboxFile = filepath.Join(t.TempDir(), "push.jsonl")
+	initPushSender()
+	t.Cleanup(func() { pushSender = nil })
+	enqueueNotification(uid, "test", "push", "push-sent", "body", "")
+	dispatchDueNotifications(time.Now())
+	out, _ := os.ReadFile(cfg.PushOutboxFile)
+	db.DB.QueryRow("SELECT last_used FROM notifications_push_tokens WHERE user_id = ?", uid).Scan(&lastUsed)
+	if st, _ := status("push-sent"); st != "sent" || !strings.Contains(string(out), `"token":"tok-1"`) || lastUsed == 1 {
+		t.Errorf("push with provider: status %q, last_used %d, outbox %s", st, lastUsed, out)
+	}
+}
+
+func TestAdminBan_BlocksLoginAndRequests(t *testing.T) {
+	setupTestDB(t)
+	user, _, err := AuthRegister("banned@test.com", "password123", "B", ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := user["id"].(int64)
+	res, _ := db.DB.Exec("INSERT INTO users (email, password_hash, name, role) VALUES ('admin@test.com', 'x', 'A', 'admin')")
+	adminID, _ := res.LastInsertId()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/admin/users/1/ban", strings.NewReader(`{"reason":"spam"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: strconv.FormatInt(uid, 10)}}
+	c.Set("userID", adminID)
+	handleAdminUserBan(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("ban: got status %d", w.Code)
+	}
+	var sessions int
+	db.DB.QueryRow("SELECT COUNT(*) FROM sessions WHERE user_id = ?", uid).Scan(&sessions)
+	if sessions != 0 {
+		t.Errorf("sessions after ban: got %d, want 0", sessions)
+	}
+	if _, _, err := AuthLogin("banned@test.com", "password123", ClientInfo{}); !errors.Is(err, ErrUserBanned) {
+		t.Errorf("login: got err %v, want ErrUserBanned", err)
+	}
+
+	// A token minted after the ban (e.g. a legacy session-less token) is still rejected.
+	tok, _ := signAccessToken(pqc.Claims{UserID: uid, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	r := gin.New()
+	r.GET("/me", authRequired(), handleUserMe)
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("authRequired: got status %d, want 403", w.Code)
+	}
+
+	db.DB.Exec("UPDATE admin_bans SET lifted_at = unixepoch() WHERE user_id = ?", uid)
+	if _, _, err := AuthLogin("banned@test.com", "password123", ClientInfo{}); err != nil {
+		t.Errorf("login after unban: %v", err)
+	}
+
+	// A failed ban lookup denies access instead of letting the user in.
+	db.DB.Exec("ALTER TABLE admin_bans RENAME TO admin_bans_off")
+	if _, _, err := AuthLogin("banned@test.com", "password123", ClientInfo{}); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("login with ban lookup failing: got err %v", err)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("authRequired with ban lookup failing: got status %d, want 503", w.Code)
+	}
+}
+
+func TestWalletLedger_TransferHoldCaptureBalance(t *testing.T) {
+	setupTestDB(t)
+	newUser := func(email string) int64 {
+		res, err := db.DB.Exec("INSERT INTO users (email, password_hash, name) VALUES (?, 'x', 'U')", email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		return id
+	}
+	buyer, seller := newUser("buyer@test.com"), newUser("seller@test.com")
+	if _, err := BalanceCredit(buyer, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := WalletTransfer(buyer, seller, "USD", 2000); !errors.Is(err, ErrWalletInsufficient) {
+		t.Errorf("overdraft transfer: got %v, want ErrWalletInsufficient", err)
+	}
+	if err := WalletTransfer(buyer, seller, "USD", 100); err != nil {
+		t.Fatal(err)
+	}
+	holdID, err := WalletHold(buyer, nil, "USD", 400, time.Now().Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := WalletBalance(buyer, "USD"); b["available"] != int64(500) || b["hold_amount"] != int64(400) {
+		t.Errorf("buyer after hold: %v", b)
+	}
+	if err := WalletHoldCapture(seller, holdID, seller, 0); !errors.Is(err, ErrHoldForbidden) {
+		t.Errorf("capture by non-owner: got %v, want ErrHoldForbidden", err)
+	}
+	if err := WalletHoldCapture(buyer, holdID, seller, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := WalletHoldRelease(buyer, holdID); !errors.Is(err, ErrHoldClosed) {
+		t.Errorf("release after capture: got %v, want ErrHoldClosed", err)
+	}
+	if got := BalanceGet(seller)["balance_minor"]; got != int64(500) {
+		t.Errorf("seller balance: got %v, want 500", got)
+	}
+	if got := BalanceGet(buyer)["balance"]; got != 5.0 {
+		t.Errorf("buyer balance: got %v, want 5", got)
+	}
+	var sum int64
+	db.DB.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM ledger_postings").Scan(&sum)
+	if sum != 0 {
+		t.Errorf("ledger postings sum to %d, want 0", sum)
+	}
+}
+
+func TestIdempotencyKey_ReplayAndMismatch(t *testing.T) {
+	setupTestDB(t)
+	res, _ := db.DB.Exec("INSERT INTO users (email, password_hash, name) VALUES ('idem@test.com', 'x', 'I')")
+	uid, _ := res.LastInsertId()
+	calls := 0
+	r := gin.New()
+	r.POST("/pay", func(c *gin.Context) { c.Set("userID", uid) }, idempotent(), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusOK, gin.H{"call": calls})
+	})
+	do := func(key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/pay", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	first := do("k1", `{"amount":1}`)
+	replay := do("k1", `{"amount":1}`)
+	if calls != 1 || replay.Code != http.StatusOK || replay.Body.String() != first.Body.String() {
+		t.Errorf("replay: calls=%d code=%d body=%q, want 1 call and %q", calls, replay.Code, replay.Body.String(), first.Body.String())
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("replay: missing Idempotent-Replayed header")
+	}
+	if w := do("k1", `{"amount":2}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("different body: got %d, want 422", w.Code)
+	}
+	if do("k2", `{"amount":1}`); calls != 2 {
+		t.Errorf("new key: calls=%d, want 2", calls)
+	}
+
+	// A handler that panics does not leave the key blocked as "in progress".
+	crash := true
+	r.POST("/crash", gin.Recovery(), func(c *gin.Context) { c.Set("userID", uid) }, idempotent(), func(c *gin.Context) {
+		if crash {
+			crash = false
+			panic("boom")
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+	crashCall := func() int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/crash", strings.NewReader(`{}`))
+		req.Header.Set("Idempotency-Key", "k3")
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := crashCall(); code != http.StatusInternalServerError {
+		t.Fatalf("panicking handler: got %d, want 500", code)
+	}
+	if code := crashCall(); code != http.StatusOK {
+		t.Errorf("retry after panic: got %d, want 200", code)
+	}
+
+	// Browsers on another origin may send the header.
+	cors := gin.New()
+	cors.Use(corsMiddleware())
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodOptions, "/pay", nil)
+	req.Header.Set("Origin", "https://app.example")
+	cors.ServeHTTP(w, req)
+	if !strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), "Idempotency-Key") {
+		t.Errorf("CORS allow headers: %q", w.Header().Get("Access-Control-Allow-Headers"))
+	}
+}
+
+func TestWalletLedger_ConcurrentDebitsNeverOverdraw(t *testing.T) {
+	setupTestDB(t)
+	var ids []int64
+	for _, email := range []string{"payer@test.com", "payee@test.com"} {
+		res, err := db.DB.Exec("INSERT INTO users (email, password_hash, name) VALUES (?, 'x', 'U')", email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		ids = append(ids, id)
+	}
+	payer, payee := ids[0], ids[1]
+	if _, err := BalanceCredit(payer, 10); err != nil { // 1000 cents
+		t.Fatal(err)
+	}
+	const workers = 40 // 20 transfers + 20 holds of 100 each; only 10 can succeed
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	ok, insufficient := 0, 0
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if i%2 == 0 {
+				err = WalletTransfer(payer, payee, "USD", 100)
+			} else {
+				_, err = WalletHold(payer, nil, "USD", 100, time.Now().Add(time.Hour).Unix())
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				ok++
+			case errors.Is(err, ErrWalletInsufficient):
+				insufficient++
+			default:
+				t.Errorf("worker %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if ok != 10 || insufficient != workers-10 {
+		t.Errorf("got %d ok / %d insufficient, want 10 / %d", ok, insufficient, workers-10)
+	}
+	b := WalletBalance(payer, "USD")
+	if b["available"] != int64(0) || b["amount"] != b["hold_amount"] {
+		t.Errorf("payer after stress: %v", b)
+	}
+	var negative int
+	db.DB.QueryRow("SELECT COUNT(*) FROM ledger_accounts WHERE user_id != 0 AND balance < 0").Scan(&negative)
+	if negative != 0 {
+		t.Errorf("%d user accounts went negative", negative)
+	}
+	var sum int64
+	db.DB.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM ledger_postings").Scan(&sum)
+	if sum != 0 {
+		t.Errorf("ledger postings sum to %d, want 0", sum)
+	}
+	if _, err := db.DB.Exec("UPDATE ledger_accounts SET balance = -1 WHERE user_id = ?", payer); err == nil {
+		t.Error("DB accepted a negative user balance")
+	}
+}
+
+func TestOrderEscrow_PayCompleteAndCancel(t *testing.T) {
+	setupTestDB(t)
+	newUser := func(email string) int64 {
+		res, err := db.DB.Exec("INSERT INTO users (email, password_hash, name) VALUES (?, 'x', 'U')", email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		return id
+	}
+	buyer, seller, other := newUser("eb@test.com"), newUser("es@test.com"), newUser("eo@test.com")
+	res, _ := db.DB.Exec("INSERT INTO products (user_id, title, price, category) VALUES (?, 'Lamp', 4.5, 'home')", seller)
+	productID, _ := res.LastInsertId()
+	if _, err := BalanceCredit(buyer, 10); err != nil {
+		t.Fatal(err)
+	}
+	newOrder := func() int64 {
+		o, err := OrderCreate(buyer, productID, "", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return o["id"].(int64)
+	}
+	paymentStatus := func(orderID int64) (st string) {
+		db.DB.QueryRow("SELECT payment_status FROM orders WHERE id = ?", orderID).Scan(&st)
+		return
+	}
+
+	completed := newOrder()
+	if _, err := OrderPay(seller, completed); !errors.Is(err, ErrOrderNotBuyer) {
+		t.Errorf("pay by seller: got %v, want ErrOrderNotBuyer", err)
+	}
+	pay, err := OrderPay(buyer, completed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OrderPay(buyer, completed); !errors.Is(err, ErrOrderNotPayable) {
+		t.Errorf("second pay: got %v, want ErrOrderNotPayable", err)
+	}
+	holdID := pay["hold_id"].(int64)
+	if err := WalletHoldRelease(buyer, holdID); !errors.Is(err, ErrHoldOrderLinked) {
+		t.Errorf("wallet release of order hold: got %v, want ErrHoldOrderLinked", err)
+	}
+	// A client-supplied payee is ignored for order holds.
+	if err := OrderApplyStatus(buyer, completed, "pending", "confirmed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := WalletHoldCapture(buyer, holdID, other, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := WalletBalance(other, "USD")["amount"]; got != int64(0) {
+		t.Errorf("other user received %v", got)
+	}
+	if got := WalletBalance(seller, "USD")["available"]; got != int64(450) || paymentStatus(completed) != "captured" {
+		t.Errorf("seller after capture: %v, payment_status %q", got, paymentStatus(completed))
+	}
+
+	cancelled := newOrder()
+	if _, err := OrderPay(buyer, cancelled); err != nil {
+		t.Fatal(err)
+	}
+	if err := OrderApplyStatus(buyer, cancelled, "pending", "cancelled"); err != nil {
+		t.Fatal(err)
+	}
+	if paymentStatus(cancelled) != "released" {
+		t.Errorf("cancelled payment_status %q, want released", paymentStatus(cancelled))
+	}
+	if b := WalletBalance(buyer, "USD"); b["available"] != int64(550) || b["hold_amount"] != int64(0) {
+		t.Errorf("buyer after cancel: %v", b)
+	}
+
+	// A confirmed order can be cancelled to refund a held payment, but not otherwise.
+	confirmedPaid := newOrder()
+	OrderApplyStatus(seller, confirmedPaid, "pending", "confirmed")
+	if _, err := OrderPay(buyer, confirmedPaid); err != nil {
+		t.Fatal(err)
+	}
+	if err := OrderApplyStatus(seller, confirmedPaid, "confirmed", "cancelled"); err != nil {
+		t.Fatal(err)
+	}
+	if b := WalletBalance(buyer, "USD"); paymentStatus(confirmedPaid) != "released" || b["available"] != int64(550) || b["hold_amount"] != int64(0) {
+		t.Errorf("confirmed paid order cancelled: payment_status %q, buyer %v", paymentStatus(confirmedPaid), b)
+	}
+	confirmedUnpaid := newOrder()
+	OrderApplyStatus(seller, confirmedUnpaid, "pending", "confirmed")
+	if err := OrderApplyStatus(buyer, confirmedUnpaid, "confirmed", "cancelled"); !errors.Is(err, ErrOrderNotCancellable) {
+		t.Errorf("cancel confirmed unpaid order: got %v, want ErrOrderNotCancellable", err)
+	}
+
+	viaStatus := newOrder()
+	if _, err := OrderPay(buyer, viaStatus); err != nil {
+		t.Fatal(err)
+	}
+	OrderApplyStatus(buyer, viaStatus, "pending", "confirmed")
+	if err := OrderApplyStatus(buyer, viaStatus, "confirmed", "completed"); err != nil {
+		t.Fatal(err)
+	}
+	if got := WalletBalance(seller, "USD")["available"]; got != int64(900) || paymentStatus(viaStatus) != "captured" {
+		t.Errorf("seller after completed: %v, payment_status %q", got, paymentStatus(viaStatus))
+	}
+
+	// The buyer-only rule is checked on the row read in the transaction: the seller cannot capture a hold
+	// placed after their own read of the order.
+	BalanceCredit(buyer, 10)
+	paid := newOrder()
+	OrderApplyStatus(seller, paid, "pending", "confirmed")
+	if _, err := OrderPay(buyer, paid); err != nil {
+		t.Fatal(err)
+	}
+	if err := OrderApplyStatus(seller, paid, "confirmed", "completed"); !errors.Is(err, ErrOrderCompleteBuyer) {
+		t.Errorf("complete by seller: got %v, want ErrOrderCompleteBuyer", err)
+	}
+	if got := WalletBalance(seller, "USD")["available"]; got != int64(900) || paymentStatus(paid) != "held" {
+		t.Errorf("seller after rejected completion: %v, payment_status %q", got, paymentStatus(paid))
+	}
+
+	// Escrow is optional: an order never paid through the wallet completes as before. One whose hold expired
+	// cannot be completed until the buyer pays again.
+	unpaid := newOrder()
+	OrderApplyStatus(seller, unpaid, "pending", "confirmed")
+	if err := OrderApplyStatus(seller, unpaid, "confirmed", "completed"); err != nil || paymentStatus(unpaid) != "pending" {
+		t.Errorf("complete order without escrow: %v, payment_status %q", err, paymentStatus(unpaid))
+	}
+	db.DB.Exec("UPDATE wallet_holds SET expires_at = ? WHERE order_id = ?", time.Now().Add(-time.Second).Unix(), paid)
+	sweepExpiredHolds(time.Now())
+	if err := OrderApplyStatus(buyer, paid, "confirmed", "completed"); !errors.Is(err, ErrHoldExpired) {
+		t.Errorf("complete after hold expired: got %v, want ErrHoldExpired", err)
+	}
+	if _, err := OrderPay(buyer, paid); err != nil {
+		t.Fatal(err)
+	}
+	if err := OrderApplyStatus(buyer, paid, "confirmed", "completed"); err != nil || paymentStatus(paid) != "captured" {
+		t.Errorf("complete after paying again: %v, payment_status %q", err, paymentStatus(paid))
+	}
+}
+
+func TestHoldSweeper_ReleasesExpiredHolds(t *testing.T) {
+	setupTestDB(t)
+	var ids []int64
+	for _, email := range []string{"hb@test.com", "hs@test.com"} {
+		res, err := db.DB.Exec("INSERT INTO users (email, password_hash, name) VALUES (?, 'x', 'U')", email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		ids = append(ids, id)
+	}
+	buyer, seller := ids[0], ids[1]
+	res, _ := db.DB.Exec("INSERT INTO products (user_id, title, price, category) VALUES (?, 'Lamp', 2, 'home')", seller)
+	productID, _ := res.LastInsertId()
+	BalanceCredit(buyer, 10)
+	now := time.Now()
+	plain, _ := WalletHold(buyer, nil, "USD", 300, now.Add(-time.Minute).Unix())
+	WalletHold(buyer, nil, "USD", 100, now.Add(time.Hour).Unix())
+	order, _ := OrderCreate(buyer, productID, "", false)
+	orderID := order["id"].(int64)
+	if _, err := OrderPay(buyer, orderID); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Exec("UPDATE wallet_holds SET expires_at = ? WHERE order_id = ?", now.Add(-time.Second).Unix(), orderID)
+
+	if n := sweepExpiredHolds(now); n != 2 {
+		t.Fatalf("released %d holds, want 2", n)
+	}
+	if n := sweepExpiredHolds(now); n != 0 {
+		t.Errorf("second sweep released %d holds, want 0", n)
+	}
+	if b := WalletBalance(buyer, "USD"); b["available"] != int64(900) || b["hold_amount"] != int64(100) {
+		t.Errorf("buyer after sweep: %v", b)
+	}
+	var st string
+	db.DB.QueryRow("SELECT payment_status FROM orders WHERE id = ?", orderID).Scan(&st)
+	if st != "expired" {
+		t.Errorf("order payment_status %q, want expired", st)
+	}
+	var rows int
+	db.DB.QueryRow("SELECT COUNT(*) FROM wallet_transactions WHERE user_id = ? AND type = 'hold_expired' AND reference_id = ?", buyer, "hold:"+strconv.FormatInt(plain, 10)).Scan(&rows)
+	if rows != 1 {
+		t.Errorf("hold_expired rows for hold %d: %d, want 1", plain, rows)
+	}
+	if _, err := OrderPay(buyer, orderID); err != nil {
+		t.Errorf("re-pay after expiry: %v", err)
+	}
+}
+
+func TestWalletHolds_PartialCaptureAndStates(t *testing.T) {
+	setupTestDB(t)
+	var ids []int64
+	for _, email := range []string{"pb@test.com", "ps@test.com"} {
+		res, err := db.DB.Exec("INSERT INTO users (email, password_hash, name) VALUES (?, 'x', 'U')", email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		ids = append(ids, id)
+	}
+	owner, payee := ids[0], ids[1]
+	BalanceCredit(owner, 10)
+	exp := time.Now().Add(time.Hour).Unix()
+	partial, _ := WalletHold(owner, nil, "USD", 400, exp)
+	released, _ := WalletHold(owner, nil, "USD", 100, exp)
+	WalletHold(owner, nil, "USD", 50, exp)
+
+	if err := WalletHoldCapture(owner, partial, payee, 500); !errors.Is(err, ErrHoldCaptureAmount) {
+		t.Errorf("over-capture: got %v, want ErrHoldCaptureAmount", err)
+	}
+	if err := WalletHoldCapture(owner, partial, payee, 150); err != nil {
+		t.Fatal(err)
+	}
+	if err := WalletHoldRelease(owner, released); err != nil {
+		t.Fatal(err)
+	}
+	if err := WalletHoldRelease(owner, partial); !errors.Is(err, ErrHoldClosed) {
+		t.Errorf("release after capture: got %v, want ErrHoldClosed", err)
+	}
+	h, err := WalletHoldGet(owner, partial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h["status"] != "captured" || h["captured_amount"] != int64(150) || h["captured_to"] != payee || h["captured_at"] == int64(0) || h["released_at"] == int64(0) {
+		t.Errorf("partially captured hold: %v", h)
+	}
+	if b := WalletBalance(owner, "USD"); b["available"] != int64(800) || b["hold_amount"] != int64(50) {
+		t.Errorf("owner after partial capture: %v", b)
+	}
+	// The statement adds up to the available balance: deposit, hold rows, the released hold and the capture remainder.
+	var statement, remainderRows int64
+	db.DB.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM wallet_transactions WHERE user_id = ?", owner).Scan(&statement)
+	db.DB.QueryRow("SELECT COUNT(*) FROM wallet_transactions WHERE user_id = ? AND type = 'hold_release' AND amount = 250 AND reference_id = ?", owner, "hold:"+strconv.FormatInt(partial, 10)).Scan(&remainderRows)
+	if statement != 800 || remainderRows != 1 {
+		t.Errorf("owner statement sums to %d (want 800), remainder rows %d (want 1)", statement, remainderRows)
+	}
+	if got := WalletBalance(payee, "USD")["available"]; got != int64(150) {
+		t.Errorf("payee: got %v, want 150", got)
+	}
+	count := func(states ...string) int {
+		list, err := WalletHolds(owner, states, 50, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(list)
+	}
+	if count() != 3 || count(holdActive) != 1 || count(holdCaptured, holdReleased) != 2 || count(holdExpired) != 0 {
+		t.Errorf("filters: all=%d active=%d captured+released=%d expired=%d", count(), count(holdActive), count(holdCaptured, holdReleased), count(holdExpired))
+	}
+	if _, err := WalletHoldGet(payee, partial); !errors.Is(err, ErrHoldNotFound) {
+		t.Errorf("other user's hold: got %v, want ErrHoldNotFound", err)
+	}
+}
+
+func TestEventBus_OrderStatusAuditAndNotification(t *testing.T) {
+	setupTestDB(t)
+	initEventBus()
+	t.Cleanup(func() { bus.Close(); bus, outbox = nil, nil })
+	var ids []int64
+	for _, email := range []string{"evb@test.com", "evs@test.com"} {
+		res, err := db.DB.Exec("INSERT INTO users (email, password_hash, name) VALUES (?, 'x', 'U')", email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		ids = append(ids, id)
+	}
+	buyer, seller := ids[0], ids[1]
+	res, _ := db.DB.Exec("INSERT INTO products (user_id, title, price, category) VALUES (?, 'Lamp', 1, 'home')", seller)
+	productID, _ := res.LastInsertId()
+	order, _ := OrderCreate(buyer, productID, "", false)
+	orderID := order["id"].(int64)
+	if err := OrderApplyStatus(seller, orderID, "pending", "confirmed"); err != nil {
+		t.Fatal(err)
+	}
+	outbox.RelayOnce()
+
+	var action, entityType, entityID string
+	var actor int64
+	err := db.DB.QueryRow("SELECT user_id, action, entity_type, entity_id FROM audit_log ORDER BY id DESC LIMIT 1").Scan(&actor, &action, &entityType, &entityID)
+	if err != nil || actor != seller || action != TopicOrderStatusChanged || entityType != "order" || entityID != strconv.FormatInt(orderID, 10) {
+		t.Errorf("audit row: %d %q %q %q (err %v)", actor, action, entityType, entityID, err)
+	}
+	var notified int
+	db.DB.QueryRow("SELECT COUNT(*) FROM notifications_queue WHERE user_id = ? AND type = 'order_status'", buyer).Scan(&notified)
+	if notified != 1 {
+		t.Errorf("buyer notifications: %d, want 1", notified)
+	}
+}
+
+func TestEventOutbox_RetryThenDeadLetter(t *testing.T) {
+	setupTestDB(t)
+	ob := event.NewOutbox(db.DB, decodeEventPayload)
+	ob.MaxAttempts = 3
+	var got []event.Event
+	failing := true
+	ob.Subscribe("flaky", "order.*", func(_ context.Context, ev event.Event) error {
+		got = append(got, ev)
+		if failing {
+			return errors.New("downstream unavailable")
+		}
+		return nil
+	})
+	// Rolled-back transactions leave nothing in the outbox.
+	tx, _ := db.DB.Begin()
+	ob.Enqueue(tx, TopicOrderStatusChanged, OrderStatusChanged{OrderID: 99}, "1", nil)
+	tx.Rollback()
+	ob.Enqueue(db.DB, TopicOrderStatusChanged, OrderStatusChanged{OrderID: 1, To: "confirmed"}, "1", nil)
+	ob.Enqueue(db.DB, TopicMessageSent, MessageSent{MessageID: 5}, "1", nil)
+	ob.Enqueue(db.DB, TopicOrderStatusChanged, OrderStatusChanged{OrderID: 2, To: "completed"}, "1", nil)
+
+	for i := 0; i < 3; i++ {
+		ob.RelayOnce()
+	}
+	// Three attempts at order 1 (stable ID), dead-letter, then the same pass moves on to order 2.
+	if len(got) != 4 || got[0].ID != got[2].ID || got[3].ID == got[0].ID {
+		t.Fatalf("deliveries: %d, want 3 of order 1 then order 2", len(got))
+	}
+	if p, ok := got[0].Payload.(OrderStatusChanged); !ok || p.OrderID != 1 {
+		t.Errorf("payload not decoded: %#v", got[0].Payload)
+	}
+	var dead int
+	db.DB.QueryRow("SELECT COUNT(*) FROM event_dead_letters WHERE subscriber = 'flaky' AND attempts = 3").Scan(&dead)
+	if dead != 1 {
+		t.Errorf("dead letters: %d, want 1", dead)
+	}
+	failing = false
+	got = nil
+	ob.RelayOnce()
+	if len(got) != 1 || got[0].Payload.(OrderStatusChanged).OrderID != 2 {
+		t.Fatalf("after dead-letter: %d deliveries, want order 2 only", len(got))
+	}
+	if ob.RelayOnce() != 0 {
+		t.Error("delivered events must not be redelivered")
+	}
+}
+
+func TestAuthRefresh_RotatesAndRevokesOnReuse(t *testing.T) {
+	setupTestDB(t)
+	if _, _, err := AuthRegister("refresh@test.com", "password123", "R", ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	_, first, err := AuthLogin("refresh@test.com", "password123", ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.RefreshToken == "" || first.ExpiresIn != int64(cfg.AccessTokenTTL/time.Second) {
+		t.Fatalf("login tokens: %+v", first)
+	}
+	claims, err := verifyAccessToken(first.Token)
+	sessionID := claims.SessionID
+	if err != nil || time.Until(claims.Expiry()) > cfg.AccessTokenTTL || claims.Role != "user" {
+		t.Fatalf("access token: %+v, err %v", claims, err)
+	}
+	second, _, err := AuthRefresh(first.RefreshToken)
+	if err != nil || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh: %v (rotated: %v)", err, second.RefreshToken != first.RefreshToken)
+	}
+	if c, err := verifyAccessToken(second.Token); err != nil || c.SessionID != sessionID {
+		t.Fatalf("refreshed access token: session %d, err %v", c.SessionID, err)
+	}
+	// Replaying the first token revokes the session, so the latest token stops working too.
+	if _, _, err := AuthRefresh(first.RefreshToken); !errors.Is(err, ErrRefreshReused) {
+		t.Fatalf("reuse: got %v, want ErrRefreshReused", err)
+	}
+	if _, _, err := AuthRefresh(second.RefreshToken); !errors.Is(err, ErrRefreshInvalid) {
+		t.Errorf("after reuse: got %v, want ErrRefreshInvalid", err)
+	}
+	var n int
+	db.DB.QueryRow("SELECT COUNT(*) FROM sessions WHERE id = ?", sessionID).Scan(&n)
+	if n != 0 {
+		t.Error("session should be revoked after refresh token reuse")
+	}
+}
+
+func TestLoginForm_RedirectCarriesRefreshToken(t *testing.T) {
+	setupTestDB(t)
+	cfg.AppURL = "https://app.omnixius.test"
+	if _, _, err := AuthRegister("form@test.com", "password123", "F", ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.POST("/login", handleLoginForm)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(url.Values{"email": {"form@test.com"}, "password": {"password123"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ServeHTTP(w, req)
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || err != nil {
+		t.Fatalf("status %d, location %q", w.Code, w.Header().Get("Location"))
+	}
+	refresh := loc.Query().Get("refresh_token")
+	if loc.Query().Get("token") == "" || refresh == "" {
+		t.Fatalf("redirect should carry both tokens: %s", loc)
+	}
+	if _, _, err := AuthRefresh(refresh); err != nil {
+		t.Errorf("refresh token from redirect: %v", err)
+	}
+}
+
+func TestSessions_InventoryRevokeOthersAndPasswordChange(t *testing.T) {
+	setupTestDB(t)
+	phone := ClientInfo{IP: "203.0.113.7", UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"}
+	_, first, err := AuthRegister("sessions@test.com", "password123", "S", phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desktop := ClientInfo{IP: "198.51.100.2", UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36"}
+	_, second, err := AuthLogin("sessions@test.com", "password123", desktop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AuthLogin("sessions@test.com", "password123", desktop)
+
+	r := gin.New()
+	r.GET("/auth/sessions", authRequired(), handleAuthSessionsList)
+	r.POST("/auth/sessions/revoke-others", authRequired(), handleAuthSessionsRevokeOthers)
+	r.POST("/auth/change-password", authRequired(), handleChangePassword)
+	call := func(method, path, token, body string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		var out map[string]any
+		json.Unmarshal(w.Body.Bytes(), &out)
+		return w.Code, out
+	}
+
+	code, out := call(http.MethodGet, "/auth/sessions", second.Token, "")
+	list, _ := out["sessions"].([]any)
+	if code != http.StatusOK || len(list) != 3 {
+		t.Fatalf("list: status %d, %d sessions", code, len(list))
+	}
+	var current, mobile map[string]any
+	for _, v := range list {
+		s := v.(map[string]any)
+		if s["current"] == true {
+			current = s
+		}
+		if s["client_type"] == "mobile" {
+			mobile = s
+		}
+	}
+	if current == nil || current["ip"] != desktop.IP || current["label"] != "Chrome on Windows" || current["last_used_at"] == nil {
+		t.Errorf("current session: %v", current)
+	}
+	if mobile == nil || mobile["ip"] != phone.IP || mobile["label"] != "Safari on iOS" {
+		t.Errorf("mobile session: %v", mobile)
+	}
+
+	if code, out := call(http.MethodPost, "/auth/sessions/revoke-others", second.Token, ""); code != http.StatusOK || out["revoked"] != float64(2) {
+		t.Fatalf("revoke others: status %d, %v", code, out)
+	}
+	if code, _ := call(http.MethodGet, "/auth/sessions", first.Token, ""); code != http.StatusUnauthorized {
+		t.Errorf("revoked session: got %d, want 401", code)
+	}
+
+	// Changing the password keeps the current session and signs out the rest.
+	_, third, _ := AuthLogin("sessions@test.com", "password123", phone)
+	code, out = call(http.MethodPost, "/auth/change-password", second.Token, `{"current_password":"password123","new_password":"password456"}`)
+	if code != http.StatusOK || out["revoked_sessions"] != float64(1) {
+		t.Fatalf("change password: status %d, %v", code, out)
+	}
+	if code, _ := call(http.MethodGet, "/auth/sessions", third.Token, ""); code != http.StatusUnauthorized {
+		t.Errorf("session after password change: got %d, want 401", code)
+	}
+	if code, _ := call(http.MethodGet, "/auth/sessions", second.Token, ""); code != http.StatusOK {
+		t.Errorf("current session after password change: got %d", code)
+	}
+
+	// A reset by email link signs out every session, refresh tokens included.
+	db.DB.Exec("UPDATE users SET reset_token = 'reset-tok', reset_token_expires = ? WHERE email = 'sessions@test.com'", time.Now().Add(time.Hour).Unix())
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/auth/reset-password", strings.NewReader(`{"token":"reset-tok","password":"password789"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handleResetPassword(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("reset password: %d", w.Code)
+	}
+	if code, _ := call(http.MethodGet, "/auth/sessions", second.Token, ""); code != http.StatusUnauthorized {
+		t.Errorf("session after password reset: got %d, want 401", code)
+	}
+	if _, _, err := AuthRefresh(second.RefreshToken); !errors.Is(err, ErrRefreshInvalid) {
+		t.Errorf("refresh after password reset: got %v, want ErrRefreshInvalid", err)
+	}
+	var refreshRows int
+	db.DB.QueryRow("SELECT COUNT(*) FROM session_refresh_tokens").Scan(&refreshRows)
+	if refreshRows != 0 {
+		t.Errorf("refresh tokens left after reset: %d", refreshRows)
+	}
+}
+
+func TestPasskeys_ManageCloneWarningAndLastMethod(t *testing.T) {
+	setupTestDB(t)
+	user, _, err := AuthRegister("passkeys@test.com", "password123", "P", ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := user["id"].(int64)
+	db.DB.Exec("UPDATE users SET password_set = 0 WHERE id = ?", uid)
+	aaguid := []byte{0xad, 0xce, 0x00, 0x02, 0x35, 0xbc, 0xc6, 0x0a, 0x64, 0x8b, 0x0b, 0x25, 0xf1, 0xf0, 0x55, 0x03}
+	cred := &webauthn.Credential{ID: []byte("credential-1"), Authenticator: webauthn.Authenticator{AAGUID: aaguid, SignCount: 1}}
+	credID, err := saveWebAuthnCredential(uid, cred, "Chrome on macOS")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, _ := ListPasskeys(uid)
+	if len(list) != 1 || list[0]["name"] != "Chrome on macOS" || list[0]["aaguid"] != "adce0002-35bc-c60a-648b-0b25f1f05503" {
+		t.Fatalf("list: %v", list)
+	}
+	if err := RenamePasskey(uid, credID, "  "); !errors.Is(err, ErrPasskeyNameInvalid) {
+		t.Errorf("empty name: got %v", err)
+	}
+	if err := RenamePasskey(uid, credID, "YubiKey"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeletePasskey(uid, credID); !errors.Is(err, ErrLastAuthMethod) {
+		t.Fatalf("delete last method: got %v", err)
+	}
+
+	// A login that advances the counter is recorded; one that does not blocks the passkey and its sessions.
+	cred.Authenticator.SignCount = 7
+	if id, err := passkeyLoginUsed(uid, cred); err != nil || id != credID {
+		t.Fatalf("login: id %d, err %v", id, err)
+	}
+	stored, _ := loadWebAuthnCredentials(uid)
+	if len(stored) != 1 || stored[0].Authenticator.SignCount != 7 {
+		t.Fatalf("stored counter: %+v", stored)
+	}
+	if _, err := issueSession(uid, "passkey", ClientInfo{CredentialID: credID}); err != nil {
+		t.Fatal(err)
+	}
+	cred.Authenticator.CloneWarning = true
+	if _, err := passkeyLoginUsed(uid, cred); !errors.Is(err, ErrPasskeyCloned) {
+		t.Fatalf("cloned login: got %v", err)
+	}
+	var n int
+	db.DB.QueryRow("SELECT COUNT(*) FROM sessions WHERE credential_id = ?", credID).Scan(&n)
+	if n != 0 {
+		t.Errorf("sessions of cloned passkey: %d", n)
+	}
+	cred.Authenticator.CloneWarning = false
+	if _, err := passkeyLoginUsed(uid, cred); !errors.Is(err, ErrPasskeyCloned) {
+		t.Errorf("blocked passkey login: got %v", err)
+	}
+	list, _ = ListPasskeys(uid)
+	if list[0]["name"] != "YubiKey" || list[0]["blocked"] != true || list[0]["last_used_at"] == nil {
+		t.Errorf("after clone: %v", list[0])
+	}
+
+	// A blocked passkey can always be removed; it no longer counts as a way to sign in.
+	if err := DeletePasskey(uid, credID); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeletePasskey(uid, credID); !errors.Is(err, ErrPasskeyNotFound) {
+		t.Errorf("delete twice: got %v", err)
+	}
+}
+
+func TestPersonalAccessTokens_ScopesExpiryAndRevocation(t *testing.T) {
+	setupTestDB(t)
+	user, session, err := AuthRegister("pat@test.com", "password123", "P", ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := user["id"].(int64)
+	if _, err := CreatePAT(uid, "exporter", []string{"orders:read", "admin:all"}, 0); !errors.Is(err, ErrPATScopeInvalid) {
+		t.Errorf("unknown scope: got %v", err)
+	}
+	if _, err := CreatePAT(uid, "exporter", []string{"orders:read"}, 400); !errors.Is(err, ErrPATExpiryRange) {
+		t.Errorf("expiry: got %v", err)
+	}
+	created, err := CreatePAT(uid, "exporter", []string{"wallet:read", "orders:read", "orders:read"}, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pat := created["token"].(string)
+	if !strings.HasPrefix(pat, patPrefix) || len(created["scopes"].([]string)) != 2 {
+		t.Fatalf("created: %v", created)
+	}
+
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r := gin.New()
+	api := r.Group("/api", authRequired())
+	api.GET("/orders/my", ok)
+	api.POST("/orders", ok)
+	api.GET("/wallet/transactions", ok)
+	api.GET("/auth/tokens", ok)
+	api.GET("/users/me", ok)
+	call := func(method, path, token string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/orders/my", http.StatusOK},
+		{http.MethodGet, "/api/wallet/transactions", http.StatusOK},
+		{http.MethodPost, "/api/orders", http.StatusForbidden},
+		{http.MethodGet, "/api/users/me", http.StatusForbidden},
+		{http.MethodGet, "/api/auth/tokens", http.StatusForbidden},
+	} {
+		if got := call(tc.method, tc.path, pat); got != tc.want {
+			t.Errorf("PAT %s %s: got %d, want %d", tc.method, tc.path, got, tc.want)
+		}
+	}
+	if got := call(http.MethodGet, "/api/auth/tokens", session.Token); got != http.StatusOK {
+		t.Errorf("session token on /auth/tokens: got %d", got)
+	}
+
+	list, _ := ListPATs(uid)
+	if len(list) != 1 || list[0]["last_used_at"] == nil || list[0]["token"] != nil || list[0]["active"] != true {
+		t.Fatalf("list: %v", list)
+	}
+	id := created["id"].(int64)
+	db.DB.Exec("UPDATE personal_access_tokens SET expires_at = unixepoch() - 1 WHERE id = ?", id)
+	if got := call(http.MethodGet, "/api/orders/my", pat); got != http.StatusUnauthorized {
+		t.Errorf("expired PAT: got %d", got)
+	}
+	db.DB.Exec("UPDATE personal_access_tokens SET expires_at = unixepoch() + 60 WHERE id = ?", id)
+	if err := RevokePAT(uid, id); err != nil {
+		t.Fatal(err)
+	}
+	if got := call(http.MethodGet, "/api/orders/my", pat); got != http.StatusUnauthorized {
+		t.Errorf("revoked PAT: got %d", got)
+	}
+	if err := RevokePAT(uid, id); !errors.Is(err, ErrPATNotFound) {
+		t.Errorf("revoke twice: got %v", err)
+	}
+}
+
+func TestOAuth_AuthorizationCodePKCERefreshAndRevocation(t *testing.T) {
+	setupTestDB(t)
+	if _, _, err := AuthRegister("oauth@test.com", "password123", "O", ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	dev, _, _ := AuthRegister("dev@test.com", "password123", "D", ClientInfo{})
+	client, err := RegisterOAuthClient(dev["id"].(int64), "Ledger Sync", []string{"https://partner.example/cb"}, []string{"orders:read", "wallet:read"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID, secret := client["client_id"].(string), client["client_secret"].(string)
+
+	r := gin.New()
+	r.GET("/oauth/authorize", handleOAuthAuthorize)
+	r.POST("/oauth/authorize", handleOAuthAuthorize)
+	r.POST("/oauth/token", handleOAuthToken)
+	r.POST("/oauth/revoke", handleOAuthRevoke)
+	api := r.Group("/api", authRequired())
+	api.GET("/orders/my", func(c *gin.Context) { c.Status(http.StatusOK) })
+	api.POST("/orders", func(c *gin.Context) { c.Status(http.StatusOK) })
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	form := func(path string, v url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(v.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return serve(req)
+	}
+	bearer := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return serve(req).Code
+	}
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk-padding-for-length"
+	authz := url.Values{
+		"response_type": {"code"}, "client_id": {clientID}, "redirect_uri": {"https://partner.example/cb"},
+		"scope": {"orders:read"}, "state": {"xyz"}, "code_challenge": {pkceS256(verifier)}, "code_challenge_method": {"S256"},
+	}
+	if w := serve(httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+authz.Encode(), nil)); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Ledger Sync") {
+		t.Fatalf("consent page: %d", w.Code)
+	}
+	bad := url.Values{}
+	for k, v := range authz {
+		bad[k] = v
+	}
+	bad.Set("redirect_uri", "https://evil.example/cb")
+	if w := serve(httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+bad.Encode(), nil)); w.Code != http.StatusBadRequest {
+		t.Errorf("unregistered redirect_uri: got %d, want 400 without redirect", w.Code)
+	}
+
+	consent := func(decision, password string) *httptest.ResponseRecorder {
+		v := url.Values{"decision": {decision}, "email": {"oauth@test.com"}, "password": {password}}
+		for k, vv := range authz {
+			v[k] = vv
+		}
+		return form("/oauth/authorize", v)
+	}
+	if w := consent("deny", ""); w.Code != http.StatusFound || !strings.Contains(w.Header().Get("Location"), "error=access_denied") {
+		t.Errorf("deny: %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if w := consent("approve", "wrong-password"); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: got %d", w.Code)
+	}
+	w := consent("approve", "password123")
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	code := loc.Query().Get("code")
+	if w.Code != http.StatusFound || code == "" || loc.Query().Get("state") != "xyz" {
+		t.Fatalf("approve: %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	exchange := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"https://partner.example/cb"},
+		"client_id": {clientID}, "client_secret": {secret}, "code_verifier": {"wrong-verifier-wrong-verifier-wrong-verifier"}}
+	if w := form("/oauth/token", exchange); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_grant") {
+		t.Fatalf("wrong verifier: %d %s", w.Code, w.Body.String())
+	}
+	exchange.Set("code_verifier", verifier)
+	w = form("/oauth/token", exchange)
+	var tokens OAuthTokens
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	if w.Code != http.StatusOK || tokens.AccessToken == "" || tokens.Scope != "orders:read" {
+		t.Fatalf("token: %d %s", w.Code, w.Body.String())
+	}
+	if got := bearer(http.MethodGet, "/api/orders/my", tokens.AccessToken); got != http.StatusOK {
+		t.Errorf("orders:read token on GET: %d", got)
+	}
+	if got := bearer(http.MethodPost, "/api/orders", tokens.AccessToken); got != http.StatusForbidden {
+		t.Errorf("orders:read token on POST: %d", got)
+	}
+
+	refresh := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(refresh.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, secret)
+	var rotated OAuthTokens
+	json.Unmarshal(serve(req).Body.Bytes(), &rotated)
+	if rotated.RefreshToken == "" || rotated.RefreshToken == tokens.RefreshToken {
+		t.Fatalf("refresh did not rotate: %+v", rotated)
+	}
+	// Replaying the code (or a used refresh token) revokes the grant and every token issued from it.
+	if w := form("/oauth/token", exchange); w.Code != http.StatusBadRequest {
+		t.Errorf("code replay: got %d", w.Code)
+	}
+	if got := bearer(http.MethodGet, "/api/orders/my", rotated.AccessToken); got != http.StatusUnauthorized {
+		t.Errorf("token after replay: got %d, want 401", got)
+	}
+
+	// A new consent creates a new grant that admins can see and revoke.
+	w = consent("approve", "password123")
+	loc, _ = url.Parse(w.Header().Get("Location"))
+	exchange.Set("code", loc.Query().Get("code"))
+	json.Unmarshal(form("/oauth/token", exchange).Body.Bytes(), &tokens)
+	grants, _ := ListOAuthGrants(0, 0, false, 10)
+	if len(grants) != 2 || grants[0]["revoked_at"] != nil || grants[1]["revoked_at"] == nil {
+		t.Fatalf("admin grant list: %v", grants)
+	}
+	if err := AdminRevokeGrant(1, grants[0]["id"].(int64)); err != nil {
+		t.Fatal(err)
+	}
+	if got := bearer(http.MethodGet, "/api/orders/my", tokens.AccessToken); got != http.StatusUnauthorized {
+		t.Errorf("token after admin revoke: got %d, want 401", got)
+	}
+	if w := form("/oauth/revoke", url.Values{"token": {"unknown"}, "client_id": {clientID}, "client_secret": {"bad"}}); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoke with bad secret: got %d", w.Code)
+	}
+}
+
+func TestRBAC_ModeratorFinanceAndRoleManagement(t *testing.T) {
+	setupTestDB(t)
+	register := func(email string) (int64, string) {
+		u, tok, err := AuthRegister(email, "password123", "S", ClientInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u["id"].(int64), tok.Token
+	}
+	adminID, adminTok := register("root@test.com")
+	db.DB.Exec("UPDATE users SET role = 'admin' WHERE id = ?", adminID)
+	modID, modTok := register("mod@test.com")
+	finID, finTok := register("fin@test.com")
+	sellerID, _ := register("seller@test.com")
+	product, err := ProductCreate(sellerID, "Fake watch", "d", "other", "Berlin", "", 10, 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid := strconv.FormatInt(product["id"].(int64), 10)
+	res, _ := db.DB.Exec("INSERT INTO admin_reports (reporter_id, reported_type, reported_id, reason) VALUES (?, 'product', ?, 'counterfeit')", finID, pid)
+	reportID, _ := res.LastInsertId()
+
+	r := gin.New()
+	admin := r.Group("/api/admin", authRequired())
+	admin.POST("/reports/:id/resolve", permissionRequired(PermReportsManage), handleAdminReportResolve)
+	admin.POST("/products/:id/takedown", permissionRequired(PermProductsTakedown), handleAdminProductTakedown)
+	admin.POST("/users/:id/wallet/adjust", permissionRequired(PermWalletAdjust), idempotent(), handleAdminWalletAdjust)
+	admin.POST("/users/:id/ban", permissionRequired(PermUsersBan), handleAdminUserBan)
+	admin.PUT("/roles/:name", adminRequired(), handleAdminRolePut)
+	admin.DELETE("/roles/:name", adminRequired(), handleAdminRoleDelete)
+	admin.POST("/users/:id/roles", adminRequired(), handleAdminUserRoleGrant)
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	grant := func(uid int64, role string) {
+		if w := call("POST", "/api/admin/users/"+strconv.FormatInt(uid, 10)+"/roles", adminTok, `{"role":"`+role+`"}`); w.Code != http.StatusOK {
+			t.Fatalf("grant %s: %d %s", role, w.Code, w.Body.String())
+		}
+	}
+
+	resolve := "/api/admin/reports/" + strconv.FormatInt(reportID, 10) + "/resolve"
+	if w := call("POST", resolve, modTok, `{"resolution":"removed"}`); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "reports.manage") {
+		t.Fatalf("resolve without role: %d %s", w.Code, w.Body.String())
+	}
+	if w := call("POST", "/api/admin/users/"+strconv.FormatInt(modID, 10)+"/roles", modTok, `{"role":"moderator"}`); w.Code != http.StatusForbidden {
+		t.Errorf("non-admin granting roles: got %d, want 403", w.Code)
+	}
+	grant(modID, "moderator")
+	grant(finID, "finance")
+
+	if w := call("POST", resolve, modTok, `{"resolution":"removed"}`); w.Code != http.StatusOK {
+		t.Errorf("moderator resolve: %d", w.Code)
+	}
+	if w := call("POST", "/api/admin/products/"+pid+"/takedown", modTok, `{"reason":"counterfeit"}`); w.Code != http.StatusOK {
+		t.Fatalf("takedown: %d %s", w.Code, w.Body.String())
+	}
+	if _, err := ProductGet(pid); !errors.Is(err, ErrProductNotFound) {
+		t.Errorf("taken-down product still public: %v", err)
+	}
+	if _, err := OrderCreate(finID, product["id"].(int64), "", false); !errors.Is(err, ErrOrderProductNotFound) {
+		t.Errorf("order on taken-down product: got %v", err)
+	}
+	var notices int
+	db.DB.QueryRow("SELECT COUNT(*) FROM notifications_queue WHERE user_id = ? AND type = 'product_takedown'", sellerID).Scan(&notices)
+	if notices != 1 {
+		t.Errorf("seller takedown notifications: got %d, want 1", notices)
+	}
+
+	adjust := "/api/admin/users/" + strconv.FormatInt(sellerID, 10) + "/wallet/adjust"
+	if w := call("POST", adjust, modTok, `{"currency":"USD","amount":500,"reason":"refund"}`); w.Code != http.StatusForbidden {
+		t.Errorf("moderator wallet adjust: got %d, want 403", w.Code)
+	}
+	if w := call("POST", adjust, finTok, `{"currency":"USD","amount":500,"reason":"refund"}`); w.Code != http.StatusOK {
+		t.Fatalf("finance credit: %d %s", w.Code, w.Body.String())
+	}
+	if w := call("POST", adjust, finTok, `{"currency":"USD","amount":-800,"reason":"chargeback"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("debit below zero: got %d, want 400", w.Code)
+	}
+	if w := call("POST", adjust, finTok, `{"currency":"USD","amount":-200,"reason":"chargeback"}`); w.Code != http.StatusOK {
+		t.Errorf("finance debit: %d", w.Code)
+	}
+	if got := WalletBalance(sellerID, "USD")["available"]; got != int64(300) {
+		t.Errorf("balance after adjustments: got %v, want 300", got)
+	}
+	// Finance cannot credit itself or touch accounts that outrank it.
+	if w := call("POST", "/api/admin/users/"+strconv.FormatInt(finID, 10)+"/wallet/adjust", finTok, `{"currency":"USD","amount":500,"reason":"bonus"}`); w.Code != http.StatusForbidden {
+		t.Errorf("finance self-credit: got %d, want 403", w.Code)
+	}
+	for _, uid := range []int64{adminID, modID} {
+		if w := call("POST", "/api/admin/users/"+strconv.FormatInt(uid, 10)+"/wallet/adjust", finTok, `{"currency":"USD","amount":500,"reason":"bonus"}`); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "role_outranked") {
+			t.Errorf("finance adjusting user %d: %d %s", uid, w.Code, w.Body.String())
+		}
+	}
+	// A retried adjustment with the same Idempotency-Key credits once.
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", adjust, strings.NewReader(`{"currency":"USD","amount":100,"reason":"goodwill"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+finTok)
+		req.Header.Set("Idempotency-Key", "adjust-goodwill-1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("idempotent adjust %d: %d %s", i, w.Code, w.Body.String())
+		}
+	}
+	if got := WalletBalance(sellerID, "USD")["available"]; got != int64(400) {
+		t.Errorf("balance after replayed adjustment: got %v, want 400", got)
+	}
+
+	if w := call("PUT", "/api/admin/roles/admin", adminTok, `{"permissions":["users.read"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("reserved role name: got %d, want 400", w.Code)
+	}
+	if w := call("PUT", "/api/admin/roles/auditor", adminTok, `{"permissions":["nope"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown permission: got %d, want 400", w.Code)
+	}
+	if w := call("PUT", "/api/admin/roles/auditor", adminTok, `{"description":"Read-only","permissions":["wallet.read","users.read"]}`); w.Code != http.StatusOK {
+		t.Errorf("create role: %d", w.Code)
+	}
+	// Staff with users.ban cannot ban an admin or an account holding permissions they lack.
+	bannerID, bannerTok := register("banner@test.com")
+	if w := call("PUT", "/api/admin/roles/banner", adminTok, `{"permissions":["users.read","users.ban"]}`); w.Code != http.StatusOK {
+		t.Fatalf("create banner role: %d", w.Code)
+	}
+	grant(bannerID, "banner")
+	ban := func(uid int64) int {
+		return call("POST", "/api/admin/users/"+strconv.FormatInt(uid, 10)+"/ban", bannerTok, `{"reason":"abuse"}`).Code
+	}
+	if code := ban(adminID); code != http.StatusForbidden {
+		t.Errorf("ban admin: got %d, want 403", code)
+	}
+	if code := ban(modID); code != http.StatusForbidden {
+		t.Errorf("ban moderator: got %d, want 403", code)
+	}
+	if code := ban(sellerID); code != http.StatusOK {
+		t.Errorf("ban seller: got %d, want 200", code)
+	}
+
+	if w := call("DELETE", "/api/admin/roles/moderator", adminTok, ""); w.Code != http.StatusConflict {
+		t.Errorf("delete builtin role: got %d, want 409", w.Code)
+	}
+	if w := call("DELETE", "/api/admin/roles/auditor", adminTok, ""); w.Code != http.StatusOK {
+		t.Errorf("delete custom role: %d", w.Code)
+	}
+	if perms := userPermissions(finID, "user"); !slices.Equal(perms, []string{"orders.read", "users.read", "wallet.adjust", "wallet.read"}) {
+		t.Errorf("finance permissions: %v", perms)
+	}
+}
+
+func TestRateLimit_UserAndIPBudgetsWithHeaders(t *testing.T) {
+	setupTestDB(t)
+	cfg.RateLimitWrite, cfg.RateLimitIPMultiplier, cfg.MaxLoginAttempts = 3, 2, 2
+	_, alice, _ := AuthRegister("alice@test.com", "password123", "A", ClientInfo{})
+	_, bob, _ := AuthRegister("bob@test.com", "password123", "B", ClientInfo{})
+	r := gin.New()
+	r.Use(rateLimitMiddleware())
+	r.POST("/api/auth/login", handleLogin)
+	r.GET("/api/products", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/api/orders", authRequired(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	call := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"email":"alice@test.com","password":"wrong-password"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := call("GET", "/api/products", ""); w.Header().Get("RateLimit-Limit") != "600" || w.Header().Get("RateLimit-Remaining") != "599" {
+		t.Errorf("read headers: limit %q remaining %q", w.Header().Get("RateLimit-Limit"), w.Header().Get("RateLimit-Remaining"))
+	}
+	for i := 0; i < 3; i++ {
+		if w := call("POST", "/api/orders", alice.Token); w.Code != http.StatusOK {
+			t.Fatalf("alice write %d: %d", i+1, w.Code)
+		}
+	}
+	w := call("POST", "/api/orders", alice.Token)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "20" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("alice over budget: %d retry-after %q remaining %q", w.Code, w.Header().Get("Retry-After"), w.Header().Get("RateLimit-Remaining"))
+	}
+	// Bob shares the IP: his own budget is untouched, but the IP budget (3 x 2) runs out after two more writes.
+	for i := 0; i < 2; i++ {
+		if w := call("POST", "/api/orders", bob.Token); w.Code != http.StatusOK {
+			t.Fatalf("bob write %d: %d", i+1, w.Code)
+		}
+	}
+	if w := call("POST", "/api/orders", bob.Token); w.Code != http.StatusTooManyRequests {
+		t.Errorf("shared IP over budget: got %d, want 429", w.Code)
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := call("POST", "/api/auth/login", ""); w.Code != http.StatusUnauthorized {
+			t.Fatalf("login attempt %d: %d", i+1, w.Code)
+		}
+	}
+	if w := call("POST", "/api/auth/login", ""); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("login limiter: %d retry-after %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+// testPasskey is a software Ed25519 authenticator that drives the passkey login endpoints end to end.
+type testPasskey struct {
+	id    []byte
+	key   ed25519.PrivateKey
+	count uint32
+}
+
+func newTestPasskey(t *testing.T, userID int64, id string) *testPasskey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cose, err := webauthncbor.Marshal(map[int]any{1: 1, 3: -8, -1: 6, -2: []byte(pub)}) // OKP, EdDSA, Ed25519, x
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := &webauthn.Credential{ID: []byte(id), PublicKey: cose, Authenticator: webauthn.Authenticator{SignCount: 1}}
+	if _, err := saveWebAuthnCredential(userID, cred, id); err != nil {
+		t.Fatal(err)
+	}
+	return &testPasskey{id: []byte(id), key: priv, count: 1}
+}
+
+// login runs /auth/login/begin and signs the challenge for /auth/login/complete. It returns the first
+// response that is not a 200 from begin, otherwise the complete response.
+func (k *testPasskey) login(t *testing.T, email string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := gin.New()
+	r.POST("/auth/login/begin", handlePasskeyLoginBegin)
+	r.POST("/auth/login/complete", handlePasskeyLoginComplete)
+	post := func(path, body, session string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if session != "" {
+			req.Header.Set("X-WebAuthn-Session", session)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w := post("/auth/login/begin", `{"email":"`+email+`"}`, "")
+	if w.Code != http.StatusOK {
+		return w
+	}
+	var begin struct {
+		SessionID string `json:"session_id"`
+		Options   struct {
+			PublicKey struct {
+				Challenge string `json:"challenge"`
+			} `json:"publicKey"`
+		} `json:"options"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &begin); err != nil {
+		t.Fatal(err)
+	}
+	k.count++
+	clientData, _ := json.Marshal(map[string]string{"type": "webauthn.get", "challenge": begin.Options.PublicKey.Challenge, "origin": cfg.WebAuthnRPOrigins[0]})
+	rpHash := sha256.Sum256([]byte(cfg.WebAuthnRPID))
+	authData := append(rpHash[:], 0x05, byte(k.count>>24), byte(k.count>>16), byte(k.count>>8), byte(k.count)) // UP|UV, sign count
+	clientHash := sha256.Sum256(clientData)
+	sig := ed25519.Sign(k.key, append(append([]byte{}, authData...), clientHash[:]...))
+	b64 := base64.RawURLEncoding.EncodeToString
+	body, _ := json.Marshal(map[string]any{
+		"id": b64(k.id), "rawId": b64(k.id), "type": "public-key",
+		"response": map[string]string{"authenticatorData": b64(authData), "clientDataJSON": b64(clientData), "signature": b64(sig)},
+	})
+	return post("/auth/login/complete", string(body), begin.SessionID)
+}
+
+func TestLoginAlerts_NewDeviceReportForcesPasswordReset(t *testing.T) {
+	setupTestDB(t)
+	const (
+		chromeWin  = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+		firefoxMac = "Mozilla/5.0 (Macintosh; Intel Mac OS X 14.1; rv:121.0) Gecko/20100101 Firefox/121.0"
+		safariIOS  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1"
+	)
+	user, _, err := AuthRegister("alerts@test.com", "password123", "A", ClientInfo{IP: "203.0.113.5", UserAgent: chromeWin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := user["id"].(int64)
+	if err := initWebAuthn(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { webauthnInstance = nil })
+	ownKey := newTestPasskey(t, uid, "own-key")
+	db.DB.Exec("UPDATE webauthn_credentials SET created_at = created_at - 3600 WHERE user_id = ?", uid)
+	alerts := func() (n, suspicious int) {
+		db.DB.QueryRow("SELECT COUNT(*), COALESCE(SUM(suspicious), 0) FROM login_alerts WHERE user_id = ?", uid).Scan(&n, &suspicious)
+		return
+	}
+	if _, _, err := AuthLogin("alerts@test.com", "password123", ClientInfo{IP: "198.51.100.20", UserAgent: chromeWin}); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := alerts(); n != 0 {
+		t.Fatalf("known device from a new network: %d alerts, want 0", n)
+	}
+	if _, _, err := AuthLogin("alerts@test.com", "password123", ClientInfo{IP: "203.0.113.77", UserAgent: safariIOS}); err != nil {
+		t.Fatal(err)
+	}
+	if n, s := alerts(); n != 1 || s != 0 {
+		t.Fatalf("new device on a known network: %d alerts (%d suspicious), want 1 (0)", n, s)
+	}
+	if _, _, err := AuthLogin("alerts@test.com", "password123", ClientInfo{IP: "192.0.2.44", UserAgent: firefoxMac}); err != nil {
+		t.Fatal(err)
+	}
+	// The attacker opens a second session and a personal access token from the now known device.
+	_, attacker, err := AuthLogin("alerts@test.com", "password123", ClientInfo{IP: "192.0.2.45", UserAgent: firefoxMac})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pat, err := CreatePAT(uid, "backdoor", []string{"orders:read"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attackerKey := newTestPasskey(t, uid, "attacker-key")
+	if err := RecoverySetKey(uid, "attacker-recovery-key"); err != nil {
+		t.Fatal(err)
+	}
+	if n, s := alerts(); n != 2 || s != 1 {
+		t.Fatalf("new device on a new network: %d alerts (%d suspicious), want 2 (1)", n, s)
+	}
+	var notices int
+	db.DB.QueryRow("SELECT COUNT(*) FROM notifications_queue WHERE user_id = ? AND type = 'login_new_device'", uid).Scan(&notices)
+	if notices != 2 {
+		t.Errorf("in-app notifications: got %d, want 2", notices)
+	}
+
+	// Confirmation email, then one alert per new device; report the Firefox sign-in.
+	var token string
+	for _, m := range waitForMail(t, 3) {
+		if i := strings.Index(m, "login-alert.html?token="); i >= 0 && strings.Contains(m, "Firefox on macOS") {
+			token = m[i+len("login-alert.html?token=") : i+len("login-alert.html?token=")+64]
+		}
+	}
+	if token == "" {
+		t.Fatal("no alert email for the Firefox sign-in")
+	}
+	var firefoxSession int64
+	db.DB.QueryRow("SELECT session_id FROM login_alerts WHERE user_id = ? AND suspicious = 1", uid).Scan(&firefoxSession)
+
+	report := func() int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/auth/login-alerts/report", strings.NewReader(`{"token":"`+token+`"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		handleLoginAlertReport(c)
+		return w.Code
+	}
+	if code := report(); code != http.StatusOK {
+		t.Fatalf("report: got %d", code)
+	}
+	if code := report(); code != http.StatusBadRequest {
+		t.Errorf("reused alert link: got %d, want 400", code)
+	}
+	var n int
+	db.DB.QueryRow("SELECT COUNT(*) FROM sessions WHERE id = ?", firefoxSession).Scan(&n)
+	if n != 0 {
+		t.Error("reported session was not revoked")
+	}
+	db.DB.QueryRow("SELECT COUNT(*) FROM sessions WHERE user_id = ?", uid).Scan(&n)
+	if n != 0 {
+		t.Errorf("sessions after report: got %d, want 0", n)
+	}
+	r := gin.New()
+	r.GET("/me", authRequired(), handleUserMe)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+attacker.Token)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("attacker's second session: got %d, want 401", w.Code)
+	}
+	if _, _, err := AuthRefresh(attacker.RefreshToken); !errors.Is(err, ErrRefreshInvalid) {
+		t.Errorf("attacker's refresh token: got %v, want ErrRefreshInvalid", err)
+	}
+	if _, _, err := AuthenticatePAT(pat["token"].(string), ""); !errors.Is(err, ErrPATInvalid) {
+		t.Errorf("personal access token after report: got %v, want ErrPATInvalid", err)
+	}
+	if _, _, err := AuthLogin("alerts@test.com", "password123", ClientInfo{UserAgent: chromeWin}); !errors.Is(err, ErrPasswordResetRequired) {
+		t.Fatalf("login after report: got %v, want ErrPasswordResetRequired", err)
+	}
+	// Passkeys and the recovery key added since the reported sign-in are gone; older passkeys wait for the reset.
+	if w := attackerKey.login(t, "alerts@test.com"); w.Code != http.StatusUnauthorized {
+		t.Errorf("attacker passkey after report: %d %s", w.Code, w.Body)
+	}
+	if w := ownKey.login(t, "alerts@test.com"); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "password_reset_required") {
+		t.Errorf("passkey login after report: %d %s", w.Code, w.Body)
+	}
+	if _, err := RecoveryCheck("alerts@test.com", "attacker-recovery-key"); !errors.Is(err, ErrRecoveryInvalid) {
+		t.Errorf("recovery key set after the reported sign-in: got %v, want ErrRecoveryInvalid", err)
+	}
+
+	var resetToken string
+	db.DB.QueryRow("SELECT reset_token FROM users WHERE id = ?", uid).Scan(&resetToken)
+	w = httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/auth/reset-password", strings.NewReader(`{"token":"`+resetToken+`","password":"new-password-1"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handleResetPassword(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("reset password: %d", w.Code)
+	}
+	if _, _, err := AuthLogin("alerts@test.com", "new-password-1", ClientInfo{UserAgent: chromeWin}); err != nil {
+		t.Errorf("login after reset: %v", err)
+	}
+	if w := ownKey.login(t, "alerts@test.com"); w.Code != http.StatusOK {
+		t.Errorf("passkey login after reset: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestPQCKeyRotation_OldTokensStayValid(t *testing.T) {
+	setupTestDB(t)
+	before := currentKeyring().ActiveID()
+	oldTok, _ := signAccessToken(pqc.Claims{UserID: 1, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	kid, err := RotateSigningKey()
+	if err != nil || kid == before {
+		t.Fatalf("rotate: kid %s (was %s), err %v", kid, before, err)
+	}
+	newTok, _ := signAccessToken(pqc.Claims{UserID: 1, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if _, signedBy, err := currentKeyring().Verify(newTok); err != nil || signedBy != kid {
+		t.Errorf("new token signed by %q, want %q (err %v)", signedBy, kid, err)
+	}
+	for _, tok := range []string{oldTok, newTok} {
+		if _, err := verifyAccessToken(tok); err != nil {
+			t.Errorf("verify after rotation: %v", err)
+		}
+	}
+	// A restart loads the same keyring from the DB.
+	if err := initKeyring(); err != nil || currentKeyring().ActiveID() != kid {
+		t.Fatalf("reload: active %s, err %v", currentKeyring().ActiveID(), err)
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	handleJWKS(c)
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+		} `json:"keys"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &jwks)
+	if len(jwks.Keys) != 2 {
+		t.Errorf("jwks keys: %d, want 2 (active + rotated-out)", len(jwks.Keys))
+	}
+}
+
+func TestMFA_TOTPTwoStepLoginAndBackupCodes(t *testing.T) {
+	setupTestDB(t)
+	cfg.MFAEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+	defer func() { cfg.MFAEncryptionKey = nil }()
+	_, _, err := AuthRegister("mfa@test.com", "password123", "M", ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uid int64
+	db.DB.QueryRow("SELECT id FROM users WHERE email = ?", "mfa@test.com").Scan(&uid)
+	secret, uri, err := MFAEnroll(uid)
+	if err != nil || !strings.HasPrefix(uri, "otpauth://totp/") {
+		t.Fatalf("enroll: uri %q, err %v", uri, err)
+	}
+	step := totp.Step(time.Now())
+	code, _ := totp.CodeAt(secret, step)
+	backup, err := MFAConfirm(uid, code)
+	if err != nil || len(backup) != mfaBackupCodeCount {
+		t.Fatalf("confirm: %d codes, err %v", len(backup), err)
+	}
+
+	_, _, err = AuthLogin("mfa@test.com", "password123", ClientInfo{})
+	var mfa *MFARequiredError
+	if !errors.As(err, &mfa) || mfa.Token == "" {
+		t.Fatalf("login: got %v, want MFARequiredError", err)
+	}
+	// The code used to confirm enrollment cannot be replayed; the next step's code (within skew) works.
+	if _, _, err := AuthLoginMFA(mfa.Token, code, ClientInfo{}); !errors.Is(err, ErrMFAInvalidCode) {
+		t.Fatalf("replayed code: got %v", err)
+	}
+	next, _ := totp.CodeAt(secret, step+1)
+	if _, tokens, err := AuthLoginMFA(mfa.Token, next, ClientInfo{}); err != nil || tokens.Token == "" {
+		t.Fatalf("second step: %v", err)
+	}
+	if _, _, err := AuthLoginMFA(mfa.Token, next, ClientInfo{}); !errors.Is(err, ErrMFAChallengeFailed) {
+		t.Errorf("challenge reuse: got %v", err)
+	}
+
+	_, _, err = AuthLogin("mfa@test.com", "password123", ClientInfo{})
+	errors.As(err, &mfa)
+	if _, _, err := AuthLoginMFA(mfa.Token, strings.ToUpper(backup[0]), ClientInfo{}); err != nil {
+		t.Fatalf("backup code login: %v", err)
+	}
+	_, _, err = AuthLogin("mfa@test.com", "password123", ClientInfo{})
+	errors.As(err, &mfa)
+	if _, _, err := AuthLoginMFA(mfa.Token, backup[0], ClientInfo{}); !errors.Is(err, ErrMFAInvalidCode) {
+		t.Errorf("used backup code: got %v", err)
+	}
+	if st := MFAStatus(uid); st["backup_codes_remaining"] != mfaBackupCodeCount-1 {
+		t.Errorf("status: %v", st)
+	}
+	if err := MFADisable(uid, backup[1]); err != nil || mfaEnabled(uid) {
+		t.Fatalf("disable: %v", err)
+	}
+	var n int
+	db.DB.QueryRow("SELECT COUNT(*) FROM audit_log WHERE user_id = ? AND action IN ('mfa.totp_enabled', 'mfa.backup_code_used', 'mfa.totp_disabled')", uid).Scan(&n)
+	if n != 4 {
+		t.Errorf("audit rows: %d, want 4", n)
+	}
+}
+
+// waitForMail waits for n messages in the test Maildir and returns them quoted-printable decoded.
+func waitForMail(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		paths, _ := mailer.(*mail.Maildir).Delivered()
+		if len(paths) >= n {
+			var out []string
+			for _, p := range paths {
+				f, err := os.Open(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, _ := io.ReadAll(quotedprintable.NewReader(f))
+				f.Close()
+				out = append(out, string(b))
+			}
+			return out
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d emails, want %d", len(paths), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMail_ConfirmAndResetLinks(t *testing.T) {
+	setupTestDB(t)
+	cfg.AppURL = "https://app.omnixius.test"
+	if _, _, err := AuthRegister("mail@test.com", "password123", "Mia", ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	var verifyToken string
+	var verified int
+	db.DB.QueryRow("SELECT email_verify_token, email_verified FROM users WHERE email = ?", "mail@test.com").Scan(&verifyToken, &verified)
+	msgs := waitForMail(t, 1)
+	if verified != 0 || !strings.Contains(msgs[0], "To: mail@test.com") ||
+		!strings.Contains(msgs[0], "https://app.omnixius.test/app/confirm-email.html?token="+verifyToken) {
+		t.Fatalf("confirmation email (verified=%d):\n%s", verified, msgs[0])
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/auth/forgot-password", strings.NewReader(`{"email":"mail@test.com"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handleForgotPassword(c)
+	var resetToken string
+	db.DB.QueryRow("SELECT reset_token FROM users WHERE email = ?", "mail@test.com").Scan(&resetToken)
+	msgs = waitForMail(t, 2)
+	if w.Code != 200 || resetToken == "" || !strings.Contains(msgs[1], "https://app.omnixius.test/app/reset-password.html?token="+resetToken) {
+		t.Fatalf("reset email (status %d):\n%s", w.Code, msgs[1])
+	}
+}
+
+func TestEmailVerification_RequiredModeGatesOrdering(t *testing.T) {
+	setupTestDB(t)
+	cfg.EmailVerification = EmailVerificationRequired
+	_, tokens, err := AuthRegister("unverified@test.com", "password123", "U", ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uid int64
+	var token string
+	db.DB.QueryRow("SELECT id, email_verify_token FROM users WHERE email = ?", "unverified@test.com").Scan(&uid, &token)
+	r := gin.New()
+	r.POST("/orders", authRequired(), verifiedEmailRequired(), func(c *gin.Context) { c.Status(http.StatusCreated) })
+	order := func() int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.Token)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := order(); code != http.StatusForbidden {
+		t.Fatalf("unverified order: got %d, want 403", code)
+	}
+
+	// Resending replaces the token; resending again right away is throttled.
+	db.DB.Exec("UPDATE users SET email_verify_sent_at = 0 WHERE id = ?", uid)
+	if err := AuthResendConfirmEmail(uid); err != nil {
+		t.Fatal(err)
+	}
+	if err := AuthResendConfirmEmail(uid); !errors.Is(err, ErrVerifyResendTooSoon) {
+		t.Errorf("second resend: got %v", err)
+	}
+	if err := AuthConfirmEmail(token); !errors.Is(err, ErrVerifyTokenInvalid) {
+		t.Errorf("replaced token: got %v", err)
+	}
+	db.DB.QueryRow("SELECT email_verify_token FROM users WHERE id = ?", uid).Scan(&token)
+	db.DB.Exec("UPDATE users SET email_verify_expires = unixepoch() - 1 WHERE id = ?", uid)
+	if err := AuthConfirmEmail(token); !errors.Is(err, ErrVerifyTokenExpired) {
+		t.Errorf("expired token: got %v", err)
+	}
+	db.DB.Exec("UPDATE users SET email_verify_expires = unixepoch() + 60 WHERE id = ?", uid)
+	if err := AuthConfirmEmail(token); err != nil {
+		t.Fatal(err)
+	}
+	if code := order(); code != http.StatusCreated {
+		t.Errorf("verified order: got %d, want 201", code)
+	}
+	if err := AuthResendConfirmEmail(uid); !errors.Is(err, ErrEmailAlreadyVerified) {
+		t.Errorf("resend after verify: got %v", err)
+	}
+}
+
+func TestPhoneVerification_OTPFlowAndLimits(t *testing.T) {
+	setupTestDB(t)
+	_, _, err := AuthRegister("phone@test.com", "password123", "P", ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uid int64
+	db.DB.QueryRow("SELECT id FROM users WHERE email = ?", "phone@test.com").Scan(&uid)
+	lastCode := func() string {
+		b, _ := os.ReadFile(cfg.SMSOutboxFile)
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		var m struct{ Text string }
+		json.Unmarshal([]byte(lines[len(lines)-1]), &m)
+		i := strings.Index(m.Text, ": ")
+		return m.Text[i+2 : i+8]
+	}
+
+	if _, err := PhoneStartVerification(uid, "12345"); !errors.Is(err, ErrPhoneInvalid) {
+		t.Errorf("invalid phone: got %v", err)
+	}
+	phone, err := PhoneStartVerification(uid, "+1 (415) 555-0100")
+	if err != nil || phone != "+14155550100" {
+		t.Fatalf("start: %q, %v", phone, err)
+	}
+	if _, err := PhoneStartVerification(uid, "+14155550100"); !errors.Is(err, ErrPhoneResendTooSoon) {
+		t.Errorf("resend: got %v", err)
+	}
+	code := lastCode()
+	for i := 0; i < phoneCodeMaxAttempts; i++ {
+		if _, err := PhoneVerify(uid, "000000x"); !errors.Is(err, ErrPhoneCodeInvalid) {
+			t.Fatalf("wrong code: got %v", err)
+		}
+	}
+	if _, err := PhoneVerify(uid, code); !errors.Is(err, ErrPhoneTooManyAttempts) {
+		t.Fatalf("after attempt limit: got %v", err)
+	}
+
+	db.DB.Exec("UPDATE phone_verifications SET sent_at = 0 WHERE user_id = ?", uid)
+	PhoneStartVerification(uid, "+14155550100")
+	if _, err := PhoneVerify(uid, lastCode()); err != nil {
+		t.Fatal(err)
+	}
+	var stored string
+	var verified int
+	db.DB.QueryRow("SELECT phone, phone_verified FROM users WHERE id = ?", uid).Scan(&stored, &verified)
+	if stored != "+14155550100" || verified != 1 {
+		t.Fatalf("after verify: phone %q verified %d", stored, verified)
+	}
+
+	// A new number is only stored once its own code is verified.
+	PhoneStartVerification(uid, "+442071838750")
+	db.DB.QueryRow("SELECT phone, phone_verified FROM users WHERE id = ?", uid).Scan(&stored, &verified)
+	if stored != "+14155550100" || verified != 1 {
+		t.Errorf("pending change: phone %q verified %d", stored, verified)
+	}
+	if _, err := PhoneVerify(uid, lastCode()); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.QueryRow("SELECT phone FROM users WHERE id = ?", uid).Scan(&stored)
+	if stored != "+442071838750" {
+		t.Errorf("changed phone: %q", stored)
+	}
+}
+
+func TestRecovery_HashedKeyEmailConfirmationAndRateLimit(t *testing.T) {
+	setupTestDB(t)
+	_, first, err := AuthRegister("recover@test.com", "password123", "R", ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uid int64
+	db.DB.QueryRow("SELECT id FROM users WHERE email = ?", "recover@test.com").Scan(&uid)
+	// Rows written before server-side hashing hold the client value and are upgraded on first use.
+	db.DB.Exec("INSERT INTO user_recovery (user_id, recovery_hash) VALUES (?, ?)", uid, "client-derived-key")
+	if _, err := RecoveryCheck("recover@test.com", "client-derived-key"); err != nil {
+		t.Fatal(err)
+	}
+	var stored string
+	db.DB.QueryRow("SELECT recovery_hash FROM user_recovery WHERE user_id = ?", uid).Scan(&stored)
+	if !strings.HasPrefix(stored, "argon2id:") {
+		t.Fatalf("legacy hash not upgraded: %q", stored)
+	}
+	if _, err := RecoveryCheck("nobody@test.com", "client-derived-key"); !errors.Is(err, ErrRecoveryInvalid) {
+		t.Errorf("unknown email: got %v", err)
+	}
+
+	restore := func(ip, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/auth/recovery/restore", strings.NewReader(`{"email":"recover@test.com","recoveryHash":"`+key+`"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.RemoteAddr = ip + ":1234"
+		handleRecoveryRestore(c)
+		return w
+	}
+	if w := restore("10.0.0.1", "client-derived-key"); w.Code != http.StatusAccepted {
+		t.Fatalf("restore: %d %s", w.Code, w.Body)
+	}
+	msgs := waitForMail(t, 2) // confirmation email from registration, then the recovery link
+	i := strings.Index(msgs[1], "recovery-confirm.html?token=")
+	if i < 0 {
+		t.Fatalf("recovery email:\n%s", msgs[1])
+	}
+	token := msgs[1][i+len("recovery-confirm.html?token=") : i+len("recovery-confirm.html?token=")+64]
+	if _, err := verifyAccessToken(first.Token); err != nil {
+		t.Fatal("sessions must survive until the restore is confirmed")
+	}
+	if _, err := CreatePAT(uid, "old", []string{"orders:read"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := RecoveryConfirm(token, ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := AuthRefresh(first.RefreshToken); !errors.Is(err, ErrRefreshInvalid) {
+		t.Errorf("refresh token from before the restore: got %v, want ErrRefreshInvalid", err)
+	}
+	var livePATs int
+	db.DB.QueryRow("SELECT COUNT(*) FROM personal_access_tokens WHERE user_id = ? AND revoked_at IS NULL", uid).Scan(&livePATs)
+	if livePATs != 0 {
+		t.Errorf("personal access tokens after restore: %d active, want 0", livePATs)
+	}
+	if _, _, err := RecoveryConfirm(token, ClientInfo{}); !errors.Is(err, ErrRecoveryConfirmInvalid) {
+		t.Errorf("reused link: got %v", err)
+	}
+	var sessions, notices int
+	db.DB.QueryRow("SELECT COUNT(*) FROM sessions WHERE user_id = ? AND device_name != 'recovery'", uid).Scan(&sessions)
+	db.DB.QueryRow("SELECT COUNT(*) FROM notifications_queue WHERE user_id = ? AND type = 'account_recovered'", uid).Scan(&notices)
+	if sessions != 0 || notices != 3 {
+		t.Errorf("after restore: %d old sessions, %d notifications", sessions, notices)
+	}
+
+	// The account budget applies across IPs.
+	codes := []int{}
+	for i := 0; i < recoveryAttemptsPerHour; i++ {
+		codes = append(codes, restore("10.0.1."+strconv.Itoa(i), "wrong").Code)
+	}
+	if codes[len(codes)-1] != http.StatusTooManyRequests {
+		t.Errorf("per-account limit: statuses %v", codes)
+	}
+}
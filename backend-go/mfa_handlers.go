@@ -0,0 +1,134 @@
+// Two-factor authentication handlers: TOTP enrollment, backup codes and POST /auth/login/2fa.
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mfaError maps two-factor service errors to a status and message; ok is false for unexpected errors.
+func mfaError(err error) (int, string, bool) {
+	switch {
+	case errors.Is(err, ErrMFANotConfigured):
+		return http.StatusServiceUnavailable, err.Error(), true
+	case errors.Is(err, ErrMFAAlreadyEnabled):
+		return http.StatusConflict, err.Error(), true
+	case errors.Is(err, ErrMFANotEnrolled):
+		return http.StatusBadRequest, err.Error(), true
+	case errors.Is(err, ErrMFAInvalidCode):
+		return http.StatusBadRequest, err.Error(), true
+	case errors.Is(err, ErrMFAChallengeFailed):
+		return http.StatusUnauthorized, err.Error(), true
+	}
+	return 0, "", false
+}
+
+func bindMFACode(c *gin.Context) (string, bool) {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Code == "" {
+		c.JSON(400, gin.H{"error": "code required"})
+		return "", false
+	}
+	return body.Code, true
+}
+
+func handleMFAStatus(c *gin.Context) {
+	c.JSON(200, MFAStatus(getUserID(c)))
+}
+
+func handleMFAEnroll(c *gin.Context) {
+	secret, uri, err := MFAEnroll(getUserID(c))
+	if err != nil {
+		if status, msg, ok := mfaError(err); ok {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		c.JSON(500, gin.H{"error": "enrollment failed"})
+		return
+	}
+	c.JSON(200, gin.H{"secret": secret, "otpauth_uri": uri})
+}
+
+func handleMFAConfirm(c *gin.Context) {
+	code, ok := bindMFACode(c)
+	if !ok {
+		return
+	}
+	codes, err := MFAConfirm(getUserID(c), code)
+	if err != nil {
+		if status, msg, ok := mfaError(err); ok {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		c.JSON(500, gin.H{"error": "confirmation failed"})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true, "backup_codes": codes})
+}
+
+func handleMFADisable(c *gin.Context) {
+	code, ok := bindMFACode(c)
+	if !ok {
+		return
+	}
+	if err := MFADisable(getUserID(c), code); err != nil {
+		if status, msg, ok := mfaError(err); ok {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		c.JSON(500, gin.H{"error": "failed to disable two-factor authentication"})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true})
+}
+
+func handleMFABackupCodes(c *gin.Context) {
+	code, ok := bindMFACode(c)
+	if !ok {
+		return
+	}
+	codes, err := MFARegenerateBackupCodes(getUserID(c), code)
+	if err != nil {
+		if status, msg, ok := mfaError(err); ok {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		c.JSON(500, gin.H{"error": "failed to generate backup codes"})
+		return
+	}
+	c.JSON(200, gin.H{"backup_codes": codes})
+}
+
+// handleLoginMFA completes a two-step login: {mfa_token, code} where code is a TOTP or backup code.
+func handleLoginMFA(c *gin.Context) {
+	if !allowLogin(c) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many login attempts. Try again later."})
+		return
+	}
+	var body struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.MFAToken == "" || body.Code == "" {
+		c.JSON(400, gin.H{"error": "mfa_token and code required"})
+		return
+	}
+	user, tokens, err := AuthLoginMFA(body.MFAToken, body.Code, clientInfo(c))
+	if err != nil {
+		if status, msg, ok := mfaError(err); ok {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		if h := loginRefusedJSON(err); h != nil {
+			c.JSON(403, h)
+			return
+		}
+		c.JSON(500, gin.H{"error": "Login failed"})
+		return
+	}
+	c.JSON(200, withTokens(gin.H{"user": user}, tokens))
+}
@@ -0,0 +1,328 @@
+// Two-factor authentication (§1.1): TOTP enrollment, backup codes and the second login step.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"omnixius-api/db"
+	"omnixius-api/internal/crypto"
+	"omnixius-api/internal/totp"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	mfaIssuer          = "OMNIXIUS"
+	mfaChallengeTTL    = 5 * time.Minute
+	mfaChallengeTries  = 5
+	mfaBackupCodeCount = 10
+)
+
+var (
+	ErrMFANotConfigured   = errors.New("two-factor authentication not configured on this server")
+	ErrMFAAlreadyEnabled  = errors.New("two-factor authentication already enabled")
+	ErrMFANotEnrolled     = errors.New("two-factor authentication not enrolled")
+	ErrMFAInvalidCode     = errors.New("invalid authentication code")
+	ErrMFAChallengeFailed = errors.New("login challenge invalid or expired")
+	ErrMFARequired        = errors.New("two-factor authentication required")
+)
+
+// MFARequiredError is returned by AuthLogin when the password was right but a second factor is needed.
+// errors.Is(err, ErrMFARequired) is true for it.
+type MFARequiredError struct {
+	Token     string // challenge for POST /auth/login/2fa
+	ExpiresIn int64
+}
+
+func (e *MFARequiredError) Error() string { return ErrMFARequired.Error() }
+func (e *MFARequiredError) Unwrap() error { return ErrMFARequired }
+
+// JSON is the login response body asking for the second factor.
+func (e *MFARequiredError) JSON() gin.H {
+	return gin.H{"mfa_required": true, "mfa_token": e.Token, "expires_in": e.ExpiresIn, "methods": []string{"totp", "backup_code"}}
+}
+
+func mfaCipher() (*crypto.AESGCMProvider, error) {
+	if len(cfg.MFAEncryptionKey) != 32 {
+		return nil, ErrMFANotConfigured
+	}
+	return crypto.NewAESGCMProvider(map[string][]byte{"mfa": cfg.MFAEncryptionKey}), nil
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// mfaEnabled reports whether the user has confirmed TOTP enrollment.
+func mfaEnabled(userID int64) bool {
+	var n int
+	return db.DB.QueryRow("SELECT 1 FROM user_totp WHERE user_id = ? AND confirmed_at IS NOT NULL", userID).Scan(&n) == nil
+}
+
+// MFAStatus returns whether TOTP is enabled and how many unused backup codes are left.
+func MFAStatus(userID int64) gin.H {
+	var remaining int
+	db.DB.QueryRow("SELECT COUNT(*) FROM user_backup_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&remaining)
+	return gin.H{"enabled": mfaEnabled(userID), "backup_codes_remaining": remaining}
+}
+
+// MFAEnroll starts (or restarts) TOTP enrollment and returns the secret and otpauth:// URI for the QR code.
+// The secret is only used after MFAConfirm.
+func MFAEnroll(userID int64) (secret, uri string, err error) {
+	c, err := mfaCipher()
+	if err != nil {
+		return "", "", err
+	}
+	if mfaEnabled(userID) {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+	var email string
+	if err := db.DB.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email); err != nil {
+		return "", "", err
+	}
+	if secret, err = totp.GenerateSecret(); err != nil {
+		return "", "", err
+	}
+	enc, err := c.Encrypt([]byte(secret), "mfa")
+	if err != nil {
+		return "", "", err
+	}
+	_, err = db.DB.Exec(
+		`INSERT INTO user_totp (user_id, secret_enc, created_at) VALUES (?, ?, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET secret_enc = excluded.secret_enc, confirmed_at = NULL, last_step = 0, created_at = excluded.created_at`,
+		userID, enc, time.Now().Unix(),
+	)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, totp.ProvisioningURI(secret, mfaIssuer, email), nil
+}
+
+// MFAConfirm enables TOTP once the user proves the authenticator works, and returns fresh backup codes.
+func MFAConfirm(userID int64, code string) ([]string, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var confirmed sql.NullInt64
+	if tx.QueryRow("SELECT confirmed_at FROM user_totp WHERE user_id = ?", userID).Scan(&confirmed) != nil {
+		return nil, ErrMFANotEnrolled
+	}
+	if confirmed.Valid {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if err := checkTOTPTx(tx, userID, code); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE user_totp SET confirmed_at = ? WHERE user_id = ?", time.Now().Unix(), userID); err != nil {
+		return nil, err
+	}
+	codes, err := replaceBackupCodesTx(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	auditLog(userID, "mfa.totp_enabled", "user", strconv.FormatInt(userID, 10), "")
+	return codes, nil
+}
+
+// MFADisable turns two-factor authentication off. code is a current TOTP code or an unused backup code.
+func MFADisable(userID int64, code string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if !mfaEnabled(userID) {
+		return ErrMFANotEnrolled
+	}
+	usedBackup, err := checkSecondFactorTx(tx, userID, code)
+	if err != nil {
+		return err
+	}
+	tx.Exec("DELETE FROM user_totp WHERE user_id = ?", userID)
+	tx.Exec("DELETE FROM user_backup_codes WHERE user_id = ?", userID)
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if usedBackup {
+		auditLog(userID, "mfa.backup_code_used", "user", strconv.FormatInt(userID, 10), "")
+	}
+	auditLog(userID, "mfa.totp_disabled", "user", strconv.FormatInt(userID, 10), "")
+	return nil
+}
+
+// MFARegenerateBackupCodes replaces all backup codes after checking a current TOTP code.
+func MFARegenerateBackupCodes(userID int64, code string) ([]string, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if !mfaEnabled(userID) {
+		return nil, ErrMFANotEnrolled
+	}
+	if err := checkTOTPTx(tx, userID, code); err != nil {
+		return nil, err
+	}
+	codes, err := replaceBackupCodesTx(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	auditLog(userID, "mfa.backup_codes_regenerated", "user", strconv.FormatInt(userID, 10), "")
+	return codes, nil
+}
+
+// createMFAChallenge stores a short-lived login challenge and returns its opaque token.
+func createMFAChallenge(userID int64) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	tok := hex.EncodeToString(b)
+	now := time.Now()
+	db.DB.Exec("DELETE FROM mfa_challenges WHERE expires_at <= ?", now.Unix())
+	_, err := db.DB.Exec("INSERT INTO mfa_challenges (token_hash, user_id, expires_at, created_at) VALUES (?, ?, ?, ?)",
+		sha256Hex(tok), userID, now.Add(mfaChallengeTTL).Unix(), now.Unix())
+	return tok, err
+}
+
+// AuthLoginMFA completes a login started by AuthLogin with a TOTP or backup code. A challenge allows
+// mfaChallengeTries wrong codes.
+func AuthLoginMFA(challenge, code string, client ClientInfo) (gin.H, SessionTokens, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, SessionTokens{}, err
+	}
+	defer tx.Rollback()
+	var userID int64
+	var attempts int
+	err = tx.QueryRow("SELECT user_id, attempts FROM mfa_challenges WHERE token_hash = ? AND expires_at > ?",
+		sha256Hex(challenge), time.Now().Unix()).Scan(&userID, &attempts)
+	if err != nil || attempts >= mfaChallengeTries {
+		return nil, SessionTokens{}, ErrMFAChallengeFailed
+	}
+	usedBackup, err := checkSecondFactorTx(tx, userID, code)
+	if err != nil {
+		if errors.Is(err, ErrMFAInvalidCode) {
+			tx.Exec("UPDATE mfa_challenges SET attempts = attempts + 1 WHERE token_hash = ?", sha256Hex(challenge))
+			if cerr := tx.Commit(); cerr != nil {
+				return nil, SessionTokens{}, cerr
+			}
+		}
+		return nil, SessionTokens{}, err
+	}
+	tx.Exec("DELETE FROM mfa_challenges WHERE token_hash = ?", sha256Hex(challenge))
+	if err := tx.Commit(); err != nil {
+		return nil, SessionTokens{}, err
+	}
+	if usedBackup {
+		auditLog(userID, "mfa.backup_code_used", "user", strconv.FormatInt(userID, 10), "")
+	}
+	if err := checkLoginAllowed(userID); err != nil {
+		return nil, SessionTokens{}, err
+	}
+	return completeLogin(userID, client)
+}
+
+// MFAVerify checks a TOTP or backup code outside the login flow (e.g. OAuth consent).
+func MFAVerify(userID int64, code string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	usedBackup, err := checkSecondFactorTx(tx, userID, code)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if usedBackup {
+		auditLog(userID, "mfa.backup_code_used", "user", strconv.FormatInt(userID, 10), "")
+	}
+	return nil
+}
+
+// checkSecondFactorTx accepts a TOTP code, or else an unused backup code, which it marks used.
+// usedBackup tells the caller to audit the backup code use once the transaction commits.
+func checkSecondFactorTx(tx *sql.Tx, userID int64, code string) (usedBackup bool, err error) {
+	err = checkTOTPTx(tx, userID, code)
+	if !errors.Is(err, ErrMFAInvalidCode) {
+		return false, err
+	}
+	res, err := tx.Exec("UPDATE user_backup_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		time.Now().Unix(), userID, sha256Hex(normalizeBackupCode(code)))
+	if err != nil {
+		return false, err
+	}
+	if mustRows(res) == 0 {
+		return false, ErrMFAInvalidCode
+	}
+	return true, nil
+}
+
+// checkTOTPTx validates a TOTP code for the user and records its step so it cannot be replayed.
+func checkTOTPTx(tx *sql.Tx, userID int64, code string) error {
+	c, err := mfaCipher()
+	if err != nil {
+		return err
+	}
+	var enc []byte
+	var lastStep int64
+	if tx.QueryRow("SELECT secret_enc, last_step FROM user_totp WHERE user_id = ?", userID).Scan(&enc, &lastStep) != nil {
+		return ErrMFANotEnrolled
+	}
+	secret, err := c.Decrypt(enc, "mfa")
+	if err != nil {
+		return err
+	}
+	step, ok := totp.Validate(string(secret), code, time.Now(), lastStep)
+	if !ok {
+		return ErrMFAInvalidCode
+	}
+	_, err = tx.Exec("UPDATE user_totp SET last_step = ? WHERE user_id = ?", step, userID)
+	return err
+}
+
+// replaceBackupCodesTx deletes the user's backup codes and returns mfaBackupCodeCount new ones ("xxxxx-xxxxx").
+func replaceBackupCodesTx(tx *sql.Tx, userID int64) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM user_backup_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	now := time.Now().Unix()
+	codes := make([]string, 0, mfaBackupCodeCount)
+	for i := 0; i < mfaBackupCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(enc.EncodeToString(b))[:10]
+		if _, err := tx.Exec("INSERT INTO user_backup_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)", userID, sha256Hex(raw), now); err != nil {
+			return nil, err
+		}
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+	}
+	return codes, nil
+}
+
+func normalizeBackupCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
@@ -0,0 +1,264 @@
+// Notification dispatcher (§16): delivers due notifications_queue rows by channel, retries with backoff.
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"omnixius-api/db"
+	"omnixius-api/internal/mail"
+	"omnixius-api/internal/push"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	notifyBatchSize   = 50
+	notifyBaseBackoff = 30 * time.Second
+	notifyMaxBackoff  = time.Hour
+)
+
+var (
+	errNotifyNoRecipient    = errors.New("no recipient for channel")
+	errNotifyUnknownChannel = errors.New("unknown channel")
+	errNotifyNoPushProvider = errors.New("no push provider configured")
+)
+
+// pushSender delivers push notifications; nil until a provider is configured.
+var pushSender push.Sender
+
+// initPushSender writes push messages to PUSH_OUTBOX_FILE when set; otherwise there is no provider.
+func initPushSender() {
+	pushSender = nil
+	if cfg.PushOutboxFile != "" {
+		pushSender = push.NewFileSender(cfg.PushOutboxFile)
+		log.Printf("Push: writing messages to %s", cfg.PushOutboxFile)
+	}
+}
+
+// queuedNotification is one notifications_queue row claimed for delivery.
+type queuedNotification struct {
+	ID          int64
+	UserID      int64
+	Type        string
+	Channel     string
+	Title       string
+	Body        string
+	Data        sql.NullString
+	Attempts    int
+	MaxAttempts int
+}
+
+// notificationSender delivers one notification. errNotifyNoRecipient and errNotifyNoPushProvider fail the row without
+// retry; other errors retry.
+type notificationSender func(n queuedNotification) error
+
+var notificationSenders = map[string]notificationSender{
+	"websocket": sendNotificationWS,
+	"in_app":    sendNotificationWS,
+	"email":     sendNotificationEmail,
+	"push":      sendNotificationPush,
+}
+
+var notifyWake = make(chan struct{}, 1)
+
+// startNotificationDispatcher polls the queue every interval (or sooner when woken by enqueueNotification).
+func startNotificationDispatcher(interval time.Duration) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	// Rows left in 'sending' by a crash are retried.
+	_, _ = db.DB.Exec("UPDATE notifications_queue SET status = 'pending' WHERE status = 'sending'")
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			dispatchDueNotifications(time.Now())
+			select {
+			case <-t.C:
+			case <-notifyWake:
+			}
+		}
+	}()
+}
+
+// wakeNotificationDispatcher asks the dispatcher to poll now. Never blocks.
+func wakeNotificationDispatcher() {
+	select {
+	case notifyWake <- struct{}{}:
+	default:
+	}
+}
+
+// enqueueNotification inserts a pending notification due now and wakes the dispatcher. data is optional JSON.
+func enqueueNotification(userID int64, ntype, channel, title, body, data string) error {
+	_, err := db.DB.Exec(
+		"INSERT INTO notifications_queue (user_id, type, channel, title, body, data, status, scheduled_for) VALUES (?, ?, ?, ?, ?, ?, 'pending', ?)",
+		userID, ntype, channel, title, body, nullStr(data), time.Now().Unix(),
+	)
+	if err == nil {
+		wakeNotificationDispatcher()
+	}
+	return err
+}
+
+// dispatchDueNotifications delivers up to notifyBatchSize due rows. Returns the number of rows processed.
+func dispatchDueNotifications(now time.Time) int {
+	rows, err := db.DB.Query(
+		`SELECT id, user_id, type, channel, COALESCE(title, ''), body, data, attempts, max_attempts
+		 FROM notifications_queue WHERE status = 'pending' AND COALESCE(scheduled_for, 0) <= ?
+		 ORDER BY scheduled_for, id LIMIT ?`,
+		now.Unix(), notifyBatchSize,
+	)
+	if err != nil {
+		log.Printf("Notifications: poll failed: %v", err)
+		return 0
+	}
+	var due []queuedNotification
+	for rows.Next() {
+		var n queuedNotification
+		if rows.Scan(&n.ID, &n.UserID, &n.Type, &n.Channel, &n.Title, &n.Body, &n.Data, &n.Attempts, &n.MaxAttempts) == nil {
+			due = append(due, n)
+		}
+	}
+	rows.Close()
+	processed := 0
+	for _, n := range due {
+		// Claim the row so a concurrent dispatcher does not deliver it twice.
+		res, err := db.DB.Exec("UPDATE notifications_queue SET status = 'sending' WHERE id = ? AND status = 'pending'", n.ID)
+		if err != nil || mustRows(res) == 0 {
+			continue
+		}
+		deliverNotification(n, now)
+		processed++
+	}
+	return processed
+}
+
+func deliverNotification(n queuedNotification, now time.Time) {
+	if !notificationChannelEnabled(n.UserID, n.Channel) {
+		db.DB.Exec("UPDATE notifications_queue SET status = 'skipped', last_error = ? WHERE id = ?", "disabled in user settings", n.ID)
+		return
+	}
+	send, ok := notificationSenders[n.Channel]
+	err := errNotifyUnknownChannel
+	if ok {
+		err = send(n)
+	}
+	attempts := n.Attempts + 1
+	switch {
+	case err == nil:
+		db.DB.Exec("UPDATE notifications_queue SET status = 'sent', attempts = ?, sent_at = ?, last_error = NULL WHERE id = ?", attempts, now.Unix(), n.ID)
+	case errors.Is(err, errNotifyNoRecipient), errors.Is(err, errNotifyUnknownChannel), errors.Is(err, errNotifyNoPushProvider),
+		attempts >= n.MaxAttempts:
+		db.DB.Exec("UPDATE notifications_queue SET status = 'failed', attempts = ?, last_error = ? WHERE id = ?", attempts, err.Error(), n.ID)
+	default:
+		next := now.Add(notificationBackoff(attempts)).Unix()
+		db.DB.Exec("UPDATE notifications_queue SET status = 'pending', attempts = ?, scheduled_for = ?, last_error = ? WHERE id = ?", attempts, next, err.Error(), n.ID)
+	}
+}
+
+// notificationBackoff returns the delay before retry number attempts (1-based): 30s, 60s, 120s, ... capped at 1h.
+func notificationBackoff(attempts int) time.Duration {
+	d := notifyBaseBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= notifyMaxBackoff {
+			return notifyMaxBackoff
+		}
+	}
+	return d
+}
+
+// notificationChannelEnabled applies notifications_user_settings (no row = everything enabled). WebSocket/in-app are always on.
+func notificationChannelEnabled(userID int64, channel string) bool {
+	var emailEnabled, pushEnabled int
+	err := db.DB.QueryRow(
+		"SELECT COALESCE(email_enabled, 1), COALESCE(push_enabled, 1) FROM notifications_user_settings WHERE user_id = ?",
+		userID,
+	).Scan(&emailEnabled, &pushEnabled)
+	if err != nil {
+		return true
+	}
+	switch channel {
+	case "email":
+		return emailEnabled == 1
+	case "push":
+		return pushEnabled == 1
+	}
+	return true
+}
+
+func sendNotificationWS(n queuedNotification) error {
+	payload := gin.H{"id": n.ID, "type": n.Type, "title": n.Title, "body": n.Body}
+	if n.Data.Valid && json.Valid([]byte(n.Data.String)) {
+		payload["data"] = json.RawMessage(n.Data.String)
+	}
+	BroadcastToUser(n.UserID, "notification", payload)
+	return nil
+}
+
+func sendNotificationEmail(n queuedNotification) error {
+	var email string
+	if db.DB.QueryRow("SELECT email FROM users WHERE id = ?", n.UserID).Scan(&email) != nil || email == "" {
+		return errNotifyNoRecipient
+	}
+	return sendTemplateMail(email, mail.TemplateNotification, map[string]any{
+		"Title":        n.Title,
+		"Body":         n.Body,
+		"Link":         appLink("/app/dashboard.html", nil),
+		"SettingsLink": appLink("/app/settings.html", nil),
+	})
+}
+
+// sendNotificationPush sends to every push token of the user. It succeeds if at least one token accepted the
+// message; tokens the provider rejects for good are deleted, and last_used is bumped only on delivery.
+func sendNotificationPush(n queuedNotification) error {
+	type target struct {
+		id              int64
+		token, platform string
+	}
+	rows, err := db.DB.Query("SELECT id, token, platform FROM notifications_push_tokens WHERE user_id = ?", n.UserID)
+	if err != nil {
+		return err
+	}
+	var targets []target
+	for rows.Next() {
+		var t target
+		if rows.Scan(&t.id, &t.token, &t.platform) == nil {
+			targets = append(targets, t)
+		}
+	}
+	rows.Close()
+	if len(targets) == 0 {
+		return errNotifyNoRecipient
+	}
+	if pushSender == nil {
+		return errNotifyNoPushProvider
+	}
+	var lastErr error
+	delivered, invalid := 0, 0
+	for _, t := range targets {
+		err := pushSender.Send(push.Message{Token: t.token, Platform: t.platform, Title: n.Title, Body: n.Body, Data: n.Data.String})
+		switch {
+		case err == nil:
+			delivered++
+			db.DB.Exec("UPDATE notifications_push_tokens SET last_used = unixepoch() WHERE id = ?", t.id)
+		case errors.Is(err, push.ErrTokenInvalid):
+			invalid++
+			db.DB.Exec("DELETE FROM notifications_push_tokens WHERE id = ?", t.id)
+		default:
+			lastErr = err
+		}
+	}
+	if delivered > 0 {
+		return nil
+	}
+	if invalid == len(targets) {
+		return errNotifyNoRecipient
+	}
+	return lastErr
+}
@@ -0,0 +1,330 @@
+// OAuth 2.1 handlers: the consent screen (/oauth/authorize, next to /login), the token and revocation
+// endpoints, app registration and grant management for users and admins.
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const oauthConsentPageHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <title>Authorize {{.Client}} — OMNIXIUS</title>
+  <style>
+    body { font-family: system-ui, sans-serif; max-width: 420px; margin: 2rem auto; padding: 1rem; background: #0c0c0f; color: #e8e6e3; }
+    h1 { font-size: 1.5rem; margin-bottom: 0.5rem; }
+    p.sub { color: #8a8a8a; font-size: 0.95rem; margin-bottom: 1rem; }
+    ul { margin: 0 0 1.5rem; padding-left: 1.2rem; }
+    li { margin-bottom: 0.35rem; }
+    label { display: block; margin-bottom: 0.25rem; color: #8a8a8a; }
+    input { width: 100%; padding: 0.6rem; margin-bottom: 1rem; background: #14141a; border: 1px solid #2a2a32; border-radius: 6px; color: #e8e6e3; box-sizing: border-box; }
+    .err { color: #e74c3c; font-size: 0.9rem; margin-bottom: 0.5rem; }
+    button { width: 100%; padding: 0.75rem; background: #00d4aa; color: #0c0c0f; border: none; border-radius: 8px; font-weight: 600; cursor: pointer; font-size: 1rem; margin-bottom: 0.5rem; }
+    button.deny { background: #2a2a32; color: #e8e6e3; }
+  </style>
+</head>
+<body>
+  <h1>Authorize {{.Client}}</h1>
+  <p class="sub">{{.Client}} ({{.Host}}) wants to access your OMNIXIUS account. It will be able to:</p>
+  <ul>{{.Scopes}}</ul>
+  {{.Error}}
+  <form method="POST" action="/oauth/authorize">
+    {{.Hidden}}
+    <label>Email</label>
+    <input type="email" name="email" value="{{.Email}}" autocomplete="email">
+    <label>Password</label>
+    <input type="password" name="password" autocomplete="current-password">
+    <label>Two-factor code (if enabled)</label>
+    <input type="text" name="code" inputmode="numeric" autocomplete="one-time-code">
+    <button type="submit" name="decision" value="approve">Sign in and allow</button>
+    <button type="submit" name="decision" value="deny" class="deny" formnovalidate>Deny</button>
+  </form>
+</body>
+</html>`
+
+var oauthAuthorizeParams = []string{"response_type", "client_id", "redirect_uri", "scope", "state", "code_challenge", "code_challenge_method"}
+
+func serveOAuthConsent(c *gin.Context, status int, req *AuthorizeRequest, params url.Values, errMsg, email string) {
+	var scopes, hidden strings.Builder
+	for _, s := range req.Scopes {
+		scopes.WriteString("<li>" + templateHTMLEscape(scopeDescriptions[s]) + " <code>" + templateHTMLEscape(s) + "</code></li>")
+	}
+	for _, k := range oauthAuthorizeParams {
+		if v := params.Get(k); v != "" {
+			hidden.WriteString(`<input type="hidden" name="` + k + `" value="` + templateHTMLEscape(v) + `">`)
+		}
+	}
+	errBlock := ""
+	if errMsg != "" {
+		errBlock = "<p class=\"err\">" + templateHTMLEscape(errMsg) + "</p>"
+	}
+	host := req.RedirectURI
+	if u, err := url.Parse(req.RedirectURI); err == nil {
+		host = u.Host
+	}
+	html := strings.NewReplacer(
+		"{{.Client}}", templateHTMLEscape(req.Client.Name),
+		"{{.Host}}", templateHTMLEscape(host),
+		"{{.Scopes}}", scopes.String(),
+		"{{.Hidden}}", hidden.String(),
+		"{{.Error}}", errBlock,
+		"{{.Email}}", templateHTMLEscape(email),
+	).Replace(oauthConsentPageHTML)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Cache-Control", "no-store")
+	c.String(status, html)
+}
+
+func serveOAuthErrorPage(c *gin.Context, err error) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.String(http.StatusBadRequest, `<html><body><h1>Authorization failed</h1><p>`+templateHTMLEscape(err.Error())+`</p></body></html>`)
+}
+
+// oauthRedirect sends the browser back to the client with query parameters (code and state, or error).
+func oauthRedirect(c *gin.Context, redirectURI string, params url.Values) {
+	u, _ := url.Parse(redirectURI)
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	c.Redirect(http.StatusFound, u.String())
+}
+
+// handleOAuthAuthorize serves the consent screen (GET) and handles its form (POST).
+func handleOAuthAuthorize(c *gin.Context) {
+	params := c.Request.URL.Query()
+	if c.Request.Method == http.MethodPost {
+		c.Request.ParseForm()
+		params = c.Request.PostForm
+	}
+	req, redirectable, err := ParseAuthorizeRequest(params)
+	if err != nil {
+		var oe *OAuthError
+		if !redirectable || !errors.As(err, &oe) {
+			serveOAuthErrorPage(c, err)
+			return
+		}
+		oauthRedirect(c, req.RedirectURI, url.Values{"error": {oe.Code}, "error_description": {oe.Description}, "state": {req.State}})
+		return
+	}
+	if c.Request.Method != http.MethodPost {
+		serveOAuthConsent(c, http.StatusOK, req, params, "", "")
+		return
+	}
+	if params.Get("decision") != "approve" {
+		oauthRedirect(c, req.RedirectURI, url.Values{"error": {"access_denied"}, "state": {req.State}})
+		return
+	}
+	email := strings.TrimSpace(params.Get("email"))
+	if !allowLogin(c) {
+		serveOAuthConsent(c, http.StatusTooManyRequests, req, params, "Too many attempts. Try again later.", email)
+		return
+	}
+	userID, err := authenticatePassword(email, params.Get("password"))
+	if err != nil {
+		msg := "Invalid email or password"
+		if errors.Is(err, ErrUserBanned) {
+			msg = "Account suspended"
+		} else if errors.Is(err, ErrPasswordResetRequired) {
+			msg = "Password reset required; use the link sent to your email"
+		} else if !errors.Is(err, ErrInvalidCredentials) {
+			msg = "Authorization failed. Try again."
+		}
+		serveOAuthConsent(c, http.StatusUnauthorized, req, params, msg, email)
+		return
+	}
+	if mfaEnabled(userID) {
+		code := strings.TrimSpace(params.Get("code"))
+		if code == "" {
+			serveOAuthConsent(c, http.StatusUnauthorized, req, params, "Enter the code from your authenticator app", email)
+			return
+		}
+		if err := MFAVerify(userID, code); err != nil {
+			serveOAuthConsent(c, http.StatusUnauthorized, req, params, "Invalid two-factor code", email)
+			return
+		}
+	}
+	code, err := OAuthApprove(userID, req)
+	if err != nil {
+		serveOAuthConsent(c, http.StatusInternalServerError, req, params, "Authorization failed. Try again.", email)
+		return
+	}
+	oauthRedirect(c, req.RedirectURI, url.Values{"code": {code}, "state": {req.State}})
+}
+
+// oauthClientFromRequest authenticates the client by HTTP Basic or client_id/client_secret form fields.
+func oauthClientFromRequest(c *gin.Context) (*OAuthClient, error) {
+	id, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		id, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	return AuthenticateOAuthClient(id, secret)
+}
+
+func oauthErrorJSON(c *gin.Context, err error) {
+	var oe *OAuthError
+	if errors.As(err, &oe) {
+		c.JSON(oe.Status, oe.JSON())
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+}
+
+// handleOAuthToken is the token endpoint: grant_type authorization_code (with code_verifier) or refresh_token.
+func handleOAuthToken(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	cl, err := oauthClientFromRequest(c)
+	if err != nil {
+		oauthErrorJSON(c, err)
+		return
+	}
+	var tokens OAuthTokens
+	switch c.PostForm("grant_type") {
+	case "authorization_code":
+		if c.PostForm("code") == "" || c.PostForm("code_verifier") == "" {
+			oauthErrorJSON(c, oauthErr("invalid_request", "code and code_verifier required", 400))
+			return
+		}
+		tokens, err = OAuthExchangeCode(cl, c.PostForm("code"), c.PostForm("redirect_uri"), c.PostForm("code_verifier"))
+	case "refresh_token":
+		tokens, err = OAuthRefresh(cl, c.PostForm("refresh_token"))
+	default:
+		err = oauthErr("unsupported_grant_type", "grant_type must be authorization_code or refresh_token", 400)
+	}
+	if err != nil {
+		oauthErrorJSON(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// handleOAuthRevoke is the RFC 7009 revocation endpoint; it answers 200 for unknown tokens too.
+func handleOAuthRevoke(c *gin.Context) {
+	cl, err := oauthClientFromRequest(c)
+	if err != nil {
+		oauthErrorJSON(c, err)
+		return
+	}
+	OAuthRevoke(cl, c.PostForm("token"))
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func handleOAuthClientsList(c *gin.Context) {
+	list, err := ListOAuthClients(getUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list apps"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"clients": list})
+}
+
+func handleOAuthClientCreate(c *gin.Context) {
+	var body struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Confidential *bool    `json:"confidential"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": ErrOAuthClientInvalid.Error()})
+		return
+	}
+	confidential := body.Confidential == nil || *body.Confidential
+	client, err := RegisterOAuthClient(getUserID(c), body.Name, body.RedirectURIs, body.Scopes, confidential)
+	if err != nil {
+		if errors.Is(err, ErrOAuthClientInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register app"})
+		return
+	}
+	c.JSON(http.StatusCreated, client)
+}
+
+func handleOAuthClientDelete(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if err := DeleteOAuthClient(getUserID(c), id); err != nil {
+		if errors.Is(err, ErrOAuthClientNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete app"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// handleOAuthGrantsList lists the apps the user has authorized.
+func handleOAuthGrantsList(c *gin.Context) {
+	list, err := ListOAuthGrants(getUserID(c), 0, true, 200)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list grants"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"grants": list})
+}
+
+func handleOAuthGrantRevoke(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if err := RevokeUserGrant(getUserID(c), id); err != nil {
+		if errors.Is(err, ErrOAuthGrantNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// handleAdminOAuthGrants lists grants across users: ?user_id=, ?client_id= (oauth_clients.id), ?active=1.
+func handleAdminOAuthGrants(c *gin.Context) {
+	userID, _ := strconv.ParseInt(c.Query("user_id"), 10, 64)
+	clientID, _ := strconv.ParseInt(c.Query("client_id"), 10, 64)
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit < 1 || limit > 500 {
+		limit = 100
+	}
+	list, err := ListOAuthGrants(userID, clientID, c.Query("active") == "1", limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list grants"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"grants": list})
+}
+
+func handleAdminOAuthGrantRevoke(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if err := AdminRevokeGrant(getUserID(c), id); err != nil {
+		if errors.Is(err, ErrOAuthGrantNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
@@ -0,0 +1,555 @@
+// OAuth 2.1 authorization server: partner apps obtain scoped access tokens for a user through the
+// authorization-code flow with PKCE (S256). Access tokens are PQC-signed like first-party tokens and carry the
+// grant id (gid) and scopes (scp); revoking the grant or the client stops them at once.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"omnixius-api/db"
+	"omnixius-api/pqc"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	oauthCodeTTL         = 5 * time.Minute
+	oauthMaxRedirectURIs = 10
+	maxOAuthClientName   = 100
+)
+
+// scopeDescriptions are shown on the consent screen.
+var scopeDescriptions = map[string]string{
+	"profile:read":        "See your name, email and avatar",
+	"orders:read":         "See your orders and subscriptions",
+	"orders:write":        "Create, update and pay orders",
+	"products:read":       "See products, including closed content you bought",
+	"products:write":      "Create and edit your products and slots",
+	"wallet:read":         "See your balances and transactions",
+	"wallet:write":        "Transfer, hold and send money from your wallet",
+	"messages:read":       "Read your conversations",
+	"messages:write":      "Send messages for you",
+	"notifications:read":  "See your notifications",
+	"notifications:write": "Change notification settings",
+	"vault:read":          "Read files in your vault",
+	"vault:write":         "Upload and delete files in your vault",
+}
+
+// OAuthError is an RFC 6749 error: Code goes into "error" (or the redirect), Status is used for JSON responses.
+type OAuthError struct {
+	Code        string
+	Description string
+	Status      int
+}
+
+func (e *OAuthError) Error() string { return e.Code + ": " + e.Description }
+
+// JSON returns the RFC 6749 error body.
+func (e *OAuthError) JSON() gin.H {
+	return gin.H{"error": e.Code, "error_description": e.Description}
+}
+
+func oauthErr(code, description string, status int) *OAuthError {
+	return &OAuthError{Code: code, Description: description, Status: status}
+}
+
+var (
+	ErrOAuthClientNotFound = errors.New("client not found")
+	ErrOAuthGrantNotFound  = errors.New("grant not found")
+	ErrOAuthClientInvalid  = errors.New("name, redirect_uris and scopes required; redirect URIs must be https (or http on localhost) without fragments")
+
+	errOAuthInvalidClient = oauthErr("invalid_client", "client authentication failed", 401)
+	errOAuthInvalidGrant  = oauthErr("invalid_grant", "code or refresh token is invalid, expired or already used", 400)
+)
+
+// OAuthClient is a registered partner app. Confidential clients have a secret; public clients rely on PKCE alone.
+type OAuthClient struct {
+	ID           int64
+	ClientID     string
+	Name         string
+	SecretHash   string
+	RedirectURIs []string
+	Scopes       []string
+}
+
+// OAuthTokens is the token endpoint response.
+type OAuthTokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// validRedirectURI allows absolute https URIs, and http only for loopback hosts (native apps, development).
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Fragment != "" || u.Host == "" || u.User != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		h := u.Hostname()
+		return h == "localhost" || h == "127.0.0.1" || h == "::1"
+	}
+	return false
+}
+
+// RegisterOAuthClient registers an app owned by ownerID. The secret (confidential clients) is returned only here.
+func RegisterOAuthClient(ownerID int64, name string, redirectURIs, scopes []string, confidential bool) (gin.H, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > maxOAuthClientName || len(redirectURIs) == 0 || len(redirectURIs) > oauthMaxRedirectURIs {
+		return nil, ErrOAuthClientInvalid
+	}
+	for _, u := range redirectURIs {
+		if !validRedirectURI(u) {
+			return nil, ErrOAuthClientInvalid
+		}
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return nil, ErrOAuthClientInvalid
+	}
+	clientID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	clientID = "omx_app_" + clientID
+	var secret, secretHash string
+	if confidential {
+		if secret, err = randomToken(32); err != nil {
+			return nil, err
+		}
+		secretHash = sha256Hex(secret)
+	}
+	res, err := db.DB.Exec(
+		"INSERT INTO oauth_clients (client_id, client_secret_hash, owner_user_id, name, redirect_uris, scopes) VALUES (?, ?, ?, ?, ?, ?)",
+		clientID, nullStr(secretHash), ownerID, name, strings.Join(redirectURIs, "\n"), strings.Join(scopes, " "),
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	auditLog(ownerID, "oauth.client_registered", "oauth_client", strconv.FormatInt(id, 10), clientID)
+	out := gin.H{"id": id, "client_id": clientID, "name": name, "redirect_uris": redirectURIs, "scopes": scopes, "confidential": confidential}
+	if confidential {
+		out["client_secret"] = secret
+	}
+	return out, nil
+}
+
+// ListOAuthClients returns the apps registered by ownerID.
+func ListOAuthClients(ownerID int64) ([]gin.H, error) {
+	rows, err := db.DB.Query(
+		`SELECT c.id, c.client_id, c.name, c.redirect_uris, c.scopes, c.client_secret_hash IS NOT NULL, c.created_at,
+		 (SELECT COUNT(*) FROM oauth_grants g WHERE g.client_id = c.id AND g.revoked_at IS NULL)
+		 FROM oauth_clients c WHERE c.owner_user_id = ? AND c.revoked_at IS NULL ORDER BY c.created_at DESC, c.id DESC`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []gin.H{}
+	for rows.Next() {
+		var id, createdAt, users int64
+		var clientID, name, uris, scopes string
+		var confidential bool
+		if rows.Scan(&id, &clientID, &name, &uris, &scopes, &confidential, &createdAt, &users) != nil {
+			continue
+		}
+		list = append(list, gin.H{
+			"id": id, "client_id": clientID, "name": name, "redirect_uris": strings.Split(uris, "\n"),
+			"scopes": strings.Fields(scopes), "confidential": confidential, "created_at": createdAt, "active_grants": users,
+		})
+	}
+	return list, nil
+}
+
+// DeleteOAuthClient retires an app and revokes every grant made to it.
+func DeleteOAuthClient(ownerID, id int64) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	res, err := tx.Exec("UPDATE oauth_clients SET revoked_at = ? WHERE id = ? AND owner_user_id = ? AND revoked_at IS NULL", now, id, ownerID)
+	if err != nil {
+		return err
+	}
+	if mustRows(res) == 0 {
+		return ErrOAuthClientNotFound
+	}
+	rows, err := tx.Query("SELECT id FROM oauth_grants WHERE client_id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	var grants []int64
+	for rows.Next() {
+		var g int64
+		if rows.Scan(&g) == nil {
+			grants = append(grants, g)
+		}
+	}
+	rows.Close()
+	for _, g := range grants {
+		if err := revokeGrantTx(tx, g, ownerID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	auditLog(ownerID, "oauth.client_deleted", "oauth_client", strconv.FormatInt(id, 10), "")
+	return nil
+}
+
+func loadOAuthClient(clientID string) (*OAuthClient, error) {
+	var cl OAuthClient
+	var secret sql.NullString
+	var uris, scopes string
+	err := db.DB.QueryRow(
+		"SELECT id, client_id, name, client_secret_hash, redirect_uris, scopes FROM oauth_clients WHERE client_id = ? AND revoked_at IS NULL",
+		clientID,
+	).Scan(&cl.ID, &cl.ClientID, &cl.Name, &secret, &uris, &scopes)
+	if err != nil {
+		return nil, ErrOAuthClientNotFound
+	}
+	cl.SecretHash = secret.String
+	cl.RedirectURIs = strings.Split(uris, "\n")
+	cl.Scopes = strings.Fields(scopes)
+	return &cl, nil
+}
+
+// AuthenticateOAuthClient checks client credentials at the token and revocation endpoints. Public clients
+// send only client_id.
+func AuthenticateOAuthClient(clientID, secret string) (*OAuthClient, error) {
+	cl, err := loadOAuthClient(clientID)
+	if err != nil {
+		return nil, errOAuthInvalidClient
+	}
+	if cl.SecretHash != "" && subtle.ConstantTimeCompare([]byte(sha256Hex(secret)), []byte(cl.SecretHash)) != 1 {
+		return nil, errOAuthInvalidClient
+	}
+	return cl, nil
+}
+
+// AuthorizeRequest is a validated /oauth/authorize request.
+type AuthorizeRequest struct {
+	Client        *OAuthClient
+	RedirectURI   string
+	State         string
+	Scopes        []string
+	CodeChallenge string
+}
+
+// ParseAuthorizeRequest validates authorization request parameters. When the client or redirect URI is
+// invalid, redirectable is false and the error must be shown to the user instead of sent to the redirect URI.
+func ParseAuthorizeRequest(q url.Values) (req *AuthorizeRequest, redirectable bool, err error) {
+	cl, err := loadOAuthClient(q.Get("client_id"))
+	if err != nil {
+		return nil, false, oauthErr("invalid_client", "unknown client_id", 400)
+	}
+	redirectURI := q.Get("redirect_uri")
+	if redirectURI == "" && len(cl.RedirectURIs) == 1 {
+		redirectURI = cl.RedirectURIs[0]
+	}
+	if !slices.Contains(cl.RedirectURIs, redirectURI) {
+		return nil, false, oauthErr("invalid_request", "redirect_uri is not registered for this client", 400)
+	}
+	req = &AuthorizeRequest{Client: cl, RedirectURI: redirectURI, State: q.Get("state")}
+	if q.Get("response_type") != "code" {
+		return req, true, oauthErr("unsupported_response_type", "response_type must be code", 400)
+	}
+	req.CodeChallenge = q.Get("code_challenge")
+	if q.Get("code_challenge_method") != "S256" || len(req.CodeChallenge) < 43 || len(req.CodeChallenge) > 128 {
+		return req, true, oauthErr("invalid_request", "PKCE with code_challenge_method=S256 is required", 400)
+	}
+	scopes, err := normalizeScopes(strings.Fields(q.Get("scope")))
+	if err != nil {
+		return req, true, oauthErr("invalid_scope", "unknown or missing scope", 400)
+	}
+	for _, s := range scopes {
+		if !slices.Contains(cl.Scopes, s) {
+			return req, true, oauthErr("invalid_scope", "scope "+s+" is not allowed for this client", 400)
+		}
+	}
+	req.Scopes = scopes
+	return req, true, nil
+}
+
+// OAuthApprove records the user's consent and returns a single-use authorization code. An active grant to the
+// same client is updated with the new scopes; after a revocation a new grant is created.
+func OAuthApprove(userID int64, req *AuthorizeRequest) (string, error) {
+	if err := checkLoginAllowed(userID); err != nil {
+		return "", err
+	}
+	code, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	scopes := strings.Join(req.Scopes, " ")
+	var grantID int64
+	if tx.QueryRow("SELECT id FROM oauth_grants WHERE user_id = ? AND client_id = ? AND revoked_at IS NULL", userID, req.Client.ID).Scan(&grantID) == nil {
+		if _, err := tx.Exec("UPDATE oauth_grants SET scopes = ?, updated_at = ? WHERE id = ?", scopes, now.Unix(), grantID); err != nil {
+			return "", err
+		}
+	} else {
+		res, err := tx.Exec("INSERT INTO oauth_grants (user_id, client_id, scopes, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+			userID, req.Client.ID, scopes, now.Unix(), now.Unix())
+		if err != nil {
+			return "", err
+		}
+		grantID, _ = res.LastInsertId()
+	}
+	tx.Exec("DELETE FROM oauth_codes WHERE expires_at <= ?", now.Unix())
+	if _, err := tx.Exec(
+		"INSERT INTO oauth_codes (code_hash, grant_id, redirect_uri, code_challenge, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		sha256Hex(code), grantID, req.RedirectURI, req.CodeChallenge, scopes, now.Add(oauthCodeTTL).Unix(),
+	); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	auditLog(userID, "oauth.consent", "oauth_grant", strconv.FormatInt(grantID, 10), req.Client.ClientID+" "+scopes)
+	return code, nil
+}
+
+// pkceS256 returns the code challenge for a verifier (RFC 7636).
+func pkceS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// OAuthExchangeCode redeems an authorization code. A code presented twice revokes its grant.
+func OAuthExchangeCode(cl *OAuthClient, code, redirectURI, verifier string) (OAuthTokens, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return OAuthTokens{}, err
+	}
+	defer tx.Rollback()
+	var grantID, expiresAt int64
+	var storedURI, challenge, scopes string
+	var usedAt sql.NullInt64
+	err = tx.QueryRow(
+		`SELECT oc.grant_id, oc.redirect_uri, oc.code_challenge, oc.scopes, oc.expires_at, oc.used_at
+		 FROM oauth_codes oc JOIN oauth_grants g ON g.id = oc.grant_id
+		 WHERE oc.code_hash = ? AND g.client_id = ? AND g.revoked_at IS NULL`, sha256Hex(code), cl.ID,
+	).Scan(&grantID, &storedURI, &challenge, &scopes, &expiresAt, &usedAt)
+	if err != nil {
+		return OAuthTokens{}, errOAuthInvalidGrant
+	}
+	if usedAt.Valid {
+		revokeGrantTx(tx, grantID, 0)
+		tx.Commit()
+		return OAuthTokens{}, errOAuthInvalidGrant
+	}
+	if expiresAt <= time.Now().Unix() || storedURI != redirectURI ||
+		subtle.ConstantTimeCompare([]byte(pkceS256(verifier)), []byte(challenge)) != 1 {
+		return OAuthTokens{}, errOAuthInvalidGrant
+	}
+	if _, err := tx.Exec("UPDATE oauth_codes SET used_at = ? WHERE code_hash = ?", time.Now().Unix(), sha256Hex(code)); err != nil {
+		return OAuthTokens{}, err
+	}
+	return issueOAuthTokensTx(tx, grantID, strings.Fields(scopes))
+}
+
+// OAuthRefresh rotates a refresh token. Presenting a used refresh token revokes the whole grant.
+func OAuthRefresh(cl *OAuthClient, refresh string) (OAuthTokens, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return OAuthTokens{}, err
+	}
+	defer tx.Rollback()
+	var grantID, expiresAt int64
+	var scopes string
+	var usedAt sql.NullInt64
+	err = tx.QueryRow(
+		`SELECT rt.grant_id, rt.scopes, rt.expires_at, rt.used_at FROM oauth_refresh_tokens rt
+		 JOIN oauth_grants g ON g.id = rt.grant_id WHERE rt.token_hash = ? AND g.client_id = ? AND g.revoked_at IS NULL`,
+		sha256Hex(refresh), cl.ID,
+	).Scan(&grantID, &scopes, &expiresAt, &usedAt)
+	if err != nil {
+		return OAuthTokens{}, errOAuthInvalidGrant
+	}
+	if usedAt.Valid {
+		revokeGrantTx(tx, grantID, 0)
+		tx.Commit()
+		return OAuthTokens{}, errOAuthInvalidGrant
+	}
+	if expiresAt <= time.Now().Unix() {
+		return OAuthTokens{}, errOAuthInvalidGrant
+	}
+	if _, err := tx.Exec("UPDATE oauth_refresh_tokens SET used_at = ? WHERE token_hash = ?", time.Now().Unix(), sha256Hex(refresh)); err != nil {
+		return OAuthTokens{}, err
+	}
+	return issueOAuthTokensTx(tx, grantID, strings.Fields(scopes))
+}
+
+// issueOAuthTokensTx stores a new refresh token, commits tx and signs the access token.
+func issueOAuthTokensTx(tx *sql.Tx, grantID int64, scopes []string) (OAuthTokens, error) {
+	var userID int64
+	if err := tx.QueryRow("SELECT user_id FROM oauth_grants WHERE id = ?", grantID).Scan(&userID); err != nil {
+		return OAuthTokens{}, err
+	}
+	if checkBan(userID) != nil {
+		return OAuthTokens{}, errOAuthInvalidGrant
+	}
+	refresh, err := randomToken(32)
+	if err != nil {
+		return OAuthTokens{}, err
+	}
+	now := time.Now()
+	if _, err := tx.Exec(
+		"INSERT INTO oauth_refresh_tokens (token_hash, grant_id, scopes, expires_at, created_at) VALUES (?, ?, ?, ?, ?)",
+		sha256Hex(refresh), grantID, strings.Join(scopes, " "), now.Add(cfg.RefreshTokenTTL).Unix(), now.Unix(),
+	); err != nil {
+		return OAuthTokens{}, err
+	}
+	if _, err := tx.Exec("UPDATE oauth_grants SET last_used_at = ? WHERE id = ?", now.Unix(), grantID); err != nil {
+		return OAuthTokens{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return OAuthTokens{}, err
+	}
+	access, err := signAccessToken(pqc.Claims{
+		UserID: userID, GrantID: grantID, Scopes: scopes, ExpiresAt: now.Add(cfg.AccessTokenTTL).Unix(),
+	})
+	if err != nil {
+		return OAuthTokens{}, err
+	}
+	return OAuthTokens{
+		AccessToken: access, TokenType: "Bearer", ExpiresIn: int64(cfg.AccessTokenTTL / time.Second),
+		RefreshToken: refresh, Scope: strings.Join(scopes, " "),
+	}, nil
+}
+
+// OAuthRevoke implements RFC 7009 for the client's refresh and access tokens: either revokes the grant.
+// Unknown tokens are ignored.
+func OAuthRevoke(cl *OAuthClient, token string) {
+	var grantID int64
+	err := db.DB.QueryRow(
+		`SELECT rt.grant_id FROM oauth_refresh_tokens rt JOIN oauth_grants g ON g.id = rt.grant_id
+		 WHERE rt.token_hash = ? AND g.client_id = ?`, sha256Hex(token), cl.ID).Scan(&grantID)
+	if err != nil {
+		claims, verr := verifyAccessToken(token)
+		if verr != nil || claims.GrantID == 0 ||
+			db.DB.QueryRow("SELECT id FROM oauth_grants WHERE id = ? AND client_id = ?", claims.GrantID, cl.ID).Scan(&grantID) != nil {
+			return
+		}
+	}
+	revokeGrant(grantID, 0, 0)
+}
+
+// oauthGrantActive reports whether an access token's grant (and its client) is still in force.
+func oauthGrantActive(grantID, userID int64) bool {
+	var n int
+	return db.DB.QueryRow(
+		`SELECT 1 FROM oauth_grants g JOIN oauth_clients c ON c.id = g.client_id
+		 WHERE g.id = ? AND g.user_id = ? AND g.revoked_at IS NULL AND c.revoked_at IS NULL`, grantID, userID,
+	).Scan(&n) == nil
+}
+
+// revokeGrantTx marks a grant revoked and deletes its codes and refresh tokens. by is the acting user (0 = system).
+func revokeGrantTx(tx *sql.Tx, grantID, by int64) error {
+	if _, err := tx.Exec("UPDATE oauth_grants SET revoked_at = ?, revoked_by = ? WHERE id = ? AND revoked_at IS NULL",
+		time.Now().Unix(), nullInt64(by), grantID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM oauth_codes WHERE grant_id = ?", grantID); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM oauth_refresh_tokens WHERE grant_id = ?", grantID)
+	return err
+}
+
+// revokeGrant revokes an active grant. userID limits it to that user's grants (0 = any, for admins).
+func revokeGrant(grantID, userID, by int64) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var owner int64
+	if tx.QueryRow("SELECT user_id FROM oauth_grants WHERE id = ? AND revoked_at IS NULL AND (? = 0 OR user_id = ?)",
+		grantID, userID, userID).Scan(&owner) != nil {
+		return ErrOAuthGrantNotFound
+	}
+	if err := revokeGrantTx(tx, grantID, by); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	auditLog(owner, "oauth.grant_revoked", "oauth_grant", strconv.FormatInt(grantID, 10), "by="+strconv.FormatInt(by, 10))
+	return nil
+}
+
+// RevokeUserGrant lets a user disconnect an app.
+func RevokeUserGrant(userID, grantID int64) error { return revokeGrant(grantID, userID, userID) }
+
+// AdminRevokeGrant revokes any user's grant.
+func AdminRevokeGrant(adminID, grantID int64) error { return revokeGrant(grantID, 0, adminID) }
+
+// ListOAuthGrants lists grants, newest first. userID and clientID filter when non-zero; activeOnly hides revoked ones.
+func ListOAuthGrants(userID, clientID int64, activeOnly bool, limit int) ([]gin.H, error) {
+	rows, err := db.DB.Query(
+		`SELECT g.id, g.user_id, u.email, c.id, c.client_id, c.name, g.scopes, g.created_at, g.updated_at, g.last_used_at, g.revoked_at
+		 FROM oauth_grants g JOIN oauth_clients c ON c.id = g.client_id JOIN users u ON u.id = g.user_id
+		 WHERE (? = 0 OR g.user_id = ?) AND (? = 0 OR g.client_id = ?) AND (? = 0 OR g.revoked_at IS NULL)
+		 ORDER BY g.updated_at DESC, g.id DESC LIMIT ?`,
+		userID, userID, clientID, clientID, activeOnly, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []gin.H{}
+	for rows.Next() {
+		var id, uid, cid, createdAt, updatedAt int64
+		var email, clientKey, clientName, scopes string
+		var lastUsed, revokedAt sql.NullInt64
+		if rows.Scan(&id, &uid, &email, &cid, &clientKey, &clientName, &scopes, &createdAt, &updatedAt, &lastUsed, &revokedAt) != nil {
+			continue
+		}
+		g := gin.H{
+			"id": id, "user_id": uid, "user_email": email,
+			"client": gin.H{"id": cid, "client_id": clientKey, "name": clientName},
+			"scopes": strings.Fields(scopes), "created_at": createdAt, "updated_at": updatedAt,
+			"last_used_at": nil, "revoked_at": nil,
+		}
+		if lastUsed.Valid {
+			g["last_used_at"] = lastUsed.Int64
+		}
+		if revokedAt.Valid {
+			g["revoked_at"] = revokedAt.Int64
+		}
+		list = append(list, g)
+	}
+	return list, nil
+}
@@ -4,6 +4,8 @@ package main
 import (
 	"database/sql"
 	"errors"
+	"math"
+	"time"
 
 	"omnixius-api/db"
 
@@ -13,6 +15,12 @@ import (
 var (
 	ErrOrderProductNotFound = errors.New("product not found")
 	ErrOrderOwnProduct      = errors.New("cannot order own product")
+	ErrOrderNotFound        = errors.New("order not found")
+	ErrOrderNotBuyer        = errors.New("only the buyer can pay for an order")
+	ErrOrderNotPayable      = errors.New("order cannot be paid")
+	ErrOrderStatusChanged   = errors.New("order status changed concurrently")
+	ErrOrderCompleteBuyer   = errors.New("only the buyer can complete a paid order")
+	ErrOrderNotCancellable  = errors.New("a confirmed order can only be cancelled while its payment is held")
 )
 
 // OrdersMy returns all orders where the user is buyer or seller.
@@ -45,7 +53,7 @@ func OrderCreate(buyerID, productID int64, installmentPlan string, urgent bool)
 // OrderCreateWithSlot creates an order, optionally linked to a slot (slotID 0 = none). installmentPlan: "" or "requested". urgent: true for SOS.
 func OrderCreateWithSlot(buyerID, productID, slotID int64, installmentPlan string, urgent bool) (gin.H, error) {
 	var sellerID int64
-	if db.DB.QueryRow("SELECT user_id FROM products WHERE id = ?", productID).Scan(&sellerID) != nil {
+	if db.DB.QueryRow("SELECT user_id FROM products WHERE id = ? AND taken_down_at IS NULL", productID).Scan(&sellerID) != nil {
 		return nil, ErrOrderProductNotFound
 	}
 	if sellerID == buyerID {
@@ -75,3 +83,116 @@ func OrderCreateWithSlot(buyerID, productID, slotID int64, installmentPlan strin
 	}
 	return out, nil
 }
+
+// OrderPay places a wallet hold (escrow) for the product price and links it to the order (orders.hold_id).
+// Only the buyer can pay, while the order is pending or confirmed and unpaid (or its hold expired). payment_status becomes 'held'.
+func OrderPay(buyerID, orderID int64) (gin.H, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var buyer int64
+	var status, paymentStatus string
+	var holdID sql.NullInt64
+	var price float64
+	err = tx.QueryRow(
+		`SELECT o.buyer_id, o.status, o.payment_status, o.hold_id, p.price FROM orders o JOIN products p ON p.id = o.product_id WHERE o.id = ?`,
+		orderID,
+	).Scan(&buyer, &status, &paymentStatus, &holdID, &price)
+	if err == sql.ErrNoRows {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if buyer != buyerID {
+		return nil, ErrOrderNotBuyer
+	}
+	// Unpaid or expired (hold swept) orders can be paid; held, captured and released are final for this order.
+	payable := paymentStatus == "expired" || (paymentStatus == "pending" && !holdID.Valid)
+	if (status != "pending" && status != "confirmed") || !payable {
+		return nil, ErrOrderNotPayable
+	}
+	amount := int64(math.Round(price * balanceMinorUnits))
+	if amount <= 0 {
+		return nil, ErrOrderNotPayable
+	}
+	expiresAt := time.Now().Add(walletHoldDefaultTTL).Unix()
+	hid, err := placeHoldTx(tx, buyerID, orderID, balanceCurrency, amount, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE orders SET hold_id = ?, payment_status = 'held', updated_at = unixepoch() WHERE id = ?", hid, orderID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"order_id": orderID, "hold_id": hid, "payment_status": "held",
+		"currency": balanceCurrency, "amount": amount, "expires_at": expiresAt,
+	}, nil
+}
+
+// OrderApplyStatus moves the order from one status to another (transition already validated) and settles escrow in the
+// same transaction: 'completed' captures a held payment to orders.seller_id, 'cancelled' releases it to the buyer.
+// Escrow is optional: orders without a hold (unpaid, paid offline, legacy rows) change status as before. Only the
+// buyer completes a held payment (ErrOrderCompleteBuyer); an order whose hold expired cannot be completed until the
+// buyer pays again (ErrHoldExpired). A confirmed order can be cancelled only to refund a held payment
+// (ErrOrderNotCancellable). Publishes OrderStatusChanged (actor actorID) after commit.
+func OrderApplyStatus(actorID, orderID int64, from, to string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE orders SET status = ?, updated_at = unixepoch() WHERE id = ? AND status = ?", to, orderID, from)
+	if err != nil {
+		return err
+	}
+	if mustRows(res) == 0 {
+		return ErrOrderStatusChanged
+	}
+	ev := OrderStatusChanged{OrderID: orderID, From: from, To: to}
+	var holdID sql.NullInt64
+	var paymentStatus string
+	if err := tx.QueryRow("SELECT buyer_id, seller_id, hold_id, payment_status FROM orders WHERE id = ?", orderID).Scan(&ev.BuyerID, &ev.SellerID, &holdID, &paymentStatus); err != nil {
+		return err
+	}
+	if from == "confirmed" && to == "cancelled" && !(holdID.Valid && paymentStatus == "held") {
+		return ErrOrderNotCancellable
+	}
+	if to == "completed" && holdID.Valid {
+		if paymentStatus == "held" && actorID != ev.BuyerID {
+			return ErrOrderCompleteBuyer
+		}
+		if paymentStatus == "expired" {
+			return ErrHoldExpired
+		}
+	}
+	if to == "completed" || to == "cancelled" {
+		if holdID.Valid && paymentStatus == "held" {
+			h, err := loadOpenHold(tx, holdID.Int64)
+			if err != nil {
+				return err
+			}
+			if to == "completed" {
+				err = captureHoldTx(tx, h, h.SellerID, h.Amount)
+			} else {
+				_, err = releaseHoldTx(tx, h, holdReleased)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err := publishEventTx(tx, TopicOrderStatusChanged, actorID, ev); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	wakeEventRelay()
+	return nil
+}
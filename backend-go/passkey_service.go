@@ -0,0 +1,156 @@
+// Passkey management (§1.1): list, rename and remove WebAuthn credentials, and act on the sign counter after
+// each passkey login so a cloned authenticator cannot keep signing in.
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"omnixius-api/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+const maxPasskeyNameLen = 64
+
+var (
+	ErrPasskeyNotFound    = errors.New("passkey not found")
+	ErrPasskeyNameInvalid = errors.New("name must be 1-64 characters")
+	ErrPasskeyCloned      = errors.New("this passkey may have been cloned and is blocked; sign in another way and remove it")
+	ErrLastAuthMethod     = errors.New("cannot remove your last sign-in method; set a password or add another passkey first")
+)
+
+// formatAAGUID renders the authenticator model id as a UUID ("" when the authenticator did not send one).
+func formatAAGUID(b []byte) string {
+	id, err := uuid.FromBytes(b)
+	if err != nil {
+		return ""
+	}
+	return id.String()
+}
+
+// ListPasskeys returns the user's passkeys, newest first.
+func ListPasskeys(userID int64) ([]gin.H, error) {
+	rows, err := db.DB.Query(
+		`SELECT id, name, aaguid, credential_json, created_at, last_used_at, clone_warning
+		 FROM webauthn_credentials WHERE user_id = ? ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []gin.H{}
+	for rows.Next() {
+		var id, createdAt int64
+		var name, aaguid sql.NullString
+		var raw string
+		var lastUsed sql.NullInt64
+		var cloned int
+		if rows.Scan(&id, &name, &aaguid, &raw, &createdAt, &lastUsed, &cloned) != nil {
+			continue
+		}
+		if !aaguid.Valid {
+			var cred webauthn.Credential
+			if json.Unmarshal([]byte(raw), &cred) == nil {
+				aaguid.String = formatAAGUID(cred.Authenticator.AAGUID)
+			}
+		}
+		p := gin.H{
+			"id": id, "name": name.String, "aaguid": aaguid.String,
+			"created_at": createdAt, "last_used_at": nil, "blocked": cloned == 1,
+		}
+		if lastUsed.Valid {
+			p["last_used_at"] = lastUsed.Int64
+		}
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+// RenamePasskey sets the friendly name of one of the user's passkeys.
+func RenamePasskey(userID, id int64, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > maxPasskeyNameLen {
+		return ErrPasskeyNameInvalid
+	}
+	res, err := db.DB.Exec("UPDATE webauthn_credentials SET name = ?, updated_at = ? WHERE id = ? AND user_id = ?",
+		name, time.Now().Unix(), id, userID)
+	if err != nil {
+		return err
+	}
+	if mustRows(res) == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// DeletePasskey removes a passkey and signs out the sessions it opened. The last passkey of an account
+// without a password cannot be removed.
+func DeletePasskey(userID, id int64) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var cloned int
+	if tx.QueryRow("SELECT clone_warning FROM webauthn_credentials WHERE id = ? AND user_id = ?", id, userID).Scan(&cloned) != nil {
+		return ErrPasskeyNotFound
+	}
+	var passwordSet, others int
+	tx.QueryRow("SELECT password_set FROM users WHERE id = ?", userID).Scan(&passwordSet)
+	tx.QueryRow("SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = ? AND id != ? AND clone_warning = 0", userID, id).Scan(&others)
+	// A blocked passkey cannot sign in anyway, so removing it never locks the user out.
+	if passwordSet == 0 && others == 0 && cloned == 0 {
+		return ErrLastAuthMethod
+	}
+	if _, err := tx.Exec("DELETE FROM webauthn_credentials WHERE id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ? AND credential_id = ?", userID, id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	auditLog(userID, "passkey.deleted", "webauthn_credential", strconv.FormatInt(id, 10), "")
+	return nil
+}
+
+// passkeyLoginUsed records a successful assertion: it stores the new sign counter and last use and returns
+// the credential row id. When the counter did not advance (cred.Authenticator.CloneWarning) the credential is
+// blocked, its sessions are revoked and ErrPasskeyCloned is returned.
+func passkeyLoginUsed(userID int64, cred *webauthn.Credential) (int64, error) {
+	var id int64
+	var cloned int
+	err := db.DB.QueryRow("SELECT id, clone_warning FROM webauthn_credentials WHERE user_id = ? AND credential_id_base64 = ?",
+		userID, base64.RawURLEncoding.EncodeToString(cred.ID)).Scan(&id, &cloned)
+	if err != nil {
+		return 0, ErrPasskeyNotFound
+	}
+	if cloned == 1 {
+		return 0, ErrPasskeyCloned
+	}
+	if cred.Authenticator.CloneWarning {
+		db.DB.Exec("UPDATE webauthn_credentials SET clone_warning = 1, updated_at = ? WHERE id = ?", time.Now().Unix(), id)
+		db.DB.Exec("DELETE FROM sessions WHERE user_id = ? AND credential_id = ?", userID, id)
+		auditLog(userID, "passkey.clone_detected", "webauthn_credential", strconv.FormatInt(id, 10),
+			"sign_count="+strconv.FormatUint(uint64(cred.Authenticator.SignCount), 10))
+		return 0, ErrPasskeyCloned
+	}
+	raw, err := json.Marshal(cred)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().Unix()
+	if _, err := db.DB.Exec("UPDATE webauthn_credentials SET credential_json = ?, last_used_at = ?, updated_at = ? WHERE id = ?",
+		string(raw), now, now, id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
@@ -0,0 +1,71 @@
+// Personal access token handlers: /auth/tokens (session tokens only; scoped tokens cannot manage tokens).
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// patError maps token service errors to a status; ok is false for unexpected errors.
+func patError(err error) (int, bool) {
+	switch {
+	case errors.Is(err, ErrPATNotFound):
+		return http.StatusNotFound, true
+	case errors.Is(err, ErrPATNameInvalid), errors.Is(err, ErrPATScopeInvalid), errors.Is(err, ErrPATExpiryRange):
+		return http.StatusBadRequest, true
+	case errors.Is(err, ErrPATLimit):
+		return http.StatusConflict, true
+	}
+	return 0, false
+}
+
+func handlePATList(c *gin.Context) {
+	list, err := ListPATs(getUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list tokens"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": list, "available_scopes": apiScopes})
+}
+
+func handlePATCreate(c *gin.Context) {
+	var body struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and scopes required"})
+		return
+	}
+	tok, err := CreatePAT(getUserID(c), body.Name, body.Scopes, body.ExpiresInDays)
+	if err != nil {
+		if status, ok := patError(err); ok {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+		return
+	}
+	c.JSON(http.StatusCreated, tok)
+}
+
+func handlePATRevoke(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if err := RevokePAT(getUserID(c), id); err != nil {
+		if status, ok := patError(err); ok {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
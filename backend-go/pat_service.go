@@ -0,0 +1,211 @@
+// Personal access tokens: long-lived, scoped bearer tokens for scripts and integrations. Scoped tokens
+// (these, and access tokens carrying scp claims) only reach routes listed in scopeGroups.
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"omnixius-api/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	patPrefix         = "omx_pat_"
+	patDefaultTTLDays = 30
+	patMaxTTLDays     = 365
+	patMaxPerUser     = 50
+	patTouchInterval  = time.Minute
+	maxPATNameLen     = 64
+)
+
+// Scopes grant <resource>:read (GET/HEAD) or <resource>:write (other methods); write does not imply read.
+var apiScopes = []string{
+	"profile:read",
+	"orders:read", "orders:write",
+	"products:read", "products:write",
+	"wallet:read", "wallet:write",
+	"messages:read", "messages:write",
+	"notifications:read", "notifications:write",
+	"vault:read", "vault:write",
+}
+
+// scopeGroups maps route prefixes (gin FullPath) to the scope resource guarding them. The first match wins.
+var scopeGroups = []struct{ prefix, resource string }{
+	{"/api/users/me/orders", "orders"},
+	{"/api/users/me/balance", "wallet"},
+	{"/api/users/me", "profile"},
+	{"/api/orders", "orders"},
+	{"/api/subscriptions", "orders"},
+	{"/api/products", "products"},
+	{"/api/wallet", "wallet"},
+	{"/api/remittances", "wallet"},
+	{"/api/conversations", "messages"},
+	{"/api/messages", "messages"},
+	{"/api/notifications", "notifications"},
+	{"/api/v1/vault", "vault"},
+}
+
+var (
+	ErrPATInvalid      = errors.New("invalid or expired token")
+	ErrPATNotFound     = errors.New("token not found")
+	ErrPATNameInvalid  = errors.New("name must be 1-64 characters")
+	ErrPATScopeInvalid = errors.New("unknown or missing scopes")
+	ErrPATExpiryRange  = errors.New("expires_in_days must be between 1 and 365")
+	ErrPATLimit        = errors.New("too many active tokens; revoke one first")
+)
+
+// requiredScope returns the scope a request needs, or "" when the route is not open to scoped tokens.
+func requiredScope(method, fullPath string) string {
+	for _, g := range scopeGroups {
+		if fullPath == g.prefix || strings.HasPrefix(fullPath, g.prefix+"/") {
+			if method == "GET" || method == "HEAD" {
+				return g.resource + ":read"
+			}
+			if g.resource == "profile" {
+				return ""
+			}
+			return g.resource + ":write"
+		}
+	}
+	return ""
+}
+
+// scopeAllowed reports whether a token limited to scopes may call the route.
+func scopeAllowed(scopes []string, method, fullPath string) bool {
+	need := requiredScope(method, fullPath)
+	return need != "" && slices.Contains(scopes, need)
+}
+
+// normalizeScopes validates, de-duplicates and sorts requested scopes.
+func normalizeScopes(scopes []string) ([]string, error) {
+	var out []string
+	for _, s := range scopes {
+		s = strings.TrimSpace(s)
+		if !slices.Contains(apiScopes, s) {
+			return nil, ErrPATScopeInvalid
+		}
+		if !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	if len(out) == 0 {
+		return nil, ErrPATScopeInvalid
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// CreatePAT issues a token; the secret is returned only here.
+func CreatePAT(userID int64, name string, scopes []string, ttlDays int) (gin.H, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > maxPATNameLen {
+		return nil, ErrPATNameInvalid
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return nil, err
+	}
+	if ttlDays == 0 {
+		ttlDays = patDefaultTTLDays
+	}
+	if ttlDays < 1 || ttlDays > patMaxTTLDays {
+		return nil, ErrPATExpiryRange
+	}
+	now := time.Now()
+	var active int
+	db.DB.QueryRow("SELECT COUNT(*) FROM personal_access_tokens WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?",
+		userID, now.Unix()).Scan(&active)
+	if active >= patMaxPerUser {
+		return nil, ErrPATLimit
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	token := patPrefix + base64.RawURLEncoding.EncodeToString(b)
+	expiresAt := now.AddDate(0, 0, ttlDays).Unix()
+	res, err := db.DB.Exec(
+		"INSERT INTO personal_access_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		userID, name, sha256Hex(token), token[:len(patPrefix)+4], strings.Join(scopes, " "), expiresAt, now.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	auditLog(userID, "pat.created", "personal_access_token", strconv.FormatInt(id, 10), strings.Join(scopes, " "))
+	return gin.H{"id": id, "name": name, "token": token, "scopes": scopes, "expires_at": expiresAt, "created_at": now.Unix()}, nil
+}
+
+// ListPATs returns the user's tokens without secrets, newest first.
+func ListPATs(userID int64) ([]gin.H, error) {
+	rows, err := db.DB.Query(
+		`SELECT id, name, token_prefix, scopes, expires_at, last_used_at, COALESCE(last_used_ip, ''), revoked_at, created_at
+		 FROM personal_access_tokens WHERE user_id = ? ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	now := time.Now().Unix()
+	list := []gin.H{}
+	for rows.Next() {
+		var id, expiresAt, createdAt int64
+		var name, prefix, scopes, lastIP string
+		var lastUsed, revokedAt sql.NullInt64
+		if rows.Scan(&id, &name, &prefix, &scopes, &expiresAt, &lastUsed, &lastIP, &revokedAt, &createdAt) != nil {
+			continue
+		}
+		t := gin.H{
+			"id": id, "name": name, "token_prefix": prefix, "scopes": strings.Fields(scopes),
+			"expires_at": expiresAt, "last_used_at": nil, "last_used_ip": lastIP, "revoked_at": nil,
+			"created_at": createdAt, "active": !revokedAt.Valid && expiresAt > now,
+		}
+		if lastUsed.Valid {
+			t["last_used_at"] = lastUsed.Int64
+		}
+		if revokedAt.Valid {
+			t["revoked_at"] = revokedAt.Int64
+		}
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+// RevokePAT revokes one of the user's tokens.
+func RevokePAT(userID, id int64) error {
+	res, err := db.DB.Exec("UPDATE personal_access_tokens SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		time.Now().Unix(), id, userID)
+	if err != nil {
+		return err
+	}
+	if mustRows(res) == 0 {
+		return ErrPATNotFound
+	}
+	auditLog(userID, "pat.revoked", "personal_access_token", strconv.FormatInt(id, 10), "")
+	return nil
+}
+
+// AuthenticatePAT resolves a presented token to its user and scopes and records its use (throttled).
+func AuthenticatePAT(token, ip string) (userID int64, scopes []string, err error) {
+	var id, lastUsed int64
+	var scopeList string
+	now := time.Now().Unix()
+	err = db.DB.QueryRow(
+		`SELECT id, user_id, scopes, COALESCE(last_used_at, 0) FROM personal_access_tokens
+		 WHERE token_hash = ? AND revoked_at IS NULL AND expires_at > ?`, sha256Hex(token), now,
+	).Scan(&id, &userID, &scopeList, &lastUsed)
+	if err != nil {
+		return 0, nil, ErrPATInvalid
+	}
+	if now-lastUsed >= int64(patTouchInterval/time.Second) {
+		db.DB.Exec("UPDATE personal_access_tokens SET last_used_at = ?, last_used_ip = ? WHERE id = ?", now, nullStr(ip), id)
+	}
+	return userID, strings.Fields(scopeList), nil
+}
@@ -0,0 +1,161 @@
+// Phone verification (B1 Identity): a 6-digit SMS code confirms users.phone and sets phone_verified.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"omnixius-api/db"
+	"omnixius-api/internal/sms"
+)
+
+const (
+	phoneCodeTTL         = 10 * time.Minute
+	phoneCodeMaxAttempts = 5
+	phoneResendInterval  = time.Minute
+)
+
+var (
+	ErrPhoneInvalid         = errors.New("phone must be in international format, e.g. +14155550123")
+	ErrPhoneTaken           = errors.New("phone number already verified by another account")
+	ErrPhoneAlreadyVerified = errors.New("phone number already verified")
+	ErrPhoneResendTooSoon   = errors.New("verification code sent recently")
+	ErrPhoneNoPending       = errors.New("no pending phone verification")
+	ErrPhoneCodeExpired     = errors.New("verification code expired")
+	ErrPhoneCodeInvalid     = errors.New("invalid verification code")
+	ErrPhoneTooManyAttempts = errors.New("too many attempts; request a new code")
+)
+
+var smsSender sms.Sender = sms.LogSender{}
+
+// initSMSSender writes codes to SMS_OUTBOX_FILE when set, otherwise to the log.
+func initSMSSender() {
+	if cfg.SMSOutboxFile != "" {
+		smsSender = sms.NewFileSender(cfg.SMSOutboxFile)
+		log.Printf("SMS: writing messages to %s", cfg.SMSOutboxFile)
+		return
+	}
+	smsSender = sms.LogSender{}
+}
+
+// normalizePhone strips spaces, dashes, dots and parentheses and requires E.164: "+" and 8-15 digits.
+func normalizePhone(raw string) (string, error) {
+	p := strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "").Replace(strings.TrimSpace(raw))
+	if strings.HasPrefix(p, "00") {
+		p = "+" + p[2:]
+	}
+	if len(p) < 9 || len(p) > 16 || p[0] != '+' || p[1] == '0' {
+		return "", ErrPhoneInvalid
+	}
+	for _, r := range p[1:] {
+		if r < '0' || r > '9' {
+			return "", ErrPhoneInvalid
+		}
+	}
+	return p, nil
+}
+
+// phoneCodeHash binds the code to the user and number. Codes are short, so expiry and the attempt limit are
+// what protect them; the hash only keeps them out of the database in plain text.
+func phoneCodeHash(userID int64, phone, code string) string {
+	return sha256Hex(strconv.FormatInt(userID, 10) + ":" + phone + ":" + code)
+}
+
+// PhoneStartVerification sends a code to phone. The number is saved on the account only once verified,
+// so changing it keeps the old verified number until the new one is confirmed.
+func PhoneStartVerification(userID int64, raw string) (string, error) {
+	phone, err := normalizePhone(raw)
+	if err != nil {
+		return "", err
+	}
+	var current string
+	var verified int
+	db.DB.QueryRow("SELECT COALESCE(phone, ''), COALESCE(phone_verified, 0) FROM users WHERE id = ?", userID).Scan(&current, &verified)
+	if current == phone && verified == 1 {
+		return "", ErrPhoneAlreadyVerified
+	}
+	var other int64
+	if db.DB.QueryRow("SELECT id FROM users WHERE phone = ? AND phone_verified = 1 AND id != ?", phone, userID).Scan(&other) == nil {
+		return "", ErrPhoneTaken
+	}
+	now := time.Now()
+	var sentAt int64
+	if db.DB.QueryRow("SELECT sent_at FROM phone_verifications WHERE user_id = ?", userID).Scan(&sentAt) == nil &&
+		now.Unix()-sentAt < int64(phoneResendInterval/time.Second) {
+		return "", ErrPhoneResendTooSoon
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	_, err = db.DB.Exec(
+		`INSERT INTO phone_verifications (user_id, phone, code_hash, attempts, expires_at, sent_at) VALUES (?, ?, ?, 0, ?, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET phone = excluded.phone, code_hash = excluded.code_hash, attempts = 0,
+		 expires_at = excluded.expires_at, sent_at = excluded.sent_at`,
+		userID, phone, phoneCodeHash(userID, phone, code), now.Add(phoneCodeTTL).Unix(), now.Unix(),
+	)
+	if err != nil {
+		return "", err
+	}
+	if err := smsSender.Send(phone, "Your OMNIXIUS verification code: "+code+". It expires in 10 minutes."); err != nil {
+		db.DB.Exec("DELETE FROM phone_verifications WHERE user_id = ?", userID)
+		return "", err
+	}
+	return phone, nil
+}
+
+// PhoneVerify checks the pending code and, on success, stores the number as the verified phone.
+func PhoneVerify(userID int64, code string) (string, error) {
+	var phone, hash string
+	var attempts int
+	var expiresAt int64
+	err := db.DB.QueryRow("SELECT phone, code_hash, attempts, expires_at FROM phone_verifications WHERE user_id = ?", userID).
+		Scan(&phone, &hash, &attempts, &expiresAt)
+	if err != nil {
+		return "", ErrPhoneNoPending
+	}
+	if expiresAt <= time.Now().Unix() {
+		return "", ErrPhoneCodeExpired
+	}
+	if attempts >= phoneCodeMaxAttempts {
+		return "", ErrPhoneTooManyAttempts
+	}
+	if subtle.ConstantTimeCompare([]byte(phoneCodeHash(userID, phone, strings.TrimSpace(code))), []byte(hash)) != 1 {
+		db.DB.Exec("UPDATE phone_verifications SET attempts = attempts + 1 WHERE user_id = ?", userID)
+		return "", ErrPhoneCodeInvalid
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE users SET phone = ?, phone_verified = 1, updated_at = unixepoch() WHERE id = ?", phone, userID); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("DELETE FROM phone_verifications WHERE user_id = ?", userID); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	auditLog(userID, "phone.verified", "user", strconv.FormatInt(userID, 10), phone)
+	return phone, nil
+}
+
+// PhoneRemove clears the phone number and its verification.
+func PhoneRemove(userID int64) error {
+	db.DB.Exec("DELETE FROM phone_verifications WHERE user_id = ?", userID)
+	_, err := db.DB.Exec("UPDATE users SET phone = NULL, phone_verified = 0, updated_at = unixepoch() WHERE id = ?", userID)
+	if err == nil {
+		auditLog(userID, "phone.removed", "user", strconv.FormatInt(userID, 10), "")
+	}
+	return err
+}
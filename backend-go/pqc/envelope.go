@@ -0,0 +1,173 @@
+package pqc
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/cloudflare/circl/sign/dilithium/mode3"
+	"github.com/cloudflare/circl/sign/mldsa/mldsa65"
+)
+
+// Signature algorithms. Hybrid keys are the ML-DSA-65 key followed by the Ed25519 key (public and private);
+// hybrid signatures are the ML-DSA-65 signature followed by the Ed25519 signature, and both must verify.
+const (
+	AlgDilithium3       = "DILITHIUM3" // pre-standard CIRCL mode3; kept for keys created before ML-DSA
+	AlgMLDSA65          = "ML-DSA-65"  // FIPS 204
+	AlgMLDSA65Ed25519   = "ML-DSA-65+Ed25519"
+	envelopeVersion     = 2
+	envelopeMaxKIDLen   = 255
+	mldsaContext        = "omnixius-token"
+	hybridPublicKeySize = mldsa65.PublicKeySize + ed25519.PublicKeySize
+	hybridPrivKeySize   = mldsa65.PrivateKeySize + ed25519.PrivateKeySize
+)
+
+var algCodes = map[string]byte{AlgDilithium3: 1, AlgMLDSA65: 2, AlgMLDSA65Ed25519: 3}
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown signature algorithm")
+	ErrTokenExpired     = errors.New("token expired")
+	errBadSignature     = errors.New("invalid signature")
+)
+
+// Claims are the signed token fields. SessionID 0 = no session row.
+type Claims struct {
+	UserID    int64    `json:"sub"`
+	SessionID int64    `json:"sid,omitempty"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+	Role      string   `json:"role,omitempty"`
+	Scopes    []string `json:"scp,omitempty"`
+	GrantID   int64    `json:"gid,omitempty"` // OAuth grant; 0 for first-party tokens
+}
+
+// Expiry returns ExpiresAt as a time.
+func (c Claims) Expiry() time.Time { return time.Unix(c.ExpiresAt, 0) }
+
+// GenerateKeyFor creates a key pair for alg.
+func GenerateKeyFor(alg string) (privateKey, publicKey []byte, err error) {
+	switch alg {
+	case AlgDilithium3:
+		return GenerateKey()
+	case AlgMLDSA65:
+		pk, sk, err := mldsa65.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sk.Bytes(), pk.Bytes(), nil
+	case AlgMLDSA65Ed25519:
+		pk, sk, err := mldsa65.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return append(sk.Bytes(), edPriv...), append(pk.Bytes(), edPub...), nil
+	}
+	return nil, nil, ErrUnknownAlgorithm
+}
+
+// keySizes returns the expected public and private key sizes for alg.
+func keySizes(alg string) (pub, priv int, err error) {
+	switch alg {
+	case AlgDilithium3:
+		return mode3.PublicKeySize, mode3.PrivateKeySize, nil
+	case AlgMLDSA65:
+		return mldsa65.PublicKeySize, mldsa65.PrivateKeySize, nil
+	case AlgMLDSA65Ed25519:
+		return hybridPublicKeySize, hybridPrivKeySize, nil
+	}
+	return 0, 0, ErrUnknownAlgorithm
+}
+
+// signEnvelope returns base64url(body) "." base64url(signature), body = version | alg | len(kid) | kid | claims JSON.
+func signEnvelope(k Key, c Claims) (string, error) {
+	if len(k.ID) > envelopeMaxKIDLen {
+		return "", errors.New("key id too long")
+	}
+	claims, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	body := make([]byte, 0, 3+len(k.ID)+len(claims))
+	body = append(body, envelopeVersion, algCodes[k.Algorithm], byte(len(k.ID)))
+	body = append(body, k.ID...)
+	body = append(body, claims...)
+	sig, err := signBytes(k, body)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(body) + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func signBytes(k Key, msg []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case AlgDilithium3:
+		var sk mode3.PrivateKey
+		if err := sk.UnmarshalBinary(k.PrivateKey); err != nil {
+			return nil, err
+		}
+		sig := make([]byte, mode3.SignatureSize)
+		mode3.SignTo(&sk, msg, sig)
+		return sig, nil
+	case AlgMLDSA65, AlgMLDSA65Ed25519:
+		var sk mldsa65.PrivateKey
+		if err := sk.UnmarshalBinary(k.PrivateKey[:mldsa65.PrivateKeySize]); err != nil {
+			return nil, err
+		}
+		sig := make([]byte, mldsa65.SignatureSize)
+		if err := mldsa65.SignTo(&sk, msg, []byte(mldsaContext), true, sig); err != nil {
+			return nil, err
+		}
+		if k.Algorithm == AlgMLDSA65Ed25519 {
+			sig = append(sig, ed25519.Sign(ed25519.PrivateKey(k.PrivateKey[mldsa65.PrivateKeySize:]), msg)...)
+		}
+		return sig, nil
+	}
+	return nil, ErrUnknownAlgorithm
+}
+
+func verifyBytes(k Key, msg, sig []byte) bool {
+	switch k.Algorithm {
+	case AlgDilithium3:
+		var pk mode3.PublicKey
+		if len(sig) != mode3.SignatureSize || pk.UnmarshalBinary(k.PublicKey) != nil {
+			return false
+		}
+		return mode3.Verify(&pk, msg, sig)
+	case AlgMLDSA65, AlgMLDSA65Ed25519:
+		want := mldsa65.SignatureSize
+		if k.Algorithm == AlgMLDSA65Ed25519 {
+			want += ed25519.SignatureSize
+		}
+		var pk mldsa65.PublicKey
+		if len(sig) != want || pk.UnmarshalBinary(k.PublicKey[:mldsa65.PublicKeySize]) != nil {
+			return false
+		}
+		if !mldsa65.Verify(&pk, msg, []byte(mldsaContext), sig[:mldsa65.SignatureSize]) {
+			return false
+		}
+		if k.Algorithm == AlgMLDSA65Ed25519 {
+			return ed25519.Verify(ed25519.PublicKey(k.PublicKey[mldsa65.PublicKeySize:]), msg, sig[mldsa65.SignatureSize:])
+		}
+		return true
+	}
+	return false
+}
+
+// parseEnvelope splits a decoded envelope body into algorithm code, key ID and claims JSON.
+func parseEnvelope(body []byte) (alg byte, kid string, claims []byte, err error) {
+	if len(body) < 3 || body[0] != envelopeVersion {
+		return 0, "", nil, errors.New("invalid token envelope")
+	}
+	n := int(body[2])
+	if len(body) < 3+n {
+		return 0, "", nil, errors.New("invalid token envelope")
+	}
+	return body[1], string(body[3 : 3+n]), body[3+n:], nil
+}
@@ -0,0 +1,179 @@
+package pqc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownKey     = errors.New("unknown key id")
+	ErrNoSigner       = errors.New("keyring has no active signing key")
+	ErrLegacyRejected = errors.New("legacy token format no longer accepted")
+)
+
+// Key is one keyring entry. Algorithm "" means AlgDilithium3. PrivateKey is nil for verify-only keys.
+type Key struct {
+	ID         string
+	Algorithm  string
+	PublicKey  []byte
+	PrivateKey []byte
+}
+
+// KeyID derives a stable key ID from a public key (first 16 bytes of SHA-256, base64url).
+func KeyID(publicKey []byte) string {
+	sum := sha256.Sum256(publicKey)
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// Keyring holds the verification keys and the one active signing key. Build a new one to rotate.
+// Sign issues versioned envelopes (see signEnvelope). Verify also accepts the pre-envelope Dilithium3
+// formats "<payload>.<sig>" and "<kid>.<payload>.<sig>" until LegacyUntil (zero = no cutoff).
+type Keyring struct {
+	LegacyUntil time.Time
+
+	keys   map[string]Key
+	order  []string
+	active string
+}
+
+// NewKeyring validates keys and returns a keyring signing with activeID ("" = verify only).
+func NewKeyring(keys []Key, activeID string) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[string]Key, len(keys)), active: activeID}
+	for _, k := range keys {
+		if k.Algorithm == "" {
+			k.Algorithm = AlgDilithium3
+		}
+		pubSize, privSize, err := keySizes(k.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		if len(k.PublicKey) != pubSize {
+			return nil, errors.New("invalid public key size for " + k.ID)
+		}
+		if k.PrivateKey != nil && len(k.PrivateKey) != privSize {
+			return nil, errors.New("invalid private key size for " + k.ID)
+		}
+		if k.ID == "" {
+			k.ID = KeyID(k.PublicKey)
+		}
+		if _, dup := kr.keys[k.ID]; dup {
+			continue
+		}
+		kr.keys[k.ID] = k
+		kr.order = append(kr.order, k.ID)
+	}
+	if activeID != "" {
+		if k, ok := kr.keys[activeID]; !ok || k.PrivateKey == nil {
+			return nil, ErrNoSigner
+		}
+	}
+	return kr, nil
+}
+
+// ActiveID returns the ID of the signing key ("" if none).
+func (kr *Keyring) ActiveID() string { return kr.active }
+
+// PublicKeys returns every verification key, without private keys, in the order given to NewKeyring.
+func (kr *Keyring) PublicKeys() []Key {
+	out := make([]Key, 0, len(kr.order))
+	for _, id := range kr.order {
+		k := kr.keys[id]
+		out = append(out, Key{ID: id, Algorithm: k.Algorithm, PublicKey: k.PublicKey})
+	}
+	return out
+}
+
+// Has reports whether kid is in the keyring.
+func (kr *Keyring) Has(kid string) bool {
+	_, ok := kr.keys[kid]
+	return ok
+}
+
+// Sign issues an envelope token for c with the active key. IssuedAt defaults to now.
+func (kr *Keyring) Sign(c Claims) (string, error) {
+	if kr.active == "" {
+		return "", ErrNoSigner
+	}
+	if c.IssuedAt == 0 {
+		c.IssuedAt = time.Now().Unix()
+	}
+	return signEnvelope(kr.keys[kr.active], c)
+}
+
+// Verify checks a token and returns its claims and the ID of the key that signed it.
+func (kr *Keyring) Verify(token string) (Claims, string, error) {
+	parts := strings.Split(token, ".")
+	switch len(parts) {
+	case 2:
+		body, err := base64.RawURLEncoding.DecodeString(parts[0])
+		if err != nil {
+			return Claims{}, "", errors.New("invalid token payload")
+		}
+		if len(body) == payloadLen || len(body) == payloadLenV2 {
+			return kr.verifyLegacy("", token)
+		}
+		return kr.verifyEnvelope(body, parts[1])
+	case 3:
+		return kr.verifyLegacy(parts[0], parts[1]+"."+parts[2])
+	}
+	return Claims{}, "", errors.New("invalid token format")
+}
+
+func (kr *Keyring) verifyEnvelope(body []byte, sigB64 string) (Claims, string, error) {
+	alg, kid, raw, err := parseEnvelope(body)
+	if err != nil {
+		return Claims{}, "", err
+	}
+	k, ok := kr.keys[kid]
+	if !ok {
+		return Claims{}, kid, ErrUnknownKey
+	}
+	// The algorithm comes from the key, never from the token; a mismatching header is rejected.
+	if algCodes[k.Algorithm] != alg {
+		return Claims{}, kid, ErrUnknownAlgorithm
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigB64)
+	if err != nil || !verifyBytes(k, body, sig) {
+		return Claims{}, kid, errBadSignature
+	}
+	var c Claims
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return Claims{}, kid, errors.New("invalid token claims")
+	}
+	if time.Now().After(c.Expiry()) {
+		return Claims{}, kid, ErrTokenExpired
+	}
+	return c, kid, nil
+}
+
+// verifyLegacy verifies a Dilithium3 "<payload>.<sig>" token with the key kid, or with every Dilithium3 key if kid is "".
+func (kr *Keyring) verifyLegacy(kid, token string) (Claims, string, error) {
+	if !kr.LegacyUntil.IsZero() && time.Now().After(kr.LegacyUntil) {
+		return Claims{}, kid, ErrLegacyRejected
+	}
+	ids := kr.order
+	if kid != "" {
+		if _, ok := kr.keys[kid]; !ok {
+			return Claims{}, kid, ErrUnknownKey
+		}
+		ids = []string{kid}
+	}
+	err := errBadSignature
+	for _, id := range ids {
+		k := kr.keys[id]
+		if k.Algorithm != AlgDilithium3 {
+			continue
+		}
+		var userID, sessionID int64
+		var exp time.Time
+		userID, exp, sessionID, err = VerifyToken(k.PublicKey, token)
+		if err == nil {
+			return Claims{UserID: userID, SessionID: sessionID, ExpiresAt: exp.Unix()}, id, nil
+		}
+	}
+	return Claims{}, kid, err
+}
@@ -1,4 +1,5 @@
-// Package pqc provides quantum-resistant auth tokens (Dilithium3 signatures).
+// Package pqc provides quantum-resistant auth tokens. Keyring issues versioned envelopes signed with
+// ML-DSA-65 (FIPS 204), optionally hybrid with Ed25519; this file is the original Dilithium3 format, still verified.
 package pqc
 
 import (
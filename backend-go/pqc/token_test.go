@@ -1,8 +1,12 @@
 package pqc
 
 import (
+	"encoding/base64"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/cloudflare/circl/sign/mldsa/mldsa65"
 )
 
 func TestGenerateKey(t *testing.T) {
@@ -77,3 +81,118 @@ func TestVerifyToken_Tampered(t *testing.T) {
 		t.Error("expected error for tampered token")
 	}
 }
+
+func newTestKeyring(t *testing.T, alg string) (*Keyring, Key) {
+	t.Helper()
+	priv, pub, err := GenerateKeyFor(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := Key{ID: KeyID(pub), Algorithm: alg, PublicKey: pub, PrivateKey: priv}
+	kr, err := NewKeyring([]Key{k}, k.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr, k
+}
+
+// flipSig flips one byte of the decoded signature at offset (negative = from the end).
+func flipSig(t *testing.T, tok string, offset int) string {
+	t.Helper()
+	parts := strings.Split(tok, ".")
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if offset < 0 {
+		offset += len(sig)
+	}
+	sig[offset] ^= 0xff
+	return parts[0] + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestKeyring_MLDSA65Envelope(t *testing.T) {
+	kr, k := newTestKeyring(t, AlgMLDSA65)
+	tok, err := kr.Sign(Claims{UserID: 5, SessionID: 9, Role: "admin", Scopes: []string{"orders:read"}, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, kid, err := kr.Verify(tok)
+	if err != nil || kid != k.ID || c.UserID != 5 || c.SessionID != 9 || c.Role != "admin" || len(c.Scopes) != 1 || c.IssuedAt == 0 {
+		t.Fatalf("verify: %+v kid %s err %v", c, kid, err)
+	}
+	if _, _, err := kr.Verify(flipSig(t, tok, 0)); err == nil {
+		t.Error("tampered signature accepted")
+	}
+	expired, _ := kr.Sign(Claims{UserID: 5, ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	if _, _, err := kr.Verify(expired); err != ErrTokenExpired {
+		t.Errorf("expired: got %v, want ErrTokenExpired", err)
+	}
+}
+
+func TestKeyring_HybridRequiresBothSignatures(t *testing.T) {
+	kr, k := newTestKeyring(t, AlgMLDSA65Ed25519)
+	tok, err := kr.Sign(Claims{UserID: 6, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, _, err := kr.Verify(tok); err != nil || c.UserID != 6 {
+		t.Fatalf("verify: %+v err %v", c, err)
+	}
+	if _, _, err := kr.Verify(flipSig(t, tok, 0)); err == nil {
+		t.Error("accepted with a broken ML-DSA signature")
+	}
+	if _, _, err := kr.Verify(flipSig(t, tok, -1)); err == nil {
+		t.Error("accepted with a broken Ed25519 signature")
+	}
+	// A verifier that knows the key ID only as ML-DSA-65 must reject the hybrid header, not check half of it.
+	downgraded, _ := NewKeyring([]Key{{ID: k.ID, Algorithm: AlgMLDSA65, PublicKey: k.PublicKey[:mldsa65.PublicKeySize]}}, "")
+	if _, _, err := downgraded.Verify(tok); err != ErrUnknownAlgorithm {
+		t.Errorf("algorithm mismatch: got %v, want ErrUnknownAlgorithm", err)
+	}
+}
+
+func TestKeyring_Dilithium3Envelope(t *testing.T) {
+	kr, k := newTestKeyring(t, AlgDilithium3)
+	tok, _ := kr.Sign(Claims{UserID: 7, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if c, kid, err := kr.Verify(tok); err != nil || c.UserID != 7 || kid != k.ID {
+		t.Fatalf("verify: %+v kid %s err %v", c, kid, err)
+	}
+}
+
+func TestKeyring_LegacyTokensDuringMigrationWindow(t *testing.T) {
+	sk, pk, _ := GenerateKey()
+	legacy, _ := SignTokenWithSession(sk, 8, 2, time.Now().Add(time.Hour))
+	withKID := KeyID(pk) + "." + legacy
+	_, ml := newTestKeyring(t, AlgMLDSA65)
+	kr, err := NewKeyring([]Key{ml, {PublicKey: pk}}, ml.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tok := range map[string]string{"payload.sig": legacy, "kid.payload.sig": withKID} {
+		if c, _, err := kr.Verify(tok); err != nil || c.UserID != 8 || c.SessionID != 2 {
+			t.Errorf("%s: %+v err %v", name, c, err)
+		}
+	}
+	kr.LegacyUntil = time.Now().Add(-time.Minute)
+	if _, _, err := kr.Verify(legacy); err != ErrLegacyRejected {
+		t.Errorf("after window: got %v, want ErrLegacyRejected", err)
+	}
+}
+
+func TestKeyring_RotatedOutKeyVerifiesUnknownKeyRejected(t *testing.T) {
+	old, oldKey := newTestKeyring(t, AlgMLDSA65)
+	oldTok, _ := old.Sign(Claims{UserID: 1, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	_, newKey := newTestKeyring(t, AlgMLDSA65)
+	rotated, err := NewKeyring([]Key{newKey, {ID: oldKey.ID, Algorithm: oldKey.Algorithm, PublicKey: oldKey.PublicKey}}, newKey.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, kid, err := rotated.Verify(oldTok); err != nil || kid != oldKey.ID {
+		t.Errorf("rotated-out key: kid %s err %v", kid, err)
+	}
+	newTok, _ := rotated.Sign(Claims{UserID: 1, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if _, _, err := old.Verify(newTok); err != ErrUnknownKey {
+		t.Errorf("unknown kid: got %v, want ErrUnknownKey", err)
+	}
+	if _, err := NewKeyring([]Key{{Algorithm: AlgMLDSA65, PublicKey: newKey.PublicKey}}, newKey.ID); err != ErrNoSigner {
+		t.Errorf("verify-only active key: got %v, want ErrNoSigner", err)
+	}
+}
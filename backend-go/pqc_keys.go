@@ -0,0 +1,256 @@
+// Token signing keyring (§1.1): keys live in pqc_keys, one active signer plus verify-only keys kept until
+// tokens they signed have expired. Rotation via POST /api/admin/keys/rotate or `omnixius-api keys rotate`.
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"omnixius-api/db"
+	"omnixius-api/internal/crypto"
+	"omnixius-api/pqc"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pqcKeyRetireGrace is added to the access token lifetime before a rotated-out key stops verifying
+// (clock skew, and instances that have not reloaded the keyring yet keep signing with it for up to a minute).
+const pqcKeyRetireGrace = time.Hour
+
+var keyring atomic.Pointer[pqc.Keyring]
+
+// currentKeyring returns the loaded keyring. initKeyring must have run.
+func currentKeyring() *pqc.Keyring { return keyring.Load() }
+
+// initKeyring loads the keyring, creating and storing a signing key when there is no active one
+// (no DB key and no DILITHIUM_* env key), so restarts no longer invalidate tokens.
+func initKeyring() error {
+	kr, err := loadKeyring()
+	if err != nil {
+		return err
+	}
+	if kr.ActiveID() == "" {
+		kid, err := RotateSigningKey()
+		if err != nil {
+			return err
+		}
+		log.Printf("PQC: no signing key configured; generated and stored key %s", kid)
+		return nil
+	}
+	keyring.Store(kr)
+	return nil
+}
+
+// startKeyringReloader reloads the keyring from the DB every interval, picking up rotations done elsewhere.
+func startKeyringReloader(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if kr, err := loadKeyring(); err != nil {
+				log.Printf("PQC: keyring reload failed: %v", err)
+			} else if kr.ActiveID() != "" {
+				keyring.Store(kr)
+			}
+		}
+	}()
+}
+
+// loadKeyring reads unretired keys from pqc_keys and adds the env key (DILITHIUM_*), which signs only
+// when the DB has no active key.
+func loadKeyring() (*pqc.Keyring, error) {
+	rows, err := db.DB.Query(
+		`SELECT kid, algorithm, public_key, private_key, private_key_encrypted, status FROM pqc_keys
+		 WHERE status IN ('active', 'verify') AND (retire_after IS NULL OR retire_after > ?)
+		 ORDER BY created_at DESC`,
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []pqc.Key
+	active := ""
+	for rows.Next() {
+		var k pqc.Key
+		var priv []byte
+		var encrypted int
+		var status string
+		if err := rows.Scan(&k.ID, &k.Algorithm, &k.PublicKey, &priv, &encrypted, &status); err != nil {
+			return nil, err
+		}
+		if status == "active" && active == "" && priv != nil {
+			if encrypted == 1 {
+				if priv, err = pqcKeyCipher().Decrypt(priv, "pqc"); err != nil {
+					return nil, fmt.Errorf("decrypt key %s: %w", k.ID, err)
+				}
+			}
+			k.PrivateKey = priv
+			active = k.ID
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(cfg.PQCPublicKey) > 0 {
+		env := pqc.Key{ID: pqc.KeyID(cfg.PQCPublicKey), Algorithm: pqc.AlgDilithium3, PublicKey: cfg.PQCPublicKey}
+		if active == "" && len(cfg.PQCPrivateKey) > 0 {
+			env.PrivateKey = cfg.PQCPrivateKey
+			active = env.ID
+		}
+		keys = append(keys, env)
+	}
+	kr, err := pqc.NewKeyring(keys, active)
+	if err != nil {
+		return nil, err
+	}
+	kr.LegacyUntil = cfg.PQCLegacyUntil
+	return kr, nil
+}
+
+// pqcKeyCipher encrypts private keys at rest with PQC_KEY_ENCRYPTION_KEY; only used when that key is set.
+func pqcKeyCipher() *crypto.AESGCMProvider {
+	return crypto.NewAESGCMProvider(map[string][]byte{"pqc": cfg.PQCKeyEncryptionKey})
+}
+
+// RotateSigningKey generates a cfg.PQCAlgorithm key, makes it the active signer and demotes the previous active
+// key to verify-only until every access token it signed has expired. Returns the new key ID.
+func RotateSigningKey() (string, error) {
+	priv, pub, err := pqc.GenerateKeyFor(cfg.PQCAlgorithm)
+	if err != nil {
+		return "", err
+	}
+	kid := pqc.KeyID(pub)
+	stored, encrypted := priv, 0
+	if len(cfg.PQCKeyEncryptionKey) > 0 {
+		if stored, err = pqcKeyCipher().Encrypt(priv, "pqc"); err != nil {
+			return "", err
+		}
+		encrypted = 1
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	if _, err := tx.Exec(
+		"UPDATE pqc_keys SET status = 'verify', rotated_at = ?, retire_after = ? WHERE status = 'active'",
+		now.Unix(), now.Add(cfg.AccessTokenTTL+pqcKeyRetireGrace).Unix(),
+	); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO pqc_keys (kid, algorithm, public_key, private_key, private_key_encrypted, status, created_at) VALUES (?, ?, ?, ?, ?, 'active', ?)",
+		kid, cfg.PQCAlgorithm, pub, stored, encrypted, now.Unix(),
+	); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	kr, err := loadKeyring()
+	if err != nil {
+		return "", err
+	}
+	keyring.Store(kr)
+	return kid, nil
+}
+
+// PQCKeysList returns key metadata (no key material), newest first.
+func PQCKeysList() ([]gin.H, error) {
+	rows, err := db.DB.Query("SELECT kid, algorithm, status, created_at, rotated_at, retire_after FROM pqc_keys ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []gin.H{}
+	for rows.Next() {
+		var kid, alg, status string
+		var createdAt int64
+		var rotatedAt, retireAfter sql.NullInt64
+		if rows.Scan(&kid, &alg, &status, &createdAt, &rotatedAt, &retireAfter) != nil {
+			continue
+		}
+		list = append(list, gin.H{"kid": kid, "algorithm": alg, "status": status, "created_at": createdAt, "rotated_at": nullInt64(rotatedAt.Int64), "retire_after": nullInt64(retireAfter.Int64)})
+	}
+	return list, nil
+}
+
+// signAccessToken signs an access token with the active key.
+func signAccessToken(c pqc.Claims) (string, error) {
+	return currentKeyring().Sign(c)
+}
+
+// verifyAccessToken verifies a token against the keyring and returns its claims.
+func verifyAccessToken(tok string) (pqc.Claims, error) {
+	c, _, err := currentKeyring().Verify(tok)
+	return c, err
+}
+
+// handleJWKS publishes the verification keys (JWK "AKP" form: base64url public key in "pub"; hybrid keys are the
+// ML-DSA-65 key followed by the Ed25519 key).
+func handleJWKS(c *gin.Context) {
+	keys := []gin.H{}
+	for _, k := range currentKeyring().PublicKeys() {
+		keys = append(keys, gin.H{
+			"kty": "AKP", "alg": k.Algorithm, "use": "sig", "kid": k.ID,
+			"pub": base64.RawURLEncoding.EncodeToString(k.PublicKey),
+		})
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(200, gin.H{"keys": keys})
+}
+
+func handleAdminKeysList(c *gin.Context) {
+	list, err := PQCKeysList()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to list keys"})
+		return
+	}
+	c.JSON(200, gin.H{"keys": list, "active_kid": currentKeyring().ActiveID()})
+}
+
+func handleAdminKeysRotate(c *gin.Context) {
+	kid, err := RotateSigningKey()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "rotation failed"})
+		return
+	}
+	auditLog(getUserID(c), "pqc_key.rotated", "pqc_key", kid, "")
+	c.JSON(200, gin.H{"ok": true, "active_kid": kid})
+}
+
+// runKeysCommand implements `omnixius-api keys list|rotate`. Returns the process exit code.
+func runKeysCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: omnixius-api keys list|rotate")
+		return 2
+	}
+	switch args[0] {
+	case "list":
+		list, err := PQCKeysList()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, k := range list {
+			fmt.Printf("%s\t%s\t%s\tcreated %v\tretire_after %v\n", k["kid"], k["algorithm"], k["status"], k["created_at"], k["retire_after"])
+		}
+	case "rotate":
+		kid, err := RotateSigningKey()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println("active key:", kid)
+	default:
+		fmt.Fprintln(os.Stderr, "unknown keys command:", args[0])
+		return 2
+	}
+	return 0
+}
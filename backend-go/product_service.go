@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"strconv"
+	"time"
 
 	"omnixius-api/db"
 
@@ -47,7 +48,7 @@ func ProductGet(idStr string) (gin.H, error) {
 	var p productRow
 	var emailVerified, phoneVerified int
 	err = db.DB.QueryRow(
-		`SELECT p.id, p.user_id, p.title, p.description, p.price, p.category, p.location, p.image_path, p.created_at, COALESCE(p.is_service, 0), COALESCE(p.is_subscription, 0), u.name, u.email, COALESCE(u.email_verified, 0), COALESCE(u.phone_verified, 0) FROM products p JOIN users u ON u.id = p.user_id WHERE p.id = ?`,
+		`SELECT p.id, p.user_id, p.title, p.description, p.price, p.category, p.location, p.image_path, p.created_at, COALESCE(p.is_service, 0), COALESCE(p.is_subscription, 0), u.name, u.email, COALESCE(u.email_verified, 0), COALESCE(u.phone_verified, 0) FROM products p JOIN users u ON u.id = p.user_id WHERE p.id = ? AND p.taken_down_at IS NULL`,
 		id,
 	).Scan(&p.ID, &p.UserID, &p.Title, &p.Description, &p.Price, &p.Category, &p.Location, &p.ImagePath, &p.CreatedAt, &p.IsService, &p.IsSubscription, &p.SellerName, &p.SellerEmail, &emailVerified, &phoneVerified)
 	if err != nil {
@@ -98,3 +99,44 @@ func ProductCreate(ownerID int64, title, description, category, location, imageP
 	}
 	return p.toH(), nil
 }
+
+var ErrProductTakedownState = errors.New("product is already in that state")
+
+// ProductTakedown hides a product from the catalogue and new orders and tells the seller why.
+func ProductTakedown(moderatorID, productID int64, reason string) error {
+	var sellerID int64
+	var title string
+	if db.DB.QueryRow("SELECT user_id, title FROM products WHERE id = ?", productID).Scan(&sellerID, &title) != nil {
+		return ErrProductNotFound
+	}
+	res, err := db.DB.Exec("UPDATE products SET taken_down_at = ?, takedown_reason = ?, taken_down_by = ? WHERE id = ? AND taken_down_at IS NULL",
+		time.Now().Unix(), reason, moderatorID, productID)
+	if err != nil {
+		return err
+	}
+	if mustRows(res) == 0 {
+		return ErrProductTakedownState
+	}
+	pid := strconv.FormatInt(productID, 10)
+	auditLog(moderatorID, "product.takedown", "product", pid, reason)
+	enqueueNotification(sellerID, "product_takedown", "in_app", "Listing taken down",
+		"\""+title+"\" was removed from the marketplace: "+reason, `{"product_id":`+pid+`}`)
+	return nil
+}
+
+// ProductRestore lists a taken-down product again.
+func ProductRestore(moderatorID, productID int64) error {
+	var exists int
+	if db.DB.QueryRow("SELECT 1 FROM products WHERE id = ?", productID).Scan(&exists) != nil {
+		return ErrProductNotFound
+	}
+	res, err := db.DB.Exec("UPDATE products SET taken_down_at = NULL, takedown_reason = NULL, taken_down_by = NULL WHERE id = ? AND taken_down_at IS NOT NULL", productID)
+	if err != nil {
+		return err
+	}
+	if mustRows(res) == 0 {
+		return ErrProductTakedownState
+	}
+	auditLog(moderatorID, "product.restored", "product", strconv.FormatInt(productID, 10), "")
+	return nil
+}
@@ -0,0 +1,147 @@
+// Rate limiting: every request spends from a per-IP budget and, once authenticated, a per-user budget for
+// its route class (auth, wallet, write, read). Login and recovery attempts have their own stricter budgets.
+// State lives in a ratelimit.Store; the in-memory store is per instance.
+package main
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"omnixius-api/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	rateClassAuth   = "auth"
+	rateClassWallet = "wallet"
+	rateClassWrite  = "write"
+	rateClassRead   = "read"
+)
+
+var rateStore ratelimit.Store = ratelimit.NewMemoryStore(100000)
+
+// initRateLimiter resets limiter state (call after cfg is loaded).
+func initRateLimiter() {
+	rateStore = ratelimit.NewMemoryStore(cfg.RateLimitMaxKeys)
+}
+
+// rateClass picks the budget for a route; gin's FullPath is used so path parameters do not matter.
+func rateClass(c *gin.Context) string {
+	p := c.FullPath()
+	if p == "" {
+		p = c.Request.URL.Path
+	}
+	for _, prefix := range []string{"/api/auth/", "/oauth/", "/login"} {
+		if strings.HasPrefix(p, prefix) {
+			return rateClassAuth
+		}
+	}
+	for _, prefix := range []string{"/api/wallet", "/api/users/me/balance", "/api/remittances"} {
+		if strings.HasPrefix(p, prefix) {
+			return rateClassWallet
+		}
+	}
+	if m := c.Request.Method; m != "GET" && m != "HEAD" && m != "OPTIONS" {
+		return rateClassWrite
+	}
+	return rateClassRead
+}
+
+// rateClassLimit is the per-user budget of a class; shared IPs get RateLimitIPMultiplier times as much.
+func rateClassLimit(class string) ratelimit.Limit {
+	n := cfg.RateLimitRead
+	switch class {
+	case rateClassAuth:
+		n = cfg.RateLimitAuth
+	case rateClassWallet:
+		n = cfg.RateLimitWallet
+	case rateClassWrite:
+		n = cfg.RateLimitWrite
+	}
+	return ratelimit.Limit{Count: n, Window: time.Minute}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// takeRateLimit spends one request from key's budget and sets RateLimit-* headers; when several budgets apply
+// to a request the one with the fewest requests left is reported. On denial Retry-After is set as well and the
+// caller writes the response. Store errors fail open.
+func takeRateLimit(c *gin.Context, key string, l ratelimit.Limit) bool {
+	if l.Count <= 0 {
+		return true
+	}
+	r, err := rateStore.Take(key, l, time.Now())
+	if err != nil {
+		log.Printf("rate limit store: %v", err)
+		return true
+	}
+	if prev, ok := c.Get("rateLimitRemaining"); !ok || r.Remaining <= prev.(int) {
+		c.Set("rateLimitRemaining", r.Remaining)
+		c.Header("RateLimit-Limit", strconv.Itoa(r.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(r.Remaining))
+		c.Header("RateLimit-Reset", ceilSeconds(r.Reset))
+		c.Header("RateLimit-Policy", strconv.Itoa(l.Count)+";w="+ceilSeconds(l.Window))
+	}
+	if !r.Allowed {
+		c.Header("Retry-After", ceilSeconds(r.RetryAfter))
+	}
+	return r.Allowed
+}
+
+func abortRateLimited(c *gin.Context) {
+	c.JSON(429, gin.H{"error": "Too many requests", "retry_after": c.Writer.Header().Get("Retry-After")})
+	c.Abort()
+}
+
+// rateLimitMiddleware applies the per-IP budget of the route class.
+func rateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.URL.Path == "/health" {
+			c.Next()
+			return
+		}
+		class := rateClass(c)
+		l := rateClassLimit(class)
+		l.Count *= cfg.RateLimitIPMultiplier
+		if !takeRateLimit(c, "ip:"+class+":"+c.ClientIP(), l) {
+			abortRateLimited(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// rateLimitUser applies the per-user budget of the route class. Called by authRequired.
+func rateLimitUser(c *gin.Context, userID int64) bool {
+	class := rateClass(c)
+	return takeRateLimit(c, "user:"+class+":"+strconv.FormatInt(userID, 10), rateClassLimit(class))
+}
+
+// allowLogin spends one password/code attempt from the client IP (MaxLoginAttempts per 15 minutes).
+func allowLogin(c *gin.Context) bool {
+	ip := c.ClientIP()
+	maxAttempts := cfg.MaxLoginAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 5
+	}
+	// Localhost: allow many attempts for dev and auto-login retries
+	if ip == "127.0.0.1" || ip == "::1" {
+		maxAttempts = 100
+	}
+	return takeRateLimit(c, "login:"+ip, ratelimit.Limit{Count: maxAttempts, Window: 15 * time.Minute})
+}
+
+// recoveryAllowed applies the per-IP and per-account recovery budgets (recoveryAttemptsPerHour each).
+// Both are spent on every attempt.
+func recoveryAllowed(c *gin.Context, email string) bool {
+	l := ratelimit.Limit{Count: recoveryAttemptsPerHour, Window: time.Hour}
+	okIP := takeRateLimit(c, "recovery:ip:"+c.ClientIP(), l)
+	okAcct := takeRateLimit(c, "recovery:acct:"+email, l)
+	return okIP && okAcct
+}
@@ -0,0 +1,291 @@
+// RBAC handlers: role management (superuser only) and the staff tools behind individual permissions
+// (product takedowns, order lookup, wallet view and adjustment).
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"omnixius-api/db"
+	"omnixius-api/internal/ledger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// roleError maps RBAC service errors to a status; ok is false for unexpected errors.
+func roleError(err error) (int, bool) {
+	switch {
+	case errors.Is(err, ErrRoleNotFound), errors.Is(err, ErrRoleUserNotFound), errors.Is(err, ErrRoleNotGranted):
+		return http.StatusNotFound, true
+	case errors.Is(err, ErrRoleNameInvalid), errors.Is(err, ErrRoleReserved), errors.Is(err, ErrRolePermissionInvalid):
+		return http.StatusBadRequest, true
+	case errors.Is(err, ErrRoleBuiltin):
+		return http.StatusConflict, true
+	}
+	return 0, false
+}
+
+func paramID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return 0, false
+	}
+	return id, true
+}
+
+func handleAdminPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"permissions": PermissionList()})
+}
+
+func handleAdminRolesList(c *gin.Context) {
+	list, err := ListRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list roles"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": list})
+}
+
+func handleAdminRolePut(c *gin.Context) {
+	var body struct {
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "permissions required"})
+		return
+	}
+	role, err := PutRole(getUserID(c), c.Param("name"), body.Description, body.Permissions)
+	if err != nil {
+		if status, ok := roleError(err); ok {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save role"})
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+func handleAdminRoleDelete(c *gin.Context) {
+	if err := DeleteRole(getUserID(c), c.Param("name")); err != nil {
+		if status, ok := roleError(err); ok {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete role"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+func handleAdminUserRoles(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	h, err := UserRoles(id)
+	if err != nil {
+		if status, ok := roleError(err); ok {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load roles"})
+		return
+	}
+	c.JSON(http.StatusOK, h)
+}
+
+func handleAdminUserRoleGrant(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Role == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role required"})
+		return
+	}
+	if err := GrantRole(getUserID(c), id, body.Role); err != nil {
+		if status, ok := roleError(err); ok {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to grant role"})
+		return
+	}
+	handleAdminUserRoles(c)
+}
+
+func handleAdminUserRoleRevoke(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	if err := RevokeRole(getUserID(c), id, c.Param("role")); err != nil {
+		if status, ok := roleError(err); ok {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke role"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+func handleAdminProductTakedown(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || strings.TrimSpace(body.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason required"})
+		return
+	}
+	switch err := ProductTakedown(getUserID(c), id, strings.TrimSpace(body.Reason)); {
+	case errors.Is(err, ErrProductNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrProductTakedownState):
+		c.JSON(http.StatusConflict, gin.H{"error": "product is already taken down"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to take down product"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	}
+}
+
+func handleAdminProductRestore(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	switch err := ProductRestore(getUserID(c), id); {
+	case errors.Is(err, ErrProductNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrProductTakedownState):
+		c.JSON(http.StatusConflict, gin.H{"error": "product is not taken down"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore product"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	}
+}
+
+func handleAdminOrderGet(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	var pid, buyerID, sellerID, createdAt int64
+	var status, paymentStatus, title string
+	var price float64
+	var urgent int
+	var holdID sql.NullInt64
+	err := db.DB.QueryRow(
+		`SELECT o.product_id, o.buyer_id, o.seller_id, o.status, o.created_at, COALESCE(o.urgent, 0), o.payment_status, o.hold_id, p.title, p.price
+		 FROM orders o JOIN products p ON p.id = o.product_id WHERE o.id = ?`, id,
+	).Scan(&pid, &buyerID, &sellerID, &status, &createdAt, &urgent, &paymentStatus, &holdID, &title, &price)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id": id, "product_id": pid, "buyer_id": buyerID, "seller_id": sellerID,
+		"status": status, "created_at": createdAt, "urgent": urgent == 1, "title": title, "price": price,
+		"payment_status": paymentStatus, "hold_id": holdID.Int64,
+	})
+}
+
+func handleAdminUserOrders(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	rows, _ := db.DB.Query(`SELECT o.id, o.status, o.created_at, COALESCE(o.installment_plan, ''), p.title, p.price, p.image_path, u.name FROM orders o JOIN products p ON p.id = o.product_id JOIN users u ON u.id = o.seller_id WHERE o.buyer_id = ? ORDER BY o.created_at DESC LIMIT 100`, id)
+	asBuyer := rowsToOrderList(rows)
+	rows, _ = db.DB.Query(`SELECT o.id, o.status, o.created_at, COALESCE(o.installment_plan, ''), p.title, p.price, p.image_path, u.name FROM orders o JOIN products p ON p.id = o.product_id JOIN users u ON u.id = o.buyer_id WHERE o.seller_id = ? ORDER BY o.created_at DESC LIMIT 100`, id)
+	asSeller := rowsToOrderList(rows)
+	c.JSON(http.StatusOK, gin.H{"asBuyer": asBuyer, "asSeller": asSeller})
+}
+
+func handleAdminUserWallet(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	balances, err := WalletBalances(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load balances"})
+		return
+	}
+	rows, err := db.DB.Query(
+		"SELECT id, type, currency, amount, fee, status, reference_id, created_at FROM wallet_transactions WHERE user_id = ? ORDER BY created_at DESC, id DESC LIMIT 50", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load transactions"})
+		return
+	}
+	defer rows.Close()
+	txs := []gin.H{}
+	for rows.Next() {
+		var txID, amount, fee, createdAt int64
+		var txType, currency, status string
+		var refID sql.NullString
+		if rows.Scan(&txID, &txType, &currency, &amount, &fee, &status, &refID, &createdAt) != nil {
+			continue
+		}
+		txs = append(txs, gin.H{
+			"id": txID, "type": txType, "currency": currency, "amount": amount, "fee": fee,
+			"status": status, "reference_id": refID.String, "created_at": createdAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"user_id": id, "balances": balances, "transactions": txs})
+}
+
+func handleAdminWalletAdjust(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	var body struct {
+		Currency string `json:"currency"`
+		Amount   int64  `json:"amount"`
+		Reason   string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Amount == 0 || strings.TrimSpace(body.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount (non-zero, minor units) and reason required"})
+		return
+	}
+	if body.Currency == "" {
+		body.Currency = walletDefaultCurrency
+	}
+	role, _ := c.Get("userRole")
+	staffRole, _ := role.(string)
+	entryID, err := WalletAdjust(getUserID(c), staffRole, id, body.Currency, body.Amount, strings.TrimSpace(body.Reason))
+	switch {
+	case errors.Is(err, ErrWalletRecipientMissing):
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	case errors.Is(err, ErrWalletSelfAdjust):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": "self_adjust"})
+		return
+	case errors.Is(err, ErrRoleOutranked):
+		c.JSON(http.StatusForbidden, gin.H{"error": "cannot adjust an admin or an account with permissions you lack", "code": "role_outranked"})
+		return
+	case errors.Is(err, ErrWalletInsufficient), errors.Is(err, ledger.ErrInvalidAmount):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to adjust wallet"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ledger_entry_id": entryID, "balance": WalletBalance(id, body.Currency)})
+}
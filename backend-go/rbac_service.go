@@ -0,0 +1,335 @@
+// Fine-grained RBAC: roles grant named permissions and are assigned to users on top of users.role.
+// users.role = 'admin' remains the superuser and holds every permission; admin routes check one permission each.
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"omnixius-api/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	PermStatsRead        = "stats.read"
+	PermReportsRead      = "reports.read"
+	PermReportsManage    = "reports.manage"
+	PermProductsTakedown = "products.takedown"
+	PermUsersRead        = "users.read"
+	PermUsersBan         = "users.ban"
+	PermOrdersRead       = "orders.read"
+	PermWalletRead       = "wallet.read"
+	PermWalletAdjust     = "wallet.adjust"
+	PermOAuthManage      = "oauth.manage"
+)
+
+// permissionDescriptions lists every permission a role may carry, in display order.
+var permissionDescriptions = []struct{ name, description string }{
+	{PermStatsRead, "View platform statistics"},
+	{PermReportsRead, "View user reports"},
+	{PermReportsManage, "Assign and resolve user reports"},
+	{PermProductsTakedown, "Take down and restore product listings"},
+	{PermUsersRead, "View user accounts"},
+	{PermUsersBan, "Ban and unban users"},
+	{PermOrdersRead, "View any order"},
+	{PermWalletRead, "View any user's wallet"},
+	{PermWalletAdjust, "Credit or debit user wallets"},
+	{PermOAuthManage, "View and revoke OAuth grants"},
+}
+
+var roleNameRe = regexp.MustCompile(`^[a-z][a-z_]{1,31}$`)
+
+var (
+	ErrRoleNotFound          = errors.New("role not found")
+	ErrRoleNameInvalid       = errors.New("role name must be 2-32 lowercase letters or underscores")
+	ErrRoleReserved          = errors.New("user, seller and admin are account types, not roles")
+	ErrRoleBuiltin           = errors.New("builtin roles cannot be deleted")
+	ErrRolePermissionInvalid = errors.New("unknown permission")
+	ErrRoleUserNotFound      = errors.New("user not found")
+	ErrRoleNotGranted        = errors.New("user does not have this role")
+	ErrRoleOutranked         = errors.New("target account outranks you")
+)
+
+func validPermission(p string) bool {
+	for _, d := range permissionDescriptions {
+		if d.name == p {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionList returns the known permissions with descriptions.
+func PermissionList() []gin.H {
+	list := []gin.H{}
+	for _, d := range permissionDescriptions {
+		list = append(list, gin.H{"name": d.name, "description": d.description})
+	}
+	return list
+}
+
+// userPermissions returns the permissions the user holds through their roles; admins hold all of them.
+func userPermissions(userID int64, role string) []string {
+	if role == "admin" {
+		all := make([]string, 0, len(permissionDescriptions))
+		for _, d := range permissionDescriptions {
+			all = append(all, d.name)
+		}
+		return all
+	}
+	rows, err := db.DB.Query(
+		`SELECT DISTINCT rp.permission FROM user_roles ur JOIN role_permissions rp ON rp.role = ur.role
+		 WHERE ur.user_id = ? ORDER BY rp.permission`, userID)
+	if err != nil {
+		return []string{}
+	}
+	defer rows.Close()
+	perms := []string{}
+	for rows.Next() {
+		var p string
+		if rows.Scan(&p) == nil {
+			perms = append(perms, p)
+		}
+	}
+	return perms
+}
+
+// staffCanActOn reports whether a staff member may take an action (such as a ban) against target: admins may act
+// on anyone; other staff not on an admin, nor on an account holding a permission they lack (ErrRoleOutranked).
+func staffCanActOn(actorID int64, actorRole string, targetID int64) error {
+	var targetRole string
+	err := db.DB.QueryRow("SELECT role FROM users WHERE id = ?", targetID).Scan(&targetRole)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRoleUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if actorRole == "admin" {
+		return nil
+	}
+	if targetRole == "admin" {
+		return ErrRoleOutranked
+	}
+	actorPerms := userPermissions(actorID, actorRole)
+	for _, p := range userPermissions(targetID, targetRole) {
+		if !slices.Contains(actorPerms, p) {
+			return ErrRoleOutranked
+		}
+	}
+	return nil
+}
+
+// permissionRequired allows the request only if the user holds perm. Runs after authRequired; scoped tokens
+// never reach admin routes, so only session tokens are checked here.
+func permissionRequired(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var perms []string
+		if v, ok := c.Get("userPermissions"); ok {
+			perms, _ = v.([]string)
+		} else {
+			role, _ := c.Get("userRole")
+			r, _ := role.(string)
+			perms = userPermissions(getUserID(c), r)
+			c.Set("userPermissions", perms)
+		}
+		if !slices.Contains(perms, perm) {
+			c.JSON(403, gin.H{"error": "Permission required", "code": "permission_denied", "required_permission": perm})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func rolePermissions(name string) []string {
+	perms := []string{}
+	rows, err := db.DB.Query("SELECT permission FROM role_permissions WHERE role = ? ORDER BY permission", name)
+	if err != nil {
+		return perms
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p string
+		if rows.Scan(&p) == nil {
+			perms = append(perms, p)
+		}
+	}
+	return perms
+}
+
+// ListRoles returns every role with its permissions and member count.
+func ListRoles() ([]gin.H, error) {
+	rows, err := db.DB.Query(
+		`SELECT r.name, r.description, r.builtin, r.created_at, r.updated_at,
+		        (SELECT COUNT(*) FROM user_roles ur WHERE ur.role = r.name)
+		 FROM roles r ORDER BY r.builtin DESC, r.name`)
+	if err != nil {
+		return nil, err
+	}
+	type role struct {
+		name, description           string
+		builtin                     int
+		createdAt, updatedAt, users int64
+	}
+	var roles []role
+	for rows.Next() {
+		var r role
+		if rows.Scan(&r.name, &r.description, &r.builtin, &r.createdAt, &r.updatedAt, &r.users) == nil {
+			roles = append(roles, r)
+		}
+	}
+	rows.Close()
+	list := []gin.H{}
+	for _, r := range roles {
+		list = append(list, gin.H{
+			"name": r.name, "description": r.description, "builtin": r.builtin == 1,
+			"permissions": rolePermissions(r.name), "users": r.users,
+			"created_at": r.createdAt, "updated_at": r.updatedAt,
+		})
+	}
+	return list, nil
+}
+
+// PutRole creates a role or replaces its description and permissions.
+func PutRole(adminID int64, name, description string, permissions []string) (gin.H, error) {
+	name = strings.TrimSpace(name)
+	if name == "user" || name == "seller" || name == "admin" {
+		return nil, ErrRoleReserved
+	}
+	if !roleNameRe.MatchString(name) {
+		return nil, ErrRoleNameInvalid
+	}
+	var perms []string
+	for _, p := range permissions {
+		p = strings.TrimSpace(p)
+		if !validPermission(p) {
+			return nil, ErrRolePermissionInvalid
+		}
+		if !slices.Contains(perms, p) {
+			perms = append(perms, p)
+		}
+	}
+	slices.Sort(perms)
+	description = strings.TrimSpace(description)
+	if len(description) > 200 {
+		description = description[:200]
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	if _, err := tx.Exec(
+		`INSERT INTO roles (name, description, created_at, updated_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(name) DO UPDATE SET description = excluded.description, updated_at = excluded.updated_at`,
+		name, description, now, now); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM role_permissions WHERE role = ?", name); err != nil {
+		return nil, err
+	}
+	for _, p := range perms {
+		if _, err := tx.Exec("INSERT INTO role_permissions (role, permission) VALUES (?, ?)", name, p); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	auditLog(adminID, "role.updated", "role", name, strings.Join(perms, " "))
+	if perms == nil {
+		perms = []string{}
+	}
+	return gin.H{"name": name, "description": description, "permissions": perms}, nil
+}
+
+// DeleteRole removes a custom role and its assignments.
+func DeleteRole(adminID int64, name string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var builtin int
+	if tx.QueryRow("SELECT builtin FROM roles WHERE name = ?", name).Scan(&builtin) != nil {
+		return ErrRoleNotFound
+	}
+	if builtin == 1 {
+		return ErrRoleBuiltin
+	}
+	for _, q := range []string{"DELETE FROM user_roles WHERE role = ?", "DELETE FROM role_permissions WHERE role = ?", "DELETE FROM roles WHERE name = ?"} {
+		if _, err := tx.Exec(q, name); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	auditLog(adminID, "role.deleted", "role", name, "")
+	return nil
+}
+
+// UserRoles returns the roles granted to a user and the permissions they add up to.
+func UserRoles(userID int64) (gin.H, error) {
+	var role string
+	if db.DB.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role) != nil {
+		return nil, ErrRoleUserNotFound
+	}
+	rows, err := db.DB.Query("SELECT role, granted_by, created_at FROM user_roles WHERE user_id = ? ORDER BY role", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := []gin.H{}
+	for rows.Next() {
+		var name string
+		var grantedBy sql.NullInt64
+		var createdAt int64
+		if rows.Scan(&name, &grantedBy, &createdAt) != nil {
+			continue
+		}
+		roles = append(roles, gin.H{"role": name, "granted_by": nullInt64(grantedBy.Int64), "created_at": createdAt})
+	}
+	return gin.H{"user_id": userID, "account_role": role, "roles": roles, "permissions": userPermissions(userID, role)}, nil
+}
+
+// GrantRole assigns a role to a user (idempotent).
+func GrantRole(adminID, userID int64, name string) error {
+	var exists int
+	if db.DB.QueryRow("SELECT 1 FROM users WHERE id = ?", userID).Scan(&exists) != nil {
+		return ErrRoleUserNotFound
+	}
+	if db.DB.QueryRow("SELECT 1 FROM roles WHERE name = ?", name).Scan(&exists) != nil {
+		return ErrRoleNotFound
+	}
+	res, err := db.DB.Exec("INSERT OR IGNORE INTO user_roles (user_id, role, granted_by, created_at) VALUES (?, ?, ?, ?)",
+		userID, name, adminID, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if mustRows(res) > 0 {
+		auditLog(adminID, "role.granted", "user", strconv.FormatInt(userID, 10), name)
+	}
+	return nil
+}
+
+// RevokeRole removes a role from a user.
+func RevokeRole(adminID, userID int64, name string) error {
+	res, err := db.DB.Exec("DELETE FROM user_roles WHERE user_id = ? AND role = ?", userID, name)
+	if err != nil {
+		return err
+	}
+	if mustRows(res) == 0 {
+		return ErrRoleNotGranted
+	}
+	auditLog(adminID, "role.revoked", "user", strconv.FormatInt(userID, 10), name)
+	return nil
+}
@@ -0,0 +1,157 @@
+// Master recovery (§1.2): the client derives a recovery key from the BIP-39 phrase; the server keeps only an
+// Argon2id hash of it. Restoring needs the account email plus the key, and is then confirmed either by a link
+// sent to that email or by a fresh passkey sign-in.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"omnixius-api/db"
+	"omnixius-api/internal/mail"
+)
+
+const (
+	recoveryConfirmTTL      = 30 * time.Minute
+	recoveryPasskeyFresh    = 10 * time.Minute // a passkey session this new confirms a restore directly
+	recoveryAttemptsPerHour = 5
+)
+
+var (
+	ErrRecoveryInvalid        = errors.New("invalid email or recovery key")
+	ErrRecoveryConfirmInvalid = errors.New("recovery link invalid or expired")
+)
+
+// recoveryDummyHash is checked for unknown accounts so response time does not reveal whether an email exists.
+var recoveryDummyHash = sync.OnceValue(func() string { return hashPasswordArgon2("omnixius-recovery-dummy") })
+
+// RecoverySetKey stores the Argon2id hash of the client-derived recovery key, replacing any previous one.
+func RecoverySetKey(userID int64, key string) error {
+	_, err := db.DB.Exec(
+		"INSERT OR REPLACE INTO user_recovery (user_id, recovery_hash, created_at) VALUES (?, ?, ?)",
+		userID, hashPasswordArgon2(key), time.Now().Unix(),
+	)
+	return err
+}
+
+// RecoveryCheck returns the user whose email and recovery key match. Unknown email and wrong key fail the same way.
+// Hashes stored before server-side hashing (the raw client value) are upgraded on success.
+func RecoveryCheck(email, key string) (int64, error) {
+	var userID int64
+	var stored string
+	err := db.DB.QueryRow(
+		"SELECT r.user_id, r.recovery_hash FROM user_recovery r JOIN users u ON u.id = r.user_id WHERE u.email = ?",
+		strings.TrimSpace(strings.ToLower(email)),
+	).Scan(&userID, &stored)
+	if err != nil {
+		checkPassword(recoveryDummyHash(), key)
+		return 0, ErrRecoveryInvalid
+	}
+	if strings.HasPrefix(stored, "argon2id:") {
+		if !checkPassword(stored, key) {
+			return 0, ErrRecoveryInvalid
+		}
+		return userID, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(key)) != 1 {
+		return 0, ErrRecoveryInvalid
+	}
+	RecoverySetKey(userID, key)
+	return userID, nil
+}
+
+// RecoveryRequestConfirmation emails the account a link that finishes the restore (RecoveryConfirm).
+func RecoveryRequestConfirmation(userID int64, ip string) error {
+	var email string
+	if err := db.DB.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email); err != nil {
+		return err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	tok := hex.EncodeToString(b)
+	now := time.Now()
+	db.DB.Exec("DELETE FROM recovery_confirmations WHERE expires_at <= ?", now.Unix())
+	if _, err := db.DB.Exec(
+		"INSERT INTO recovery_confirmations (token_hash, user_id, ip, expires_at, created_at) VALUES (?, ?, ?, ?, ?)",
+		sha256Hex(tok), userID, nullStr(ip), now.Add(recoveryConfirmTTL).Unix(), now.Unix(),
+	); err != nil {
+		return err
+	}
+	auditLog(userID, "recovery.requested", "user_recovery", "", ip)
+	sendTemplateMailAsync(email, mail.TemplateRecovery, map[string]any{
+		"Link":      appLink("/app/recovery-confirm.html", url.Values{"token": {tok}}),
+		"IP":        ip,
+		"ExpiresIn": "30 minutes",
+	})
+	return nil
+}
+
+// RecoveryConfirm consumes an emailed recovery link and completes the restore.
+func RecoveryConfirm(token string, client ClientInfo) (int64, SessionTokens, error) {
+	var userID int64
+	err := db.DB.QueryRow("SELECT user_id FROM recovery_confirmations WHERE token_hash = ? AND expires_at > ?",
+		sha256Hex(token), time.Now().Unix()).Scan(&userID)
+	if err != nil {
+		return 0, SessionTokens{}, ErrRecoveryConfirmInvalid
+	}
+	res, err := db.DB.Exec("DELETE FROM recovery_confirmations WHERE user_id = ?", userID)
+	if err != nil || mustRows(res) == 0 {
+		return 0, SessionTokens{}, ErrRecoveryConfirmInvalid
+	}
+	tokens, err := recoveryComplete(userID, "email", client)
+	return userID, tokens, err
+}
+
+// recoveryPasskeyConfirmed reports whether sessionID is a passkey sign-in of userID made in the last recoveryPasskeyFresh.
+func recoveryPasskeyConfirmed(userID, sessionID int64) bool {
+	var n int
+	return db.DB.QueryRow(
+		"SELECT 1 FROM sessions WHERE id = ? AND user_id = ? AND device_name = 'passkey' AND created_at > ? AND expires_at > ?",
+		sessionID, userID, time.Now().Add(-recoveryPasskeyFresh).Unix(), time.Now().Unix(),
+	).Scan(&n) == nil
+}
+
+// recoveryComplete revokes every session, OAuth grant and personal access token, opens a recovery session in
+// the same transaction and notifies every device.
+func recoveryComplete(userID int64, confirmedBy string, client ClientInfo) (SessionTokens, error) {
+	if err := checkLoginAllowed(userID); err != nil {
+		return SessionTokens{}, err
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	defer tx.Rollback()
+	if _, err := revokeAllAccessTx(tx, userID, userID); err != nil {
+		return SessionTokens{}, err
+	}
+	sessionID, refresh, err := openSessionTx(tx, userID, "recovery", client)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return SessionTokens{}, err
+	}
+	DisconnectUser(userID)
+	noteLoginDevice(userID, sessionID, "recovery", client)
+	tokens, err := signSessionTokens(userID, sessionID, refresh)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	auditLog(userID, "recovery.restore", "user_recovery", "", "confirmed_by="+confirmedBy)
+	title := "Account recovered"
+	body := "Your account was restored with the master recovery key and every device was signed out. If this wasn't you, contact support."
+	for _, channel := range []string{"websocket", "email", "push"} {
+		enqueueNotification(userID, "account_recovered", channel, title, body, `{"confirmed_by":"`+confirmedBy+`","at":`+strconv.FormatInt(time.Now().Unix(), 10)+`}`)
+	}
+	return tokens, nil
+}
@@ -91,7 +91,7 @@ func SlotBook(productID, slotID string, buyerID int64) (gin.H, error) {
 	}
 	var sellerID int64
 	var isService int
-	if db.DB.QueryRow("SELECT user_id, COALESCE(is_service, 0) FROM products WHERE id = ?", pid).Scan(&sellerID, &isService) != nil {
+	if db.DB.QueryRow("SELECT user_id, COALESCE(is_service, 0) FROM products WHERE id = ? AND taken_down_at IS NULL", pid).Scan(&sellerID, &isService) != nil {
 		return nil, ErrSlotProductNotFound
 	}
 	if isService != 1 {
@@ -125,5 +125,6 @@ func SlotBook(productID, slotID string, buyerID int64) (gin.H, error) {
 	slotTime := time.Unix(slotAtUnix, 0).Format("2006-01-02 15:04")
 	msg := fmt.Sprintf("Booked your service for %s. Order #%d.", slotTime, oid)
 	MessageSend(convID, buyerID, msg)
+	publishEvent(TopicSlotBooked, buyerID, SlotBooked{SlotID: sid, ProductID: pid, OrderID: oid, BuyerID: buyerID, SellerID: sellerID, SlotAt: slotAtUnix})
 	return gin.H{"order": order, "slot_id": sid, "message": "Booked; seller notified."}, nil
 }
@@ -26,7 +26,7 @@ func Subscribe(productID string, userID int64) (gin.H, error) {
 	}
 	var sellerID int64
 	var isSub int
-	if db.DB.QueryRow("SELECT user_id, COALESCE(is_subscription, 0) FROM products WHERE id = ?", pid).Scan(&sellerID, &isSub) != nil {
+	if db.DB.QueryRow("SELECT user_id, COALESCE(is_subscription, 0) FROM products WHERE id = ? AND taken_down_at IS NULL", pid).Scan(&sellerID, &isSub) != nil {
 		return nil, ErrSubProductNotFound
 	}
 	if isSub != 1 {
@@ -44,6 +44,7 @@ func Subscribe(productID string, userID int64) (gin.H, error) {
 		return nil, err
 	}
 	sid, _ := res.LastInsertId()
+	publishEvent(TopicSubscriptionCreated, userID, SubscriptionCreated{SubscriptionID: sid, ProductID: pid, SubscriberID: userID, SellerID: sellerID})
 	return gin.H{"id": sid, "product_id": pid, "user_id": userID, "status": "active"}, nil
 }
 
@@ -44,19 +44,7 @@ func handleWalletExport(c *gin.Context) {
 	}
 	key := argon2.IDKey([]byte(body.Password), salt, 1, 64*1024, 4, 32)
 
-	var balances []gin.H
-	rows, _ := db.DB.Query("SELECT currency, amount, hold_amount, updated_at FROM wallet_balances WHERE user_id = ?", uid)
-	if rows != nil {
-		for rows.Next() {
-			var currency string
-			var amount, holdAmount int64
-			var updatedAt sql.NullInt64
-			if rows.Scan(&currency, &amount, &holdAmount, &updatedAt) == nil {
-				balances = append(balances, gin.H{"currency": currency, "amount": amount, "hold_amount": holdAmount, "updated_at": updatedAt.Int64})
-			}
-		}
-		rows.Close()
-	}
+	balances, _ := WalletBalances(uid)
 	var addresses []gin.H
 	rows2, _ := db.DB.Query("SELECT id, currency, address, network, created_at, last_used_at FROM wallet_deposit_addresses WHERE user_id = ?", uid)
 	if rows2 != nil {
@@ -0,0 +1,85 @@
+// Wallet hold sweeper (§15): releases holds past expires_at that were never captured or released.
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"omnixius-api/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+const holdSweepBatchSize = 100
+
+// startHoldExpirySweeper releases expired holds every interval.
+func startHoldExpirySweeper(interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			sweepExpiredHolds(time.Now())
+			<-t.C
+		}
+	}()
+}
+
+// sweepExpiredHolds moves up to holdSweepBatchSize active holds with expires_at <= now to expired. Returns the number released.
+// Each hold: funds back to available (ledger entry hold_expired), a hold_expired statement row, order payment_status
+// 'expired', and a WebSocket event wallet:hold_expired to the owner.
+func sweepExpiredHolds(now time.Time) int {
+	rows, err := db.DB.Query(
+		"SELECT id FROM wallet_holds WHERE status = 'active' AND expires_at <= ? ORDER BY expires_at LIMIT ?",
+		now.Unix(), holdSweepBatchSize,
+	)
+	if err != nil {
+		log.Printf("Wallet: hold sweep failed: %v", err)
+		return 0
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	released := 0
+	for _, id := range ids {
+		h, err := expireHold(id)
+		if err != nil {
+			if !errors.Is(err, ErrHoldClosed) {
+				log.Printf("Wallet: expire hold %d: %v", id, err)
+			}
+			continue
+		}
+		released++
+		payload := gin.H{"hold_id": h.ID, "currency": h.Currency, "amount": h.Amount}
+		if h.OrderID != 0 {
+			payload["order_id"] = h.OrderID
+			payload["payment_status"] = "expired"
+		}
+		BroadcastToUser(h.UserID, "wallet:hold_expired", payload)
+	}
+	return released
+}
+
+func expireHold(holdID int64) (walletHoldRow, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return walletHoldRow{}, err
+	}
+	defer tx.Rollback()
+	h, err := loadOpenHold(tx, holdID)
+	if err != nil {
+		return h, err
+	}
+	if _, err := releaseHoldTx(tx, h, holdExpired); err != nil {
+		return h, err
+	}
+	return h, tx.Commit()
+}
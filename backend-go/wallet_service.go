@@ -0,0 +1,456 @@
+// Wallet service (§15): balances, transfers and holds, all posted through the double-entry ledger.
+// wallet_transactions keeps the per-user statement; each row points at its ledger entry.
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"omnixius-api/db"
+	"omnixius-api/internal/ledger"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrWalletInsufficient     = errors.New("insufficient balance")
+	ErrWalletSelfTransfer     = errors.New("cannot transfer to self")
+	ErrWalletSelfAdjust       = errors.New("cannot adjust your own wallet")
+	ErrWalletRecipientMissing = errors.New("recipient not found")
+	ErrHoldNotFound           = errors.New("hold not found")
+	ErrHoldForbidden          = errors.New("forbidden")
+	ErrHoldClosed             = errors.New("hold already released or captured")
+	ErrHoldOrderLinked        = errors.New("hold pays an order; cancel the order to release it")
+	ErrHoldPayeeRequired      = errors.New("to_user_id required")
+	ErrHoldCaptureAmount      = errors.New("capture amount must be between 1 and the held amount")
+	ErrHoldPartialOrder       = errors.New("order holds are captured in full")
+	ErrHoldExpired            = errors.New("hold expired")
+)
+
+const (
+	walletDefaultCurrency = "USD"
+	walletHoldDefaultTTL  = 7 * 24 * time.Hour
+)
+
+func walletBalanceJSON(b ledger.UserBalance) gin.H {
+	return gin.H{
+		"currency":    b.Currency,
+		"amount":      b.Total(),
+		"hold_amount": b.Held,
+		"available":   b.Available,
+		"updated_at":  b.UpdatedAt,
+	}
+}
+
+// WalletBalances returns the user's balances per currency (minor units).
+func WalletBalances(userID int64) ([]gin.H, error) {
+	balances, err := ledger.UserBalances(db.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	list := []gin.H{}
+	for _, b := range balances {
+		list = append(list, walletBalanceJSON(b))
+	}
+	return list, nil
+}
+
+// WalletBalance returns the user's balance in one currency (zeros if none).
+func WalletBalance(userID int64, currency string) gin.H {
+	return walletBalanceJSON(ledger.UserBalanceIn(db.DB, userID, currency))
+}
+
+// walletStatement writes one wallet_transactions row for a completed ledger entry.
+func walletStatement(tx *sql.Tx, userID int64, txType, currency string, amount int64, refID string, entryID int64, now int64) error {
+	_, err := tx.Exec(
+		"INSERT INTO wallet_transactions (user_id, type, currency, amount, fee, status, reference_id, ledger_entry_id, created_at, completed_at) VALUES (?, ?, ?, ?, 0, 'completed', ?, ?, ?, ?)",
+		userID, txType, currency, amount, refID, entryID, now, now,
+	)
+	return err
+}
+
+func walletLedgerErr(err error) error {
+	if errors.Is(err, ledger.ErrInsufficientFunds) {
+		return ErrWalletInsufficient
+	}
+	return err
+}
+
+// WalletTransfer moves amount from the user's available balance to another user.
+func WalletTransfer(fromUserID, toUserID int64, currency string, amount int64) error {
+	if toUserID == fromUserID {
+		return ErrWalletSelfTransfer
+	}
+	var exists int
+	if db.DB.QueryRow("SELECT 1 FROM users WHERE id = ?", toUserID).Scan(&exists) != nil {
+		return ErrWalletRecipientMissing
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	ref := "transfer:" + strconv.FormatInt(fromUserID, 10) + ":" + strconv.FormatInt(toUserID, 10)
+	entryID, err := ledger.Transfer(tx, "transfer", ref,
+		ledger.UserMain(fromUserID, currency), ledger.UserMain(toUserID, currency), amount)
+	if err != nil {
+		return walletLedgerErr(err)
+	}
+	if err := walletStatement(tx, fromUserID, "transfer_out", currency, -amount, strconv.FormatInt(toUserID, 10), entryID, now); err != nil {
+		return err
+	}
+	if err := walletStatement(tx, toUserID, "transfer_in", currency, amount, strconv.FormatInt(fromUserID, 10), entryID, now); err != nil {
+		return err
+	}
+	ev := WalletTransferred{FromUserID: fromUserID, ToUserID: toUserID, Currency: currency, Amount: amount, LedgerEntryID: entryID}
+	if err := publishEventTx(tx, TopicWalletTransfer, fromUserID, ev); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	wakeEventRelay()
+	return nil
+}
+
+// WalletAdjust credits (amount > 0) or debits (amount < 0) a user's main balance against the adjustment
+// system account. Debits cannot take the available balance below zero. Only admins may adjust their own
+// wallet, and staff cannot adjust an account that outranks them (staffCanActOn). Returns the ledger entry id.
+func WalletAdjust(staffID int64, staffRole string, userID int64, currency string, amount int64, reason string) (int64, error) {
+	if amount == 0 {
+		return 0, ledger.ErrInvalidAmount
+	}
+	if staffID == userID && staffRole != "admin" {
+		return 0, ErrWalletSelfAdjust
+	}
+	if err := staffCanActOn(staffID, staffRole, userID); err != nil {
+		if errors.Is(err, ErrRoleUserNotFound) {
+			return 0, ErrWalletRecipientMissing
+		}
+		return 0, err
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	ref := "adjustment:" + strconv.FormatInt(staffID, 10)
+	from, to := ledger.System(ledger.CodeAdjustment, currency), ledger.UserMain(userID, currency)
+	abs := amount
+	if amount < 0 {
+		from, to, abs = to, from, -amount
+	}
+	entryID, err := ledger.Transfer(tx, "adjustment", ref, from, to, abs)
+	if err != nil {
+		return 0, walletLedgerErr(err)
+	}
+	if err := walletStatement(tx, userID, "adjustment", currency, amount, ref, entryID, time.Now().Unix()); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	auditLog(staffID, "wallet.adjusted", "user", strconv.FormatInt(userID, 10),
+		currency+" "+strconv.FormatInt(amount, 10)+" entry="+strconv.FormatInt(entryID, 10)+": "+reason)
+	return entryID, nil
+}
+
+// WalletHold reserves amount of the user's available balance until expiresAt. orderID is optional.
+func WalletHold(userID int64, orderID *int64, currency string, amount, expiresAt int64) (int64, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var order interface{}
+	if orderID != nil {
+		order = *orderID
+	}
+	holdID, err := placeHoldTx(tx, userID, order, currency, amount, expiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return holdID, tx.Commit()
+}
+
+// placeHoldTx inserts the hold row and moves amount from the user's main to hold account. The statement shows the
+// reservation as a hold row; the release, expiry or capture remainder adds it back, so rows sum to the available balance.
+func placeHoldTx(tx *sql.Tx, userID int64, orderID interface{}, currency string, amount, expiresAt int64) (int64, error) {
+	res, err := tx.Exec(
+		"INSERT INTO wallet_holds (user_id, order_id, currency, amount, expires_at) VALUES (?, ?, ?, ?, ?)",
+		userID, orderID, currency, amount, expiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	holdID, _ := res.LastInsertId()
+	ref := "hold:" + strconv.FormatInt(holdID, 10)
+	entryID, err := ledger.Transfer(tx, "hold", ref,
+		ledger.UserMain(userID, currency), ledger.UserHold(userID, currency), amount)
+	if err != nil {
+		return 0, walletLedgerErr(err)
+	}
+	if err := walletStatement(tx, userID, "hold", currency, -amount, ref, entryID, time.Now().Unix()); err != nil {
+		return 0, err
+	}
+	return holdID, nil
+}
+
+// Hold states. active is the only non-terminal state.
+const (
+	holdActive   = "active"
+	holdCaptured = "captured"
+	holdReleased = "released"
+	holdExpired  = "expired"
+)
+
+type walletHoldRow struct {
+	ID        int64
+	UserID    int64
+	Currency  string
+	Amount    int64
+	ExpiresAt int64
+	// OrderID is the order paid by this hold (orders.hold_id = ID), 0 if none. SellerID is that order's seller.
+	OrderID  int64
+	SellerID int64
+}
+
+// loadOpenHold loads an active hold with the order it pays (if any). Other states return ErrHoldClosed.
+func loadOpenHold(tx *sql.Tx, holdID int64) (walletHoldRow, error) {
+	h := walletHoldRow{ID: holdID}
+	var status string
+	var orderID, sellerID sql.NullInt64
+	err := tx.QueryRow(
+		`SELECT h.user_id, h.currency, h.amount, h.expires_at, h.status, o.id, o.seller_id
+		 FROM wallet_holds h LEFT JOIN orders o ON o.hold_id = h.id WHERE h.id = ?`,
+		holdID,
+	).Scan(&h.UserID, &h.Currency, &h.Amount, &h.ExpiresAt, &status, &orderID, &sellerID)
+	if err == sql.ErrNoRows {
+		return h, ErrHoldNotFound
+	}
+	if err != nil {
+		return h, err
+	}
+	if status != holdActive {
+		return h, ErrHoldClosed
+	}
+	h.OrderID, h.SellerID = orderID.Int64, sellerID.Int64
+	return h, nil
+}
+
+// closeHoldTx moves an active hold to a terminal state. The status guard makes a concurrent second close fail with ErrHoldClosed.
+func closeHoldTx(tx *sql.Tx, holdID int64, set string, args ...interface{}) error {
+	res, err := tx.Exec("UPDATE wallet_holds SET "+set+" WHERE id = ? AND status = 'active'", append(args, holdID)...)
+	if err != nil {
+		return err
+	}
+	if mustRows(res) == 0 {
+		return ErrHoldClosed
+	}
+	return nil
+}
+
+// WalletHoldRelease returns the held funds to the owner's available balance.
+// Holds that pay an order are released by cancelling the order (ErrHoldOrderLinked).
+func WalletHoldRelease(userID, holdID int64) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	h, err := loadOpenHold(tx, holdID)
+	if err != nil {
+		return err
+	}
+	if h.UserID != userID {
+		return ErrHoldForbidden
+	}
+	if h.OrderID != 0 {
+		return ErrHoldOrderLinked
+	}
+	if _, err := releaseHoldTx(tx, h, holdReleased); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// WalletHoldCapture pays amount of the held funds (buyer confirming delivery); amount 0 captures the whole hold and a
+// smaller amount releases the remainder to the owner. A hold that pays an order always goes to orders.seller_id and is
+// captured in full; toUserID is only used for holds without an order and must then be set.
+func WalletHoldCapture(userID, holdID, toUserID, amount int64) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	h, err := loadOpenHold(tx, holdID)
+	if err != nil {
+		return err
+	}
+	if h.UserID != userID {
+		return ErrHoldForbidden
+	}
+	if h.OrderID != 0 {
+		if amount != 0 && amount != h.Amount {
+			return ErrHoldPartialOrder
+		}
+		toUserID = h.SellerID
+	}
+	if toUserID <= 0 {
+		return ErrHoldPayeeRequired
+	}
+	if amount == 0 {
+		amount = h.Amount
+	}
+	if amount < 0 || amount > h.Amount {
+		return ErrHoldCaptureAmount
+	}
+	if err := captureHoldTx(tx, h, toUserID, amount); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// releaseHoldTx moves an active hold to released or expired (state) and the funds back to the owner's main account,
+// posted as hold_release / hold_expired with a statement row of that type. Returns the ledger entry ID. If the hold pays an
+// order, its payment_status becomes state.
+func releaseHoldTx(tx *sql.Tx, h walletHoldRow, state string) (int64, error) {
+	if err := closeHoldTx(tx, h.ID, "status = ?, released_at = ?", state, time.Now().Unix()); err != nil {
+		return 0, err
+	}
+	entryType := "hold_release"
+	if state == holdExpired {
+		entryType = "hold_expired"
+	}
+	ref := "hold:" + strconv.FormatInt(h.ID, 10)
+	entryID, err := ledger.Transfer(tx, entryType, ref,
+		ledger.UserHold(h.UserID, h.Currency), ledger.UserMain(h.UserID, h.Currency), h.Amount)
+	if err != nil {
+		return 0, walletLedgerErr(err)
+	}
+	if err := walletStatement(tx, h.UserID, entryType, h.Currency, h.Amount, ref, entryID, time.Now().Unix()); err != nil {
+		return 0, err
+	}
+	return entryID, setOrderPaymentStatusTx(tx, h.OrderID, state)
+}
+
+// captureHoldTx moves an active hold to captured: amount is paid to toUserID, the rest (if any) returns to the owner with
+// a hold_release statement row (the owner's hold row already shows the payment).
+// Holds past expires_at cannot be captured (ErrHoldExpired). If the hold pays an order, its payment_status becomes 'captured'.
+func captureHoldTx(tx *sql.Tx, h walletHoldRow, toUserID, amount int64) error {
+	now := time.Now().Unix()
+	if h.ExpiresAt <= now {
+		return ErrHoldExpired
+	}
+	remainder := h.Amount - amount
+	var releasedAt interface{}
+	if remainder > 0 {
+		releasedAt = now
+	}
+	if err := closeHoldTx(tx, h.ID, "status = 'captured', captured_at = ?, captured_amount = ?, captured_to = ?, released_at = ?",
+		now, amount, toUserID, releasedAt); err != nil {
+		return err
+	}
+	ref := "hold:" + strconv.FormatInt(h.ID, 10)
+	entryID, err := ledger.Transfer(tx, "hold_capture", ref,
+		ledger.UserHold(h.UserID, h.Currency), ledger.UserMain(toUserID, h.Currency), amount)
+	if err != nil {
+		return walletLedgerErr(err)
+	}
+	if err := walletStatement(tx, toUserID, "payment", h.Currency, amount, ref, entryID, now); err != nil {
+		return err
+	}
+	if remainder > 0 {
+		releaseID, err := ledger.Transfer(tx, "hold_release", ref,
+			ledger.UserHold(h.UserID, h.Currency), ledger.UserMain(h.UserID, h.Currency), remainder)
+		if err != nil {
+			return walletLedgerErr(err)
+		}
+		if err := walletStatement(tx, h.UserID, "hold_release", h.Currency, remainder, ref, releaseID, now); err != nil {
+			return err
+		}
+	}
+	return setOrderPaymentStatusTx(tx, h.OrderID, holdCaptured)
+}
+
+func setOrderPaymentStatusTx(tx *sql.Tx, orderID int64, status string) error {
+	if orderID == 0 {
+		return nil
+	}
+	_, err := tx.Exec("UPDATE orders SET payment_status = ?, updated_at = unixepoch() WHERE id = ?", status, orderID)
+	return err
+}
+
+const walletHoldColumns = `id, order_id, currency, amount, status, captured_amount, captured_to, expires_at, created_at, captured_at, released_at`
+
+func scanWalletHold(scan func(dest ...interface{}) error) (gin.H, error) {
+	var id, amount, capturedAmount, expiresAt int64
+	var currency, status string
+	var orderID, capturedTo, createdAt, capturedAt, releasedAt sql.NullInt64
+	if err := scan(&id, &orderID, &currency, &amount, &status, &capturedAmount, &capturedTo, &expiresAt, &createdAt, &capturedAt, &releasedAt); err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"id": id, "order_id": orderID.Int64, "currency": currency, "amount": amount, "status": status,
+		"captured_amount": capturedAmount, "captured_to": capturedTo.Int64, "expires_at": expiresAt,
+		"created_at": createdAt.Int64, "captured_at": capturedAt.Int64, "released_at": releasedAt.Int64,
+	}, nil
+}
+
+// WalletHolds lists the user's holds, newest first. states filters by status (empty = all).
+func WalletHolds(userID int64, states []string, limit, offset int) ([]gin.H, error) {
+	query := "SELECT " + walletHoldColumns + " FROM wallet_holds WHERE user_id = ?"
+	args := []interface{}{userID}
+	if len(states) > 0 {
+		query += " AND status IN (?" + strings.Repeat(", ?", len(states)-1) + ")"
+		for _, st := range states {
+			args = append(args, st)
+		}
+	}
+	query += " ORDER BY id DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []gin.H{}
+	for rows.Next() {
+		h, err := scanWalletHold(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, h)
+	}
+	return list, rows.Err()
+}
+
+// WalletHoldGet returns one of the user's holds.
+func WalletHoldGet(userID, holdID int64) (gin.H, error) {
+	h, err := scanWalletHold(db.DB.QueryRow("SELECT "+walletHoldColumns+" FROM wallet_holds WHERE id = ? AND user_id = ?", holdID, userID).Scan)
+	if err == sql.ErrNoRows {
+		return nil, ErrHoldNotFound
+	}
+	return h, err
+}
+
+// parseHoldStates parses a comma-separated status filter. ok is false for unknown states.
+func parseHoldStates(s string) (states []string, ok bool) {
+	if s == "" {
+		return nil, true
+	}
+	for _, st := range strings.Split(s, ",") {
+		st = strings.TrimSpace(st)
+		switch st {
+		case holdActive, holdCaptured, holdReleased, holdExpired:
+			states = append(states, st)
+		default:
+			return nil, false
+		}
+	}
+	return states, true
+}
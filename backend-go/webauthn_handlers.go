@@ -5,12 +5,14 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"omnixius-api/db"
-	"omnixius-api/pqc"
+	"omnixius-api/internal/useragent"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-webauthn/webauthn/protocol"
@@ -80,17 +82,21 @@ func loadWebAuthnCredentials(userID int64) ([]webauthn.Credential, error) {
 	return creds, nil
 }
 
-func saveWebAuthnCredential(userID int64, cred *webauthn.Credential) error {
+func saveWebAuthnCredential(userID int64, cred *webauthn.Credential, name string) (int64, error) {
 	raw, err := json.Marshal(cred)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	idB64 := base64.RawURLEncoding.EncodeToString(cred.ID)
-	_, err = db.DB.Exec(
-		"INSERT INTO webauthn_credentials (user_id, credential_id_base64, credential_json, updated_at) VALUES (?, ?, ?, ?)",
-		userID, idB64, string(raw), time.Now().Unix(),
+	now := time.Now().Unix()
+	res, err := db.DB.Exec(
+		"INSERT INTO webauthn_credentials (user_id, credential_id_base64, credential_json, name, aaguid, created_at, last_used_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, idB64, string(raw), nullStr(name), nullStr(formatAAGUID(cred.Authenticator.AAGUID)), now, now, now,
 	)
-	return err
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
 }
 
 func webauthnSaveSession(session *webauthn.SessionData) (string, error) {
@@ -149,7 +155,7 @@ func handlePasskeyRegisterBegin(c *gin.Context) {
 	// Create user with placeholder password (passkey-only account)
 	hash := hashPasswordArgon2(string(uuid.New().String())) // unguessable
 	res, err := db.DB.Exec(
-		"INSERT INTO users (email, password_hash, name, role) VALUES (?, ?, ?, 'user')",
+		"INSERT INTO users (email, password_hash, password_set, name, role) VALUES (?, ?, 0, ?, 'user')",
 		email, hash, nullStr(body.Name),
 	)
 	if err != nil {
@@ -211,16 +217,20 @@ func handlePasskeyRegisterComplete(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "registration failed: " + err.Error()})
 		return
 	}
-	if err := saveWebAuthnCredential(userID, cred); err != nil {
+	client := clientInfo(c)
+	client.CredentialID, err = saveWebAuthnCredential(userID, cred, useragent.Parse(client.UserAgent).Label())
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save credential"})
 		return
 	}
-	authSid, authExp := createSession(userID, "passkey")
-	token, _ := pqc.SignTokenWithSession(cfg.PQCPrivateKey, userID, authSid, authExp)
-	c.JSON(http.StatusOK, gin.H{
-		"user":  gin.H{"id": userID, "email": email, "role": "user", "name": name},
-		"token": token,
-	})
+	tokens, err := issueSession(userID, "passkey", client)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create session"})
+		return
+	}
+	c.JSON(http.StatusOK, withTokens(gin.H{
+		"user": gin.H{"id": userID, "email": email, "role": "user", "name": name},
+	}, tokens))
 }
 
 func handlePasskeyLoginBegin(c *gin.Context) {
@@ -303,18 +313,101 @@ func handlePasskeyLoginComplete(c *gin.Context) {
 	}
 	creds, _ := loadWebAuthnCredentials(userID)
 	u := webauthnUser{id: userID, email: email, displayName: name.String, credentials: creds}
-	_, err = webauthnInstance.FinishLogin(u, *session, c.Request)
+	cred, err := webauthnInstance.FinishLogin(u, *session, c.Request)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "login failed: " + err.Error()})
 		return
 	}
-	authSid, authExp := createSession(userID, "passkey")
-	token, _ := pqc.SignTokenWithSession(cfg.PQCPrivateKey, userID, authSid, authExp)
-	c.JSON(http.StatusOK, gin.H{
+	client := clientInfo(c)
+	client.CredentialID, err = passkeyLoginUsed(userID, cred)
+	if errors.Is(err, ErrPasskeyCloned) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": "passkey_cloned"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login failed"})
+		return
+	}
+	if err := checkLoginAllowed(userID); err != nil {
+		if h := loginRefusedJSON(err); h != nil {
+			c.JSON(http.StatusForbidden, h)
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "login failed"})
+		}
+		return
+	}
+	tokens, err := issueSession(userID, "passkey", client)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create session"})
+		return
+	}
+	c.JSON(http.StatusOK, withTokens(gin.H{
 		"user": gin.H{
 			"id": userID, "email": email, "role": role, "name": name.String,
 			"avatar_path": avatar.String,
 		},
-		"token": token,
-	})
+	}, tokens))
+}
+
+// passkeyError maps passkey management errors to a status; ok is false for unexpected errors.
+func passkeyError(err error) (int, bool) {
+	switch {
+	case errors.Is(err, ErrPasskeyNotFound):
+		return http.StatusNotFound, true
+	case errors.Is(err, ErrPasskeyNameInvalid):
+		return http.StatusBadRequest, true
+	case errors.Is(err, ErrLastAuthMethod):
+		return http.StatusConflict, true
+	}
+	return 0, false
+}
+
+func handlePasskeysList(c *gin.Context) {
+	list, err := ListPasskeys(getUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list passkeys"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"passkeys": list})
+}
+
+func handlePasskeyRename(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name required"})
+		return
+	}
+	if err := RenamePasskey(getUserID(c), id, body.Name); err != nil {
+		if status, ok := passkeyError(err); ok {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "rename failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+func handlePasskeyDelete(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if err := DeletePasskey(getUserID(c), id); err != nil {
+		if status, ok := passkeyError(err); ok {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
@@ -16,6 +16,7 @@ var upgrader = websocket.Upgrader{
 type wsClient struct {
 	userID int64
 	send   chan []byte
+	conn   *websocket.Conn
 }
 
 type wsBroadcast struct {
@@ -101,6 +102,20 @@ func BroadcastToUser(userID int64, event string, payload interface{}) {
 	}
 }
 
+// DisconnectUser closes all open WebSocket connections of the user (e.g. after a ban).
+func DisconnectUser(userID int64) {
+	if globalWSHub == nil {
+		return
+	}
+	globalWSHub.mu.RLock()
+	defer globalWSHub.mu.RUnlock()
+	for cl := range globalWSHub.byUser[userID] {
+		if cl.conn != nil {
+			cl.conn.Close()
+		}
+	}
+}
+
 func handleWS(c *gin.Context) {
 	uid := getUserID(c)
 	if uid == 0 {
@@ -111,7 +126,7 @@ func handleWS(c *gin.Context) {
 	if err != nil {
 		return
 	}
-	client := &wsClient{userID: uid, send: make(chan []byte, 64)}
+	client := &wsClient{userID: uid, send: make(chan []byte, 64), conn: conn}
 	globalWSHub.register <- client
 	defer func() { globalWSHub.unregister <- client }()
 	go func() {
@@ -1,23 +1,42 @@
 import { API_URL } from './config';
 
 const TOKEN_KEY = 'omnixius_token';
+const REFRESH_KEY = 'omnixius_refresh_token';
 const USER_KEY = 'omnixius_user';
 
 export function getToken(): string | null {
   return sessionStorage.getItem(TOKEN_KEY) || localStorage.getItem(TOKEN_KEY);
 }
 
-export function setToken(token: string | null, persistent = true): void {
+export function getRefreshToken(): string | null {
+  return sessionStorage.getItem(REFRESH_KEY) || localStorage.getItem(REFRESH_KEY);
+}
+
+export function setToken(token: string | null, persistent = true, refreshToken: string | null = null): void {
   sessionStorage.removeItem(TOKEN_KEY);
   localStorage.removeItem(TOKEN_KEY);
+  sessionStorage.removeItem(REFRESH_KEY);
+  localStorage.removeItem(REFRESH_KEY);
   if (token) {
     const storage = persistent ? localStorage : sessionStorage;
     storage.setItem(TOKEN_KEY, token);
+    if (refreshToken) storage.setItem(REFRESH_KEY, refreshToken);
   }
   sessionStorage.removeItem(USER_KEY);
   localStorage.removeItem(USER_KEY);
 }
 
+// storeTokens swaps in a rotated token pair without touching the cached user.
+function storeTokens(token: string, refreshToken: string): void {
+  const storage = sessionStorage.getItem(TOKEN_KEY) ? sessionStorage : localStorage;
+  sessionStorage.removeItem(TOKEN_KEY);
+  localStorage.removeItem(TOKEN_KEY);
+  sessionStorage.removeItem(REFRESH_KEY);
+  localStorage.removeItem(REFRESH_KEY);
+  storage.setItem(TOKEN_KEY, token);
+  storage.setItem(REFRESH_KEY, refreshToken);
+}
+
 export function getUser(): { id: number; email?: string; name?: string; role?: string } | null {
   try {
     const raw = sessionStorage.getItem(USER_KEY) || localStorage.getItem(USER_KEY);
@@ -42,7 +61,40 @@ export interface ApiError {
 
 type RequestOptions = Omit<RequestInit, 'body'> & { body?: Record<string, unknown> | FormData };
 
-async function request<T>(path: string, options: RequestOptions = {}): Promise<T> {
+let refreshing: Promise<boolean> | null = null;
+
+// refreshSession trades the stored refresh token for a new pair. Concurrent
+// 401s share one call because the server rotates (and burns) the old token.
+function refreshSession(base: string): Promise<boolean> {
+  if (!refreshing) {
+    const refreshToken = getRefreshToken();
+    refreshing = (async () => {
+      if (!refreshToken) return false;
+      try {
+        const res = await fetch(base + '/api/auth/refresh', {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ refresh_token: refreshToken }),
+        });
+        if (!res.ok) {
+          setToken(null);
+          return false;
+        }
+        const data = (await res.json()) as { token?: string; refresh_token?: string };
+        if (!data.token || !data.refresh_token) return false;
+        storeTokens(data.token, data.refresh_token);
+        return true;
+      } catch {
+        return false;
+      }
+    })().finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+}
+
+async function request<T>(path: string, options: RequestOptions = {}, retried = false): Promise<T> {
   const base = API_URL;
   if (!base && typeof window !== 'undefined') {
     const err = new Error('API URL not set') as Error & ApiError;
@@ -50,7 +102,8 @@ async function request<T>(path: string, options: RequestOptions = {}): Promise<T
     err.data = { error: 'Set VITE_API_URL in .env or window.__OMNIXIUS_API_URL__' };
     throw err;
   }
-  const url = (base || 'http://localhost:3000') + path;
+  const origin = base || 'http://localhost:3000';
+  const url = origin + path;
   const headers: HeadersInit = {
     ...(options.body instanceof FormData ? {} : { 'Content-Type': 'application/json' }),
     ...(getToken() ? { Authorization: `Bearer ${getToken()}` } : {}),
@@ -71,6 +124,9 @@ async function request<T>(path: string, options: RequestOptions = {}): Promise<T
   } catch {
     data = null;
   }
+  if (res.status === 401 && !retried && getRefreshToken() && (await refreshSession(origin))) {
+    return request<T>(path, options, true);
+  }
   if (!res.ok) {
     const e: ApiError = { status: res.status, data: (data as { error?: string }) || { error: text } };
     throw e;
@@ -80,18 +136,19 @@ async function request<T>(path: string, options: RequestOptions = {}): Promise<T
 
 export const api = {
   getToken,
+  getRefreshToken,
   setToken,
   get user() { return getUser(); },
   set user(u) { setUser(u); },
   request: request as <T>(path: string, options?: RequestOptions) => Promise<T>,
   auth: {
     login: (email: string, password: string) =>
-      request<{ token: string; user: { id: number; email?: string; name?: string; role?: string } }>(
+      request<{ token: string; refresh_token?: string; user: { id: number; email?: string; name?: string; role?: string } }>(
         '/api/auth/login',
         { method: 'POST', body: { email, password } }
       ),
     register: (email: string, password: string, name: string) =>
-      request<{ token: string; user: unknown }>('/api/auth/register', { method: 'POST', body: { email, password, name } }),
+      request<{ token: string; refresh_token?: string; user: unknown }>('/api/auth/register', { method: 'POST', body: { email, password, name } }),
     logout: () => { setToken(null); setUser(null); },
     forgotPassword: (email: string) =>
       request<unknown>('/api/auth/forgot-password', { method: 'POST', body: { email } }),
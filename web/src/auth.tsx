@@ -53,7 +53,7 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
   const login = useCallback(
     async (email: string, password: string, remember = true) => {
       const res = await api.auth.login(email, password);
-      setToken(res.token, remember);
+      setToken(res.token, remember, res.refresh_token ?? null);
       setUser(res.user);
       setTokenState(res.token);
       setUserState(res.user);